@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+	"github.com/gin-gonic/gin"
+)
+
+// academicCalendarVersion is the version of the academic calendar file format
+// supported by loadAcademicCalendar.
+const academicCalendarVersion = 1
+
+// academicCategory is the key of the category of the academic calendar events
+const academicCategory = "cal.category.academic"
+
+// Kinds of the events of the academic calendar
+const (
+	academicHoliday     = "holiday"
+	academicBreak       = "break"
+	academicExamSession = "exam_session"
+)
+
+// academicKindLabels are the keys of the labels of the kinds of events
+var academicKindLabels = map[string]string{
+	academicHoliday:     "academic.holiday",
+	academicBreak:       "academic.break",
+	academicExamSession: "academic.exam_session",
+}
+
+//go:embed resources/academic_calendar.json
+var academicCalendarData []byte
+
+// academicCal is the academic calendar embedded in the executable.
+var academicCal = mustLoadAcademicCalendar(academicCalendarData)
+
+// academicDate is a date without time, encoded as "2006-01-02".
+type academicDate struct {
+	time.Time
+}
+
+func (d *academicDate) UnmarshalJSON(b []byte) error {
+	var s string
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return err
+	}
+
+	t, err := time.Parse(dateLayout, s)
+	if err != nil {
+		return fmt.Errorf("invalid date %q: %w", s, err)
+	}
+
+	d.Time = t
+	return nil
+}
+
+// academicEvent is an event of the academic calendar. If Campus is empty
+// the event applies to every campus. If End is missing, the event lasts one day.
+type academicEvent struct {
+	Title  string       `json:"title"`
+	Kind   string       `json:"kind"`
+	Campus string       `json:"campus"`
+	Start  academicDate `json:"start"`
+	End    academicDate `json:"end"`
+}
+
+type academicYear struct {
+	AcademicYear string          `json:"academic_year"`
+	Start        academicDate    `json:"start"`
+	End          academicDate    `json:"end"`
+	Events       []academicEvent `json:"events"`
+}
+
+// academicCalendar contains holidays, exam sessions and teaching breaks of
+// the university, curated by hand in resources/academic_calendar.json.
+type academicCalendar struct {
+	Version  int            `json:"version"`
+	Revision string         `json:"revision"`
+	Years    []academicYear `json:"years"`
+}
+
+// loadAcademicCalendar reads and validates an academic calendar.
+func loadAcademicCalendar(r io.Reader) (academicCalendar, error) {
+	var cal academicCalendar
+	err := json.NewDecoder(r).Decode(&cal)
+	if err != nil {
+		return academicCalendar{}, fmt.Errorf("unable to decode academic calendar: %w", err)
+	}
+
+	if cal.Version != academicCalendarVersion {
+		return academicCalendar{}, fmt.Errorf("unsupported academic calendar version %d", cal.Version)
+	}
+
+	seen := make(map[string]struct{})
+	for i := range cal.Years {
+		year := &cal.Years[i]
+
+		if _, ok := seen[year.AcademicYear]; ok {
+			return academicCalendar{}, fmt.Errorf("duplicated academic year %s", year.AcademicYear)
+		}
+		seen[year.AcademicYear] = struct{}{}
+
+		err = year.validate()
+		if err != nil {
+			return academicCalendar{}, fmt.Errorf("academic year %s: %w", year.AcademicYear, err)
+		}
+	}
+
+	return cal, nil
+}
+
+func mustLoadAcademicCalendar(data []byte) academicCalendar {
+	cal, err := loadAcademicCalendar(bytes.NewReader(data))
+	if err != nil {
+		panic(err)
+	}
+	return cal
+}
+
+// validate checks that the academic year is written as "2025/2026", that its
+// dates belong to those years and that every event is inside the academic year.
+// Events without an end date are set to last one day.
+func (y *academicYear) validate() error {
+	first, second, found := strings.Cut(y.AcademicYear, "/")
+	if !found {
+		return fmt.Errorf("invalid academic year")
+	}
+
+	firstYear, err := strconv.Atoi(first)
+	if err != nil {
+		return fmt.Errorf("invalid academic year")
+	}
+
+	secondYear, err := strconv.Atoi(second)
+	if err != nil || secondYear != firstYear+1 {
+		return fmt.Errorf("invalid academic year")
+	}
+
+	if y.Start.IsZero() || y.End.IsZero() {
+		return fmt.Errorf("missing start or end")
+	}
+	if y.Start.Year() != firstYear || y.End.Year() != secondYear {
+		return fmt.Errorf("start and end must be in %d and %d", firstYear, secondYear)
+	}
+
+	for i := range y.Events {
+		e := &y.Events[i]
+
+		if e.Title == "" {
+			return fmt.Errorf("event %d has no title", i)
+		}
+		if _, ok := academicKindLabels[e.Kind]; !ok {
+			return fmt.Errorf("event %q has invalid kind %q", e.Title, e.Kind)
+		}
+		if e.Start.IsZero() {
+			return fmt.Errorf("event %q has no start", e.Title)
+		}
+		if e.End.IsZero() {
+			e.End = e.Start
+		}
+		if e.End.Before(e.Start.Time) {
+			return fmt.Errorf("event %q ends before it starts", e.Title)
+		}
+		if e.Start.Before(y.Start.Time) || e.End.After(y.End.Time) {
+			return fmt.Errorf("event %q is outside of the academic year", e.Title)
+		}
+	}
+
+	return nil
+}
+
+// events returns the events of the academic calendar for the given campus. If
+// campus is empty, only the events common to every campus are returned.
+func (c academicCalendar) events(campus string) []academicEvent {
+	events := make([]academicEvent, 0)
+	for _, y := range c.Years {
+		for _, e := range y.Events {
+			if e.Campus == "" || (campus != "" && sameCampus(e.Campus, campus)) {
+				events = append(events, e)
+			}
+		}
+	}
+	return events
+}
+
+// addAcademicEvents adds the events of the academic calendar for the given
+// campus to cal, as all-day events. The titles of the events are not
+// translated, as they are written in the academic calendar file.
+func addAcademicEvents(cal *ics.Calendar, academic academicCalendar, campus, lang string) error {
+	for _, event := range academic.events(campus) {
+		sha := sha1.New()
+		_, err := fmt.Fprintf(sha, "academic%s%s%s", event.Title, event.Campus, event.Start.Format(dateLayout))
+		if err != nil {
+			return err
+		}
+
+		e := cal.AddEvent(fmt.Sprintf("%x", sha.Sum(nil)))
+		e.AddCategory(tr(lang, academicCategory))
+		e.SetSummary(event.Title)
+		e.SetAllDayStartAt(event.Start.Time)
+		// The end date of all-day events is exclusive
+		e.SetAllDayEndAt(event.End.AddDate(0, 0, 1))
+		e.SetDtStampTime(time.Now())
+
+		description := tr(lang, academicKindLabels[event.Kind])
+		if event.Kind != academicHoliday {
+			description += "\n" + tr(lang, "academic.indicative")
+		}
+		e.SetDescription(description)
+	}
+
+	return nil
+}
+
+func getAcademicCal() func(c *gin.Context) {
+	return func(ctx *gin.Context) {
+		cal, err := academicFeed(ctx)
+		sendCalendar(ctx, cal, err)
+	}
+}
+
+// academicFeed returns the academic calendar of a campus.
+func academicFeed(q feedParams) (*bytes.Buffer, error) {
+	campus := q.Query("campus")
+	lang := feedLang(q)
+
+	cacheKey := fmt.Sprintf("academic-%s-%s", strings.ToLower(campus), lang)
+	return cachedCalendar(calcache, cacheKey, func() (*ics.Calendar, error) {
+		cal := ics.NewCalendar()
+		cal.SetMethod(ics.MethodRequest)
+
+		err := addAcademicEvents(cal, academicCal, campus, lang)
+		if err != nil {
+			return nil, &calError{http.StatusInternalServerError, "Unable to create calendar", err}
+		}
+
+		cal.SetName(tr(lang, "cal.academic.name"))
+		cal.SetDescription(tr(lang, "cal.academic.description"))
+		return cal, nil
+	})
+}
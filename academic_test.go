@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+)
+
+func Test_loadAcademicCalendar(t *testing.T) {
+	// The embedded calendar is loaded at startup, so it must be valid
+	assert.NotEqual(t, 0, len(academicCal.Years))
+
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"version", `{"version": 2, "years": []}`},
+		{"academic year", `{"version": 1, "years": [{"academic_year": "2025/2027", "start": "2025-09-01", "end": "2027-08-31"}]}`},
+		{"year dates", `{"version": 1, "years": [{"academic_year": "2025/2026", "start": "2024-09-01", "end": "2026-08-31"}]}`},
+		{"date", `{"version": 1, "years": [{"academic_year": "2025/2026", "start": "2025-09-31", "end": "2026-08-31"}]}`},
+		{"kind", `{"version": 1, "years": [{"academic_year": "2025/2026", "start": "2025-09-01", "end": "2026-08-31",
+			"events": [{"title": "Natale", "kind": "party", "start": "2025-12-25"}]}]}`},
+		{"outside", `{"version": 1, "years": [{"academic_year": "2025/2026", "start": "2025-09-01", "end": "2026-08-31",
+			"events": [{"title": "Natale", "kind": "holiday", "start": "2026-12-25"}]}]}`},
+		{"end before start", `{"version": 1, "years": [{"academic_year": "2025/2026", "start": "2025-09-01", "end": "2026-08-31",
+			"events": [{"title": "Natale", "kind": "break", "start": "2025-12-25", "end": "2025-12-20"}]}]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadAcademicCalendar(strings.NewReader(tt.data))
+			assert.NotEqual(t, nil, err)
+		})
+	}
+}
+
+func Test_academicCalendar_events(t *testing.T) {
+	cal, err := loadAcademicCalendar(strings.NewReader(`{"version": 1, "years": [{
+		"academic_year": "2025/2026", "start": "2025-09-01", "end": "2026-08-31",
+		"events": [
+			{"title": "San Petronio", "kind": "holiday", "campus": "Bologna", "start": "2025-10-04"},
+			{"title": "Madonna del Fuoco", "kind": "holiday", "campus": "Forlì", "start": "2026-02-04"},
+			{"title": "Natale", "kind": "holiday", "start": "2025-12-25"}
+		]}]}`))
+	assert.Equal(t, nil, err)
+
+	assert.Equal(t, 1, len(cal.events("")))
+	assert.Equal(t, 2, len(cal.events("BOLOGNA")))
+	assert.Equal(t, "Madonna del Fuoco", cal.events("Forli")[0].Title)
+	assert.Equal(t, cal.events("Forli")[0].Start, cal.events("Forli")[0].End)
+}
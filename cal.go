@@ -3,6 +3,8 @@ package main
 import (
 	"crypto/sha1"
 	"fmt"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -14,6 +16,13 @@ import (
 	"github.com/VaiTon/unibocalendar/unibo_integ"
 )
 
+// Categories used to tell lessons and exams apart when they are in the same
+// calendar, as keys of the catalogues
+const (
+	lessonCategory = "cal.category.lesson"
+	examCategory   = "cal.category.exam"
+)
+
 // createCourseCal creates a calendar from the given timetable.
 //
 // If subjectCodes is not nil, it will be used to filter the timetable by subjects.
@@ -22,6 +31,7 @@ func createCourseCal(
 	course *unibo_integ.Course,
 	year int,
 	subjectCodes []string,
+	opts lessonOptions,
 ) (*ics.Calendar, error) {
 
 	// Filter timetable by subjects
@@ -33,78 +43,303 @@ func createCourseCal(
 	cal.SetMethod(ics.MethodRequest)
 
 	for _, event := range timetable {
-		sha := sha1.New()
-		_, err := fmt.Fprintf(sha, "%s%s%s", event.CodModulo, event.Start, event.End)
+		err := addLessonEvent(cal, event, opts)
 		if err != nil {
 			return nil, err
 		}
+	}
 
-		eventUid := fmt.Sprintf("%x", sha.Sum(nil))
+	cal.SetName(tr(opts.Lang, "cal.course.name", course.Descrizione, year))
+	cal.SetDescription(tr(opts.Lang, "cal.course.description", year, course.Descrizione))
 
-		e := cal.AddEvent(eventUid)
-		e.SetOrganizer(event.Teacher)
-		e.SetSummary(event.Title)
-		e.SetStartAt(event.Start.Time)
-		e.SetEndAt(event.End.Time)
+	return cal, nil
+}
 
-		e.SetDtStampTime(time.Now()) // https://www.kanzaki.com/docs/ical/dtstamp.html
+// lessonUid returns a stable identifier for the given lesson, so that the same
+// lesson gets the same UID even if it is included in different calendars.
+func lessonUid(event timetable.Event) (string, error) {
+	sha := sha1.New()
+	_, err := fmt.Fprintf(sha, "%s%s%s", event.CodModulo, event.Start, event.End)
+	if err != nil {
+		return "", err
+	}
 
-		b := strings.Builder{}
-		b.WriteString(fmt.Sprintf("Docente: %s\n", event.Teacher))
-		if len(event.Classrooms) > 0 {
-			classroom := event.Classrooms[0]
-			b.WriteString(fmt.Sprintf("Aula: %s\n", classroom.ResourceDesc))
-			e.SetLocation(classroom.ResourceDesc)
-		}
-		b.WriteString(fmt.Sprintf("Cfu: %d\n", event.Cfu))
-		b.WriteString(fmt.Sprintf("Periodo: %s\n", event.Interval))
-		b.WriteString(fmt.Sprintf("Codice modulo: %s\n", event.CodModulo))
+	return fmt.Sprintf("%x", sha.Sum(nil)), nil
+}
 
-		e.SetDescription(b.String())
+// lessonOptions tells how lessons are written in calendars.
+type lessonOptions struct {
+	Lang     string
+	Location locationMode
+	Format   eventFormat
+}
+
+// key returns a string representation of the options, to be used in cache keys.
+func (o lessonOptions) key() string {
+	return o.Lang + "-" + string(o.Location) + "-" + o.Format.key
+}
+
+// addLessonEvent adds the given timetable event to the calendar.
+func addLessonEvent(cal *ics.Calendar, event timetable.Event, opts lessonOptions) error {
+	eventUid, err := lessonUid(event)
+	if err != nil {
+		return err
 	}
 
-	calName := fmt.Sprintf("%s - %d year", course.Descrizione, year)
-	cal.SetName(calName)
+	e := cal.AddEvent(eventUid)
+	e.AddCategory(tr(opts.Lang, lessonCategory))
+	e.SetOrganizer(event.Teacher)
+	e.SetStartAt(event.Start.Time)
+	e.SetEndAt(event.End.Time)
 
-	calDesc := fmt.Sprintf("Orario delle lezioni del %d anno del corso di %s",
-		year, course.Descrizione)
-	cal.SetDescription(calDesc)
+	e.SetDtStampTime(time.Now()) // https://www.kanzaki.com/docs/ical/dtstamp.html
 
-	return cal, nil
+	data := newLessonData(event, opts.Format.Aliases)
+	summary := data.Title
+	if opts.Format.Summary != nil {
+		summary, err = executeEventTemplate(opts.Format.Summary, data)
+		if err != nil {
+			return err
+		}
+	}
+	e.SetSummary(summary)
+
+	b := strings.Builder{}
+	b.WriteString(tr(opts.Lang, "cal.teacher", event.Teacher) + "\n")
+	if classrooms := physicalClassrooms(event); len(classrooms) > 0 {
+		if len(classrooms) == 1 {
+			b.WriteString(tr(opts.Lang, "cal.lesson.room", lessonLocation(event, locationFull)) + "\n")
+		} else {
+			b.WriteString(tr(opts.Lang, "cal.lesson.rooms", lessonLocation(event, locationFull)) + "\n")
+		}
+		e.SetLocation(lessonLocation(event, opts.Location))
+
+		if building, ok := lessonBuilding(event); ok {
+			e.SetGeo(building.Lat, building.Lng)
+			b.WriteString(tr(opts.Lang, "cal.lesson.map", building.mapUrl()) + "\n")
+		}
+	} else if lessonOnline(event) {
+		e.SetLocation(tr(opts.Lang, "cal.lesson.online"))
+	}
+	if lessonOnline(event) {
+		if event.Teams != "" {
+			b.WriteString(tr(opts.Lang, "cal.lesson.online_link", event.Teams) + "\n")
+		} else {
+			b.WriteString(tr(opts.Lang, "cal.lesson.online") + "\n")
+		}
+		addOnlineProperties(e, event)
+	}
+	b.WriteString(tr(opts.Lang, "cal.lesson.cfu", event.Cfu) + "\n")
+	b.WriteString(tr(opts.Lang, "cal.lesson.period", event.Interval) + "\n")
+	b.WriteString(tr(opts.Lang, "cal.lesson.module", event.CodModulo) + "\n")
+
+	description := b.String()
+	if opts.Format.Description != nil {
+		description, err = executeEventTemplate(opts.Format.Description, data)
+		if err != nil {
+			return err
+		}
+	}
+	e.SetDescription(description)
+	return nil
 }
 
-func createExamsCal(exams []exams.Exam, title, description string) (*ics.Calendar, error) {
+func createExamsCal(
+	exams []exams.Exam,
+	title, description string,
+	registrations registrationMode,
+	lang string,
+) (*ics.Calendar, error) {
 	cal := ics.NewCalendar()
 	cal.SetMethod(ics.MethodRequest)
 
 	for _, exam := range exams {
-		sha := sha1.New()
-		_, err := fmt.Fprintf(sha, "%s%s%s%s", exam.SubjectName, exam.Date, exam.Location, exam.Teacher)
+		err := addExamEvent(cal, exam, registrations, lang)
 		if err != nil {
 			return nil, err
 		}
+	}
 
-		eventUid := fmt.Sprintf("%x", sha.Sum(nil))
+	cal.SetName(title)
+	cal.SetDescription(description)
 
-		e := cal.AddEvent(eventUid)
-		e.SetOrganizer(exam.Teacher)
-		e.SetSummary(exam.SubjectName)
-		e.SetStartAt(exam.Date)
-		e.SetEndAt(exam.Date.Add(2 * time.Hour))
-		e.SetLocation(exam.Location)
+	return cal, nil
+}
 
-		e.SetDtStampTime(time.Now())
+// registrationMode tells how the registration window of exams is shown
+type registrationMode string
 
-		b := strings.Builder{}
-		b.WriteString(fmt.Sprintf("Docente: %s\n", exam.Teacher))
-		b.WriteString(fmt.Sprintf("Codice: %s\n", exam.SubjectCode))
-		b.WriteString(fmt.Sprintf("Tipo: %s\n", exam.Type))
+const (
+	// registrationEvents adds all-day events for the opening and the closing
+	// of the registrations
+	registrationEvents registrationMode = "events"
+	// registrationAlarms adds an alarm to the exam, the day the registrations close
+	registrationAlarms registrationMode = "alarms"
+	registrationNone   registrationMode = "none"
+)
 
-		e.SetDescription(b.String())
+const (
+	registrationCategory = "cal.category.registration"
+	// defaultExamDuration is used when the duration is not in the exam data
+	defaultExamDuration = 2 * time.Hour
+	// registrationAlarmHour is the hour of the alarm on the day the registrations close
+	registrationAlarmHour = 9
+)
+
+func parseRegistrationMode(s string) (registrationMode, error) {
+	switch m := registrationMode(s); m {
+	case "":
+		return registrationEvents, nil
+	case registrationEvents, registrationAlarms, registrationNone:
+		return m, nil
+	default:
+		return "", fmt.Errorf("invalid registrations mode %q", s)
 	}
+}
 
-	cal.SetName(title)
-	cal.SetDescription(description)
+var (
+	registrationDateReg = regexp.MustCompile(`\b(\d{1,2}/\d{1,2}/\d{4})\b`)
+	// Matches time ranges like "9:00 - 12:00" or "dalle 9.00 alle 12.00"
+	examTimeRangeReg = regexp.MustCompile(`(\d{1,2})[:.](\d{2})\s*(?:-|–|alle)\s*(\d{1,2})[:.](\d{2})`)
+	// Matches durations like "durata: 3 ore" or "durata 90 minuti"
+	examDurationReg = regexp.MustCompile(`(?i)durata:?\s*(\d+)\s*(or[ae]|minuti)`)
+)
 
-	return cal, nil
+// parseRegistrationWindow parses the registration list of an exam, as shown
+// by AlmaEsami (e.g. "dal 25/11/2024 al 13/01/2025"). The returned dates are
+// the first and the last day the registrations are open.
+func parseRegistrationWindow(s string) (time.Time, time.Time, bool) {
+	dates := registrationDateReg.FindAllString(s, 2)
+	if len(dates) != 2 {
+		return time.Time{}, time.Time{}, false
+	}
+
+	open, err := time.ParseInLocation("2/1/2006", dates[0], romeLocation)
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+
+	closing, err := time.ParseInLocation("2/1/2006", dates[1], romeLocation)
+	if err != nil || closing.Before(open) {
+		return time.Time{}, time.Time{}, false
+	}
+
+	return open, closing, true
+}
+
+// examDuration returns the duration of the exam if it is written in its type,
+// the free text of AlmaEsami where teachers write the times (e.g. "Scritto
+// dalle 9:00 alle 12:30"), otherwise defaultExamDuration. The location is
+// not read, since room names like "Aula 12.30" look like times. A time range
+// is used only if it is the only one and it starts when the exam starts.
+func examDuration(exam exams.Exam) time.Duration {
+	start := exam.Date.In(romeLocation)
+
+	if m := examTimeRangeReg.FindAllStringSubmatch(exam.Type, -1); len(m) == 1 {
+		startH, _ := strconv.Atoi(m[0][1])
+		startM, _ := strconv.Atoi(m[0][2])
+		endH, _ := strconv.Atoi(m[0][3])
+		endM, _ := strconv.Atoi(m[0][4])
+
+		d := time.Duration(endH-startH)*time.Hour + time.Duration(endM-startM)*time.Minute
+		if startH == start.Hour() && startM == start.Minute() && d > 0 {
+			return d
+		}
+	}
+
+	if m := examDurationReg.FindStringSubmatch(exam.Type); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		if strings.HasPrefix(strings.ToLower(m[2]), "or") {
+			return time.Duration(n) * time.Hour
+		}
+		return time.Duration(n) * time.Minute
+	}
+
+	return defaultExamDuration
+}
+
+// addExamEvent adds the given exam to the calendar, along with its
+// registration window as specified by registrations.
+func addExamEvent(cal *ics.Calendar, exam exams.Exam, registrations registrationMode, lang string) error {
+	sha := sha1.New()
+	_, err := fmt.Fprintf(sha, "%s%s%s%s", exam.SubjectName, exam.Date, exam.Location, exam.Teacher)
+	if err != nil {
+		return err
+	}
+
+	eventUid := fmt.Sprintf("%x", sha.Sum(nil))
+
+	e := cal.AddEvent(eventUid)
+	e.AddCategory(tr(lang, examCategory))
+	e.SetOrganizer(exam.Teacher)
+	e.SetSummary(exam.SubjectName)
+	e.SetStartAt(exam.Date)
+	e.SetEndAt(exam.Date.Add(examDuration(exam)))
+	e.SetLocation(exam.Location)
+
+	e.SetDtStampTime(time.Now())
+
+	b := strings.Builder{}
+	b.WriteString(tr(lang, "cal.teacher", exam.Teacher) + "\n")
+	b.WriteString(tr(lang, "cal.exam.code", exam.SubjectCode) + "\n")
+	b.WriteString(tr(lang, "cal.exam.type", exam.Type) + "\n")
+
+	open, closing, found := parseRegistrationWindow(exam.Subscriptions)
+	if found {
+		b.WriteString(tr(lang, "cal.exam.registrations", open.Format("02/01/2006"), closing.Format("02/01/2006")) + "\n")
+	}
+
+	e.SetDescription(b.String())
+
+	if !found {
+		return nil
+	}
+
+	switch registrations {
+	case registrationEvents:
+		addRegistrationEvent(cal, eventUid+"-open", tr(lang, "cal.exam.registrations_open", exam.SubjectName), open, exam, lang)
+		addRegistrationEvent(cal, eventUid+"-close", tr(lang, "cal.exam.registrations_close", exam.SubjectName), closing, exam, lang)
+	case registrationAlarms:
+		alarmAt := time.Date(closing.Year(), closing.Month(), closing.Day(), registrationAlarmHour, 0, 0, 0, romeLocation)
+		alarm := e.AddAlarm()
+		alarm.SetAction(ics.ActionDisplay)
+		alarm.SetTrigger(alarmAt.UTC().Format("20060102T150405Z"), ics.WithValue("DATE-TIME"))
+		alarm.SetProperty(ics.ComponentPropertyDescription, tr(lang, "cal.exam.last_day", exam.SubjectName))
+	}
+
+	return nil
+}
+
+// addRegistrationEvent adds an all-day event about the registrations of exam.
+func addRegistrationEvent(cal *ics.Calendar, uid, summary string, day time.Time, exam exams.Exam, lang string) {
+	e := cal.AddEvent(uid)
+	e.AddCategory(tr(lang, registrationCategory))
+	e.SetSummary(summary)
+	e.SetAllDayStartAt(day)
+	e.SetAllDayEndAt(day.AddDate(0, 0, 1))
+	e.SetDtStampTime(time.Now())
+	e.SetDescription(tr(lang, "cal.exam.registration_description",
+		exam.Date.Format("02/01/2006 15:04"), exam.Subscriptions) + "\n")
+}
+
+// addExamsToCal adds the given exams to a lessons calendar, so that both can
+// be subscribed with a single URL.
+func addExamsToCal(
+	cal *ics.Calendar,
+	exams []exams.Exam,
+	course *unibo_integ.Course,
+	year int,
+	registrations registrationMode,
+	lang string,
+) error {
+	for _, exam := range exams {
+		err := addExamEvent(cal, exam, registrations, lang)
+		if err != nil {
+			return err
+		}
+	}
+
+	cal.SetDescription(tr(lang, "cal.combined.description", year, course.Descrizione))
+
+	return nil
 }
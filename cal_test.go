@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+	"github.com/cartabinaria/unibo-go/exams"
+	"github.com/go-playground/assert/v2"
+)
+
+func Test_parseRegistrationWindow(t *testing.T) {
+	open, closing, found := parseRegistrationWindow("dal 25/11/2024 al 13/01/2025")
+	assert.Equal(t, true, found)
+	assert.Equal(t, time.Date(2024, 11, 25, 0, 0, 0, 0, romeLocation), open)
+	assert.Equal(t, time.Date(2025, 1, 13, 0, 0, 0, 0, romeLocation), closing)
+
+	for _, s := range []string{"", "Lista chiusa", "dal 13/01/2025 al 25/11/2024", "dal 31/02/2025 al 01/03/2025"} {
+		_, _, found = parseRegistrationWindow(s)
+		assert.Equal(t, false, found)
+	}
+}
+
+func Test_examDuration(t *testing.T) {
+	nine := time.Date(2025, 1, 20, 9, 0, 0, 0, romeLocation)
+
+	tests := []struct {
+		exam exams.Exam
+		want time.Duration
+	}{
+		{exams.Exam{Type: "Scritto", Date: nine}, defaultExamDuration},
+		{exams.Exam{Type: "Scritto dalle 9:00 alle 12:30", Date: nine}, 3*time.Hour + 30*time.Minute},
+		{exams.Exam{Type: "Orale (durata: 3 ore)", Date: nine}, 3 * time.Hour},
+		{exams.Exam{Type: "Scritto durata 90 minuti", Date: nine}, 90 * time.Minute},
+		// Rooms are not times
+		{exams.Exam{Type: "Scritto", Location: "Aula 9.00 - 12.30", Date: nine}, defaultExamDuration},
+		{exams.Exam{Type: "Scritto", Location: "Aula 12.30, Via Zamboni 33", Date: nine}, defaultExamDuration},
+		// Ambiguous ranges
+		{exams.Exam{Type: "Scritto 14:00 - 16:00", Date: nine}, defaultExamDuration},
+		{exams.Exam{Type: "Turno A 9:00-11:00, turno B 11:00-13:00", Date: nine}, defaultExamDuration},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, examDuration(tt.exam))
+	}
+}
+
+func Test_addExamEvent(t *testing.T) {
+	exam := exams.Exam{
+		SubjectName:   "ANALISI",
+		Date:          time.Date(2025, 1, 20, 9, 0, 0, 0, romeLocation),
+		Subscriptions: "dal 25/11/2024 al 13/01/2025",
+	}
+
+	cal := ics.NewCalendar()
+	assert.Equal(t, nil, addExamEvent(cal, exam, registrationEvents, langIt))
+	assert.Equal(t, 3, len(cal.Events()))
+
+	cal = ics.NewCalendar()
+	assert.Equal(t, nil, addExamEvent(cal, exam, registrationAlarms, langIt))
+	assert.Equal(t, 1, len(cal.Events()))
+	assert.Equal(t, true, strings.Contains(cal.Serialize(), "TRIGGER;VALUE=DATE-TIME:20250113T080000Z"))
+
+	cal = ics.NewCalendar()
+	assert.Equal(t, nil, addExamEvent(cal, exam, registrationNone, langIt))
+	assert.Equal(t, 1, len(cal.Events()))
+}
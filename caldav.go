@@ -0,0 +1,812 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+	"github.com/gin-gonic/gin"
+	"github.com/patrickmn/go-cache"
+)
+
+// XML namespaces of WebDAV, CalDAV and the calendar server extensions
+const (
+	davNamespace       = "DAV:"
+	caldavNamespace    = "urn:ietf:params:xml:ns:caldav"
+	calserverNamespace = "http://calendarserver.org/ns/"
+)
+
+const (
+	davRoot         = "/dav/"
+	syncTokenPrefix = "urn:almacalendar:sync:"
+	davTimeLayout   = "20060102T150405Z"
+	davNotFound     = "HTTP/1.1 404 Not Found"
+	davOk           = "HTTP/1.1 200 OK"
+)
+
+// davMethods are the methods of the read-only CalDAV server.
+var davMethods = []string{http.MethodGet, http.MethodHead, http.MethodOptions, "PROPFIND", "REPORT"}
+
+// davWriteMethods are refused, since the calendars are generated.
+var davWriteMethods = []string{
+	http.MethodPut, http.MethodPost, http.MethodDelete, "PROPPATCH", "MKCOL", "MKCALENDAR", "COPY", "MOVE", "LOCK", "UNLOCK",
+}
+
+// davPrefixes are the prefixes of the namespaces in the responses.
+var davPrefixes = map[string]string{davNamespace: "d", caldavNamespace: "c", calserverNamespace: "cs"}
+
+// davAllProps are the properties returned for an allprop PROPFIND.
+var davAllProps = []xml.Name{
+	{Space: davNamespace, Local: "resourcetype"},
+	{Space: davNamespace, Local: "displayname"},
+	{Space: davNamespace, Local: "getetag"},
+	{Space: davNamespace, Local: "getcontenttype"},
+}
+
+// syncSnapshots holds the etags of the events of a collection for every sync
+// token given to the clients, so that the next sync returns only the changes.
+var syncSnapshots = cache.New(time.Hour*24*7, time.Hour)
+
+var errInvalidDavPath = errors.New("invalid path")
+
+// davPath is a parsed path of the CalDAV server, e.g.
+// "/dav/cal/8009/1/000-000/<uid>.ics". Every lessons and exams feed of a
+// course year and curriculum is a calendar collection.
+type davPath struct {
+	// Kind is "cal" for the lessons or "exams", empty for the root
+	Kind       string
+	Course     int
+	Year       int
+	Curriculum string
+	// Event is the UID of an event of the collection, if any
+	Event string
+}
+
+// parseDavPath parses the escaped path after the root, e.g.
+// "cal/8009/1/abc%40unibo.ics".
+func parseDavPath(p string) (davPath, error) {
+	var segments []string
+	for _, s := range strings.Split(p, "/") {
+		if s == "" {
+			continue
+		}
+		s, err := url.PathUnescape(s)
+		if err != nil {
+			return davPath{}, errInvalidDavPath
+		}
+		segments = append(segments, s)
+	}
+	if len(segments) == 0 {
+		return davPath{}, nil
+	}
+
+	var d davPath
+	if last := segments[len(segments)-1]; strings.HasSuffix(last, ".ics") {
+		uid := strings.TrimSuffix(last, ".ics")
+		if uid == "" {
+			return davPath{}, errInvalidDavPath
+		}
+		d.Event = uid
+		segments = segments[:len(segments)-1]
+	}
+
+	if len(segments) != 3 && len(segments) != 4 {
+		return davPath{}, errInvalidDavPath
+	}
+	if segments[0] != "cal" && segments[0] != "exams" {
+		return davPath{}, errInvalidDavPath
+	}
+	d.Kind = segments[0]
+
+	var err error
+	d.Course, err = strconv.Atoi(segments[1])
+	if err != nil {
+		return davPath{}, errInvalidDavPath
+	}
+	d.Year, err = strconv.Atoi(segments[2])
+	if err != nil {
+		return davPath{}, errInvalidDavPath
+	}
+	if len(segments) == 4 {
+		d.Curriculum = segments[3]
+	}
+
+	return d, nil
+}
+
+// collection returns the path of the calendar collection.
+func (p davPath) collection() string {
+	path := fmt.Sprintf("%s%s/%d/%d/", davRoot, p.Kind, p.Course, p.Year)
+	if p.Curriculum != "" {
+		path += url.PathEscape(p.Curriculum) + "/"
+	}
+	return path
+}
+
+// href returns the path of the event.
+func (p davPath) href() string {
+	return p.collection() + url.PathEscape(p.Event) + ".ics"
+}
+
+// feed returns the path of the feed of the collection.
+func (p davPath) feed() *url.URL {
+	u := &url.URL{Path: fmt.Sprintf("/%s/%d/%d", p.Kind, p.Course, p.Year)}
+	if p.Curriculum != "" {
+		u.RawQuery = url.Values{"curr": {p.Curriculum}}.Encode()
+	}
+	return u
+}
+
+// davEvent is an event of a collection, as a calendar object resource.
+type davEvent struct {
+	Href string
+	Etag string
+	// Data is a calendar with only this event
+	Data  string
+	Start time.Time
+	End   time.Time
+}
+
+// davCollection is a calendar collection with its events sorted by href.
+type davCollection struct {
+	Path   davPath
+	Name   string
+	Events []davEvent
+	// Ctag changes whenever an event changes
+	Ctag string
+}
+
+func (c *davCollection) syncToken() string {
+	return syncTokenPrefix + c.Ctag
+}
+
+func (c *davCollection) event(href string) (*davEvent, bool) {
+	i, found := slices.BinarySearchFunc(c.Events, href, func(e davEvent, href string) int {
+		return strings.Compare(e.Href, href)
+	})
+	if !found {
+		return nil, false
+	}
+	return &c.Events[i], true
+}
+
+// eventEtag returns the etag of the event. DTSTAMP is ignored, because it is
+// the time the calendar was generated.
+func eventEtag(e *ics.VEvent) string {
+	stable := ics.VEvent{ComponentBase: ics.ComponentBase{Components: e.Components}}
+	for _, p := range e.Properties {
+		if p.IANAToken != string(ics.ComponentPropertyDtstamp) {
+			stable.Properties = append(stable.Properties, p)
+		}
+	}
+	cal := ics.Calendar{Components: []ics.Component{&stable}}
+	return fmt.Sprintf("%x", sha1.Sum([]byte(cal.Serialize())))
+}
+
+// eventCalendar returns a calendar with only the given event, as stored in a
+// calendar collection: without METHOD and the properties of the feed.
+func eventCalendar(prodId string, e *ics.VEvent) string {
+	cal := ics.NewCalendar()
+	if prodId != "" {
+		cal.SetProductId(prodId)
+	}
+	cal.AddVEvent(e)
+	return cal.Serialize()
+}
+
+// withUid returns a copy of the event with the given UID.
+func withUid(e *ics.VEvent, uid string) *ics.VEvent {
+	copied := &ics.VEvent{ComponentBase: ics.ComponentBase{Components: e.Components}}
+	copied.Properties = slices.Clone(e.Properties)
+	copied.SetProperty(ics.ComponentPropertyUniqueId, uid)
+	return copied
+}
+
+// newDavCollection builds the collection of the path from the calendar of
+// its feed. The UID of an event must be unique in a collection, but parallel
+// sessions of a lesson share it in the feeds: the repeated ones get a suffix,
+// in the order of their etags so that it doesn't depend on the feed.
+func newDavCollection(p davPath, cal *ics.Calendar) *davCollection {
+	prodId := ""
+	for _, prop := range cal.CalendarProperties {
+		if prop.IANAToken == string(ics.PropertyProductId) {
+			prodId = prop.Value
+		}
+	}
+
+	var uids []string
+	byUid := make(map[string][]*ics.VEvent)
+	for _, e := range cal.Events() {
+		if e.Id() == "" {
+			continue
+		}
+		if _, ok := byUid[e.Id()]; !ok {
+			uids = append(uids, e.Id())
+		}
+		byUid[e.Id()] = append(byUid[e.Id()], e)
+	}
+
+	c := &davCollection{Path: p, Name: calendarName(cal)}
+	for _, uid := range uids {
+		same := byUid[uid]
+		slices.SortStableFunc(same, func(a, b *ics.VEvent) int {
+			return strings.Compare(eventEtag(a), eventEtag(b))
+		})
+
+		for i, e := range same {
+			if i > 0 {
+				e = withUid(e, fmt.Sprintf("%s-%d", uid, i+1))
+			}
+			// The time range of the queries, zero if the dates are invalid
+			dates, _ := newFeedEvent(e)
+
+			event := p
+			event.Event = e.Id()
+			c.Events = append(c.Events, davEvent{
+				Href:  event.href(),
+				Etag:  eventEtag(e),
+				Data:  eventCalendar(prodId, e),
+				Start: dates.Start,
+				End:   dates.End,
+			})
+		}
+	}
+
+	slices.SortFunc(c.Events, func(a, b davEvent) int {
+		return strings.Compare(a.Href, b.Href)
+	})
+
+	sha := sha1.New()
+	for _, e := range c.Events {
+		_, _ = fmt.Fprintf(sha, "%s %s\n", e.Href, e.Etag)
+	}
+	c.Ctag = fmt.Sprintf("%x", sha.Sum(nil))
+
+	return c
+}
+
+// loadDavCollection reads the collection from its feed, read from feeds.
+// The etags are kept for the sync token of the collection.
+func loadDavCollection(feeds feedSource, ctx *gin.Context, p davPath) (*davCollection, bool) {
+	cal, err := readFeed(feeds, p.feed())
+	if err != nil {
+		sendCalError(ctx, err)
+		return nil, false
+	}
+
+	c := newDavCollection(p, cal)
+
+	snapshot := make(map[string]string, len(c.Events))
+	for _, e := range c.Events {
+		snapshot[e.Href] = e.Etag
+	}
+	syncSnapshots.Set(p.collection()+" "+c.Ctag, snapshot, cache.DefaultExpiration)
+
+	return c, true
+}
+
+// davResource is the root, a collection or an event of a collection.
+type davResource struct {
+	Href       string
+	Collection *davCollection
+	Event      *davEvent
+}
+
+// davElement returns an element of the namespace, with the given inner XML.
+func davElement(name xml.Name, inner string) string {
+	prefix, ok := davPrefixes[name.Space]
+	attr := ""
+	if !ok {
+		prefix = "x"
+		attr = ` xmlns:x="` + davEscape(name.Space) + `"`
+	}
+	if inner == "" {
+		return "<" + prefix + ":" + name.Local + attr + "/>"
+	}
+	return "<" + prefix + ":" + name.Local + attr + ">" + inner + "</" + prefix + ":" + name.Local + ">"
+}
+
+func davEscape(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func davHref(href string) string {
+	return "<d:href>" + davEscape(href) + "</d:href>"
+}
+
+// propValue returns the inner XML of the property of the resource, or false
+// if the resource doesn't have it.
+func (r davResource) propValue(name xml.Name) (string, bool) {
+	c, e := r.Collection, r.Event
+	isCollection := c != nil && e == nil
+
+	switch name {
+	case xml.Name{Space: davNamespace, Local: "resourcetype"}:
+		switch {
+		case isCollection:
+			return "<d:collection/><c:calendar/>", true
+		case c == nil:
+			return "<d:collection/><d:principal/>", true
+		default:
+			return "", true
+		}
+	case xml.Name{Space: davNamespace, Local: "displayname"}:
+		if isCollection {
+			return davEscape(c.Name), true
+		}
+		if c == nil {
+			return "AlmaCalendar", true
+		}
+	// The root is the principal and its calendar home, so that the clients
+	// relying on discovery can set up an account. The home lists no
+	// calendars: they are added with their URL.
+	case xml.Name{Space: davNamespace, Local: "current-user-principal"},
+		xml.Name{Space: davNamespace, Local: "principal-URL"},
+		xml.Name{Space: caldavNamespace, Local: "calendar-home-set"}:
+		return davHref(davRoot), true
+	case xml.Name{Space: davNamespace, Local: "current-user-privilege-set"}:
+		return "<d:privilege><d:read/></d:privilege><d:privilege><d:read-current-user-privilege-set/></d:privilege>", true
+	case xml.Name{Space: caldavNamespace, Local: "supported-calendar-component-set"}:
+		if isCollection {
+			return `<c:comp name="VEVENT"/>`, true
+		}
+	case xml.Name{Space: davNamespace, Local: "supported-report-set"}:
+		if isCollection {
+			return "<d:supported-report><d:report><c:calendar-query/></d:report></d:supported-report>" +
+				"<d:supported-report><d:report><c:calendar-multiget/></d:report></d:supported-report>" +
+				"<d:supported-report><d:report><d:sync-collection/></d:report></d:supported-report>", true
+		}
+	case xml.Name{Space: calserverNamespace, Local: "getctag"}:
+		if isCollection {
+			return davEscape(c.Ctag), true
+		}
+	case xml.Name{Space: davNamespace, Local: "sync-token"}:
+		if isCollection {
+			return davEscape(c.syncToken()), true
+		}
+	case xml.Name{Space: davNamespace, Local: "getetag"}:
+		if e != nil {
+			return davEscape(`"` + e.Etag + `"`), true
+		}
+		if isCollection {
+			return davEscape(`"` + c.Ctag + `"`), true
+		}
+	case xml.Name{Space: davNamespace, Local: "getcontenttype"}:
+		if e != nil {
+			return "text/calendar; charset=utf-8; component=vevent", true
+		}
+	case xml.Name{Space: caldavNamespace, Local: "calendar-data"}:
+		if e != nil {
+			return davEscape(e.Data), true
+		}
+	}
+	return "", false
+}
+
+// davMultistatus is the body of the 207 responses. Prefixes are written
+// literally, so that every element doesn't repeat its namespace.
+type davMultistatus struct {
+	XMLName   xml.Name      `xml:"d:multistatus"`
+	D         string        `xml:"xmlns:d,attr"`
+	C         string        `xml:"xmlns:c,attr"`
+	CS        string        `xml:"xmlns:cs,attr"`
+	Responses []davResponse `xml:"d:response"`
+	SyncToken string        `xml:"d:sync-token,omitempty"`
+}
+
+type davResponse struct {
+	Href      string        `xml:"d:href"`
+	Status    string        `xml:"d:status,omitempty"`
+	Propstats []davPropstat `xml:"d:propstat"`
+}
+
+type davPropstat struct {
+	Prop struct {
+		Inner string `xml:",innerxml"`
+	} `xml:"d:prop"`
+	Status string `xml:"d:status"`
+}
+
+// newDavResponse returns the requested properties of the resource, the
+// missing ones with the 404 status.
+func newDavResponse(r davResource, names []xml.Name) davResponse {
+	var found, missing strings.Builder
+	for _, name := range names {
+		if value, ok := r.propValue(name); ok {
+			found.WriteString(davElement(name, value))
+		} else {
+			missing.WriteString(davElement(name, ""))
+		}
+	}
+
+	res := davResponse{Href: r.Href}
+	if found.Len() != 0 || missing.Len() == 0 {
+		ps := davPropstat{Status: davOk}
+		ps.Prop.Inner = found.String()
+		res.Propstats = append(res.Propstats, ps)
+	}
+	if missing.Len() != 0 {
+		ps := davPropstat{Status: davNotFound}
+		ps.Prop.Inner = missing.String()
+		res.Propstats = append(res.Propstats, ps)
+	}
+	return res
+}
+
+func writeMultistatus(ctx *gin.Context, responses []davResponse, syncToken string) {
+	body, err := xml.Marshal(davMultistatus{
+		D:         davNamespace,
+		C:         caldavNamespace,
+		CS:        calserverNamespace,
+		Responses: responses,
+		SyncToken: syncToken,
+	})
+	if err != nil {
+		_ = ctx.Error(err)
+		ctx.String(http.StatusInternalServerError, "Unable to write response")
+		return
+	}
+	ctx.Data(http.StatusMultiStatus, "application/xml; charset=utf-8", append([]byte(xml.Header), body...))
+}
+
+// davPropNames are the names of the children of a prop element.
+type davPropNames []xml.Name
+
+func (n *davPropNames) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			*n = append(*n, t.Name)
+			if err := d.Skip(); err != nil {
+				return err
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+// davPropRequest are the properties requested by PROPFIND and REPORT.
+type davPropRequest struct {
+	AllProp *struct{}    `xml:"DAV: allprop"`
+	Prop    davPropNames `xml:"DAV: prop"`
+}
+
+func (r davPropRequest) names() []xml.Name {
+	if len(r.Prop) == 0 {
+		return davAllProps
+	}
+	return r.Prop
+}
+
+type davPropfind struct {
+	XMLName xml.Name `xml:"DAV: propfind"`
+	davPropRequest
+}
+
+type davTimeRange struct {
+	Start string `xml:"start,attr"`
+	End   string `xml:"end,attr"`
+}
+
+type davCompFilter struct {
+	Name      string          `xml:"name,attr"`
+	TimeRange *davTimeRange   `xml:"urn:ietf:params:xml:ns:caldav time-range"`
+	Filters   []davCompFilter `xml:"urn:ietf:params:xml:ns:caldav comp-filter"`
+}
+
+type davCalendarQuery struct {
+	XMLName xml.Name `xml:"urn:ietf:params:xml:ns:caldav calendar-query"`
+	davPropRequest
+	Filter *davCompFilter `xml:"urn:ietf:params:xml:ns:caldav filter>comp-filter"`
+}
+
+type davMultiget struct {
+	XMLName xml.Name `xml:"urn:ietf:params:xml:ns:caldav calendar-multiget"`
+	davPropRequest
+	Hrefs []string `xml:"DAV: href"`
+}
+
+type davSyncCollection struct {
+	XMLName xml.Name `xml:"DAV: sync-collection"`
+	davPropRequest
+	SyncToken string `xml:"DAV: sync-token"`
+}
+
+// eventMatcher returns whether an event matches the filter of a
+// calendar-query report. Only the time range of the events is supported.
+func eventMatcher(f *davCompFilter) (func(davEvent) bool, error) {
+	if f == nil {
+		return func(davEvent) bool { return true }, nil
+	}
+	if f.Name != "VCALENDAR" {
+		return func(davEvent) bool { return false }, nil
+	}
+
+	var event *davCompFilter
+	for i := range f.Filters {
+		if f.Filters[i].Name != "VEVENT" {
+			return func(davEvent) bool { return false }, nil
+		}
+		event = &f.Filters[i]
+	}
+	if event == nil || event.TimeRange == nil {
+		return func(davEvent) bool { return true }, nil
+	}
+
+	start, end := time.Time{}, time.Time{}
+	var err error
+	if event.TimeRange.Start != "" {
+		start, err = time.Parse(davTimeLayout, event.TimeRange.Start)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time-range start %q", event.TimeRange.Start)
+		}
+	}
+	if event.TimeRange.End != "" {
+		end, err = time.Parse(davTimeLayout, event.TimeRange.End)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time-range end %q", event.TimeRange.End)
+		}
+	}
+
+	return func(e davEvent) bool {
+		return (end.IsZero() || e.Start.Before(end)) && (start.IsZero() || e.End.After(start) || e.Start.Equal(start))
+	}, nil
+}
+
+// syncChanges returns the responses of a sync-collection report: every event
+// if token is empty, otherwise the events changed since the token was given
+// and the removed ones. If the token is unknown, false is returned.
+func syncChanges(c *davCollection, token string, names []xml.Name) ([]davResponse, bool) {
+	responses := make([]davResponse, 0)
+	if token == "" {
+		for i := range c.Events {
+			responses = append(responses, newDavResponse(davResource{c.Events[i].Href, c, &c.Events[i]}, names))
+		}
+		return responses, true
+	}
+
+	ctag, ok := strings.CutPrefix(token, syncTokenPrefix)
+	if !ok {
+		return nil, false
+	}
+	old, found := syncSnapshots.Get(c.Path.collection() + " " + ctag)
+	if !found {
+		return nil, false
+	}
+	snapshot := old.(map[string]string)
+
+	for i, e := range c.Events {
+		if snapshot[e.Href] != e.Etag {
+			responses = append(responses, newDavResponse(davResource{e.Href, c, &c.Events[i]}, names))
+		}
+	}
+	removed := make([]string, 0)
+	for href := range snapshot {
+		if _, ok := c.event(href); !ok {
+			removed = append(removed, href)
+		}
+	}
+	slices.Sort(removed)
+	for _, href := range removed {
+		responses = append(responses, davResponse{Href: href, Status: davNotFound})
+	}
+
+	return responses, true
+}
+
+func davPropfindHandler(ctx *gin.Context, feeds feedSource, p davPath, body []byte) {
+	var req davPropfind
+	if len(bytes.TrimSpace(body)) != 0 {
+		if err := xml.Unmarshal(body, &req); err != nil {
+			ctx.String(http.StatusBadRequest, "Invalid PROPFIND body")
+			return
+		}
+	}
+	names := req.names()
+	children := ctx.GetHeader("Depth") != "0"
+
+	if p.Kind == "" {
+		writeMultistatus(ctx, []davResponse{newDavResponse(davResource{Href: davRoot}, names)}, "")
+		return
+	}
+
+	c, ok := loadDavCollection(feeds, ctx, p)
+	if !ok {
+		return
+	}
+
+	if p.Event != "" {
+		e, found := c.event(p.href())
+		if !found {
+			ctx.String(http.StatusNotFound, "Event not found")
+			return
+		}
+		writeMultistatus(ctx, []davResponse{newDavResponse(davResource{e.Href, c, e}, names)}, "")
+		return
+	}
+
+	responses := []davResponse{newDavResponse(davResource{Href: p.collection(), Collection: c}, names)}
+	if children {
+		for i := range c.Events {
+			responses = append(responses, newDavResponse(davResource{c.Events[i].Href, c, &c.Events[i]}, names))
+		}
+	}
+	writeMultistatus(ctx, responses, "")
+}
+
+func davReportHandler(ctx *gin.Context, feeds feedSource, p davPath, body []byte) {
+	if p.Kind == "" || p.Event != "" {
+		ctx.String(http.StatusForbidden, "Reports are supported only on calendars")
+		return
+	}
+
+	// The root element tells the report
+	var root struct {
+		XMLName xml.Name
+	}
+	if err := xml.Unmarshal(body, &root); err != nil {
+		ctx.String(http.StatusBadRequest, "Invalid REPORT body")
+		return
+	}
+
+	c, ok := loadDavCollection(feeds, ctx, p)
+	if !ok {
+		return
+	}
+
+	switch root.XMLName {
+	case xml.Name{Space: caldavNamespace, Local: "calendar-query"}:
+		var q davCalendarQuery
+		if err := xml.Unmarshal(body, &q); err != nil {
+			ctx.String(http.StatusBadRequest, "Invalid calendar-query")
+			return
+		}
+		matches, err := eventMatcher(q.Filter)
+		if err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		responses := make([]davResponse, 0)
+		for i, e := range c.Events {
+			if matches(e) {
+				responses = append(responses, newDavResponse(davResource{e.Href, c, &c.Events[i]}, q.names()))
+			}
+		}
+		writeMultistatus(ctx, responses, "")
+
+	case xml.Name{Space: caldavNamespace, Local: "calendar-multiget"}:
+		var q davMultiget
+		if err := xml.Unmarshal(body, &q); err != nil {
+			ctx.String(http.StatusBadRequest, "Invalid calendar-multiget")
+			return
+		}
+
+		responses := make([]davResponse, 0, len(q.Hrefs))
+		for _, href := range q.Hrefs {
+			href = strings.TrimSpace(href)
+			if u, err := url.Parse(href); err == nil {
+				if event, err := parseDavPath(strings.TrimPrefix(u.EscapedPath(), davRoot)); err == nil && event.Event != "" {
+					href = event.href()
+				}
+			}
+			if e, found := c.event(href); found {
+				responses = append(responses, newDavResponse(davResource{e.Href, c, e}, q.names()))
+			} else {
+				responses = append(responses, davResponse{Href: href, Status: davNotFound})
+			}
+		}
+		writeMultistatus(ctx, responses, "")
+
+	case xml.Name{Space: davNamespace, Local: "sync-collection"}:
+		var q davSyncCollection
+		if err := xml.Unmarshal(body, &q); err != nil {
+			ctx.String(http.StatusBadRequest, "Invalid sync-collection")
+			return
+		}
+
+		responses, ok := syncChanges(c, strings.TrimSpace(q.SyncToken), q.names())
+		if !ok {
+			ctx.Data(http.StatusForbidden, "application/xml; charset=utf-8",
+				[]byte(xml.Header+`<d:error xmlns:d="DAV:"><d:valid-sync-token/></d:error>`))
+			return
+		}
+		writeMultistatus(ctx, responses, c.syncToken())
+
+	default:
+		ctx.String(http.StatusForbidden, "Unsupported report")
+	}
+}
+
+func davGetHandler(ctx *gin.Context, feeds feedSource, p davPath) {
+	if p.Kind == "" {
+		ctx.Redirect(http.StatusFound, "/")
+		return
+	}
+	if p.Event == "" {
+		ctx.Redirect(http.StatusFound, p.feed().String())
+		return
+	}
+
+	c, ok := loadDavCollection(feeds, ctx, p)
+	if !ok {
+		return
+	}
+	e, found := c.event(p.href())
+	if !found {
+		ctx.String(http.StatusNotFound, "Event not found")
+		return
+	}
+
+	etag := `"` + e.Etag + `"`
+	ctx.Header("ETag", etag)
+	if ctx.GetHeader("If-None-Match") == etag {
+		ctx.Status(http.StatusNotModified)
+		return
+	}
+	ctx.Data(http.StatusOK, "text/calendar; charset=utf-8", []byte(e.Data))
+}
+
+// caldavHandler serves the calendars as a read-only CalDAV server, for the
+// clients that prefer it to webcal subscriptions. Every lessons and exams
+// feed of a course year is a collection, e.g. "/dav/cal/8009/1/" or
+// "/dav/exams/8009/1/000-000/", read from feeds. The
+// calendar-query, calendar-multiget and sync-collection reports are supported.
+func caldavHandler(feeds feedSource) func(c *gin.Context) {
+	allow := strings.Join(davMethods, ", ")
+
+	return func(ctx *gin.Context) {
+		ctx.Header("DAV", "1, 3, calendar-access")
+
+		if slices.Contains(davWriteMethods, ctx.Request.Method) {
+			ctx.Header("Allow", allow)
+			ctx.String(http.StatusMethodNotAllowed, "Calendars are read-only")
+			return
+		}
+		if ctx.Request.Method == http.MethodOptions {
+			ctx.Header("Allow", allow)
+			ctx.Status(http.StatusOK)
+			return
+		}
+
+		// The path is parsed escaped, so that a UID is unescaped only once
+		p, err := parseDavPath(strings.TrimPrefix(ctx.Request.URL.EscapedPath(), davRoot))
+		if err != nil {
+			ctx.String(http.StatusNotFound, err.Error())
+			return
+		}
+
+		switch ctx.Request.Method {
+		case http.MethodGet, http.MethodHead:
+			davGetHandler(ctx, feeds, p)
+		case "PROPFIND", "REPORT":
+			body, err := io.ReadAll(ctx.Request.Body)
+			if err != nil {
+				ctx.String(http.StatusBadRequest, "Unable to read body")
+				return
+			}
+			if ctx.Request.Method == "PROPFIND" {
+				davPropfindHandler(ctx, feeds, p, body)
+			} else {
+				davReportHandler(ctx, feeds, p, body)
+			}
+		}
+	}
+}
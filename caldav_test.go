@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/assert/v2"
+)
+
+func Test_parseDavPath(t *testing.T) {
+	tests := []struct {
+		path    string
+		want    davPath
+		wantErr bool
+	}{
+		{"/", davPath{}, false},
+		{"/cal/8009/1/", davPath{Kind: "cal", Course: 8009, Year: 1}, false},
+		{"/exams/8009/2/000-000", davPath{Kind: "exams", Course: 8009, Year: 2, Curriculum: "000-000"}, false},
+		{"/cal/8009/1/abc%40unibo.ics", davPath{Kind: "cal", Course: 8009, Year: 1, Event: "abc@unibo"}, false},
+		{"/cal/8009/1/000-000/abc.ics", davPath{Kind: "cal", Course: 8009, Year: 1, Curriculum: "000-000", Event: "abc"}, false},
+		{"/teacher/8009/1/", davPath{}, true},
+		{"/cal/8009/", davPath{}, true},
+		{"/cal/abc/1/", davPath{}, true},
+		{"/cal/8009/1/000-000/x/", davPath{}, true},
+		{"/cal/8009/1/.ics", davPath{}, true},
+		// Escaped once by the client, unescaped once
+		{"/cal/8009/1/50%2540.ics", davPath{Kind: "cal", Course: 8009, Year: 1, Event: "50%40"}, false},
+		{"/cal/8009/1/a%2Fb.ics", davPath{Kind: "cal", Course: 8009, Year: 1, Event: "a/b"}, false},
+		{"/cal/8009/1/50%zz.ics", davPath{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := parseDavPath(tt.path)
+			assert.Equal(t, tt.wantErr, err != nil)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_eventEtag(t *testing.T) {
+	cal := ics.NewCalendar()
+	e := cal.AddEvent("lesson")
+	e.SetSummary("Analisi")
+	e.SetDtStampTime(time.Date(2025, 10, 1, 0, 0, 0, 0, time.UTC))
+	etag := eventEtag(e)
+
+	e.SetDtStampTime(time.Date(2025, 10, 2, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, etag, eventEtag(e))
+
+	e.SetSummary("Algebra")
+	assert.NotEqual(t, etag, eventEtag(e))
+}
+
+func Test_newDavCollection(t *testing.T) {
+	cal := ics.NewCalendar()
+	for _, room := range []string{"Aula E2", "Aula E1"} {
+		e := cal.AddEvent("lesson")
+		e.SetSummary("Algebra")
+		e.SetLocation(room)
+		e.SetStartAt(time.Date(2025, 10, 21, 9, 0, 0, 0, time.UTC))
+		e.SetEndAt(time.Date(2025, 10, 21, 11, 0, 0, 0, time.UTC))
+	}
+
+	p := davPath{Kind: "cal", Course: 8009, Year: 1}
+	c := newDavCollection(p, cal)
+	assert.Equal(t, 2, len(c.Events))
+	assert.Equal(t, "/dav/cal/8009/1/lesson-2.ics", c.Events[0].Href)
+	assert.Equal(t, "/dav/cal/8009/1/lesson.ics", c.Events[1].Href)
+	assert.Equal(t, true, strings.Contains(c.Events[0].Data, "UID:lesson-2\n"))
+	assert.Equal(t, time.Date(2025, 10, 21, 9, 0, 0, 0, time.UTC), c.Events[0].Start.UTC())
+
+	// The order of the feed doesn't change the hrefs
+	reversed := ics.NewCalendar()
+	events := cal.Events()
+	reversed.AddVEvent(events[1])
+	reversed.AddVEvent(events[0])
+	assert.Equal(t, c.Events, newDavCollection(p, reversed).Events)
+}
+
+// testDavRouter serves the CalDAV server with a lessons feed with the
+// calendar, which can be changed between requests.
+func testDavRouter(calendar **bytes.Buffer) *gin.Engine {
+	feeds := func(feed *url.URL) (*bytes.Buffer, error) {
+		if !strings.HasPrefix(feed.Path, "/cal/8009/") {
+			return nil, &calError{http.StatusNotFound, "Course not found", nil}
+		}
+		return bytes.NewBuffer((*calendar).Bytes()), nil
+	}
+
+	r := gin.New()
+	for _, method := range append(davMethods, davWriteMethods...) {
+		r.Handle(method, "/dav/*path", caldavHandler(feeds))
+	}
+	return r
+}
+
+func davRequest(r http.Handler, method, path, depth, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if depth != "" {
+		req.Header.Set("Depth", depth)
+	}
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func Test_caldavPropfind(t *testing.T) {
+	calendar := testPreviewCalendar(t)
+	r := testDavRouter(&calendar)
+
+	props := `<d:propfind xmlns:d="DAV:" xmlns:cs="http://calendarserver.org/ns/">
+<d:prop><d:resourcetype/><d:displayname/><cs:getctag/><d:getetag/><d:owner/></d:prop></d:propfind>`
+
+	tests := []struct {
+		name     string
+		path     string
+		depth    string
+		wantCode int
+		want     []string
+		notWant  []string
+	}{
+		{"root", "/dav/", "0", http.StatusMultiStatus,
+			[]string{"<d:href>/dav/</d:href>", "<d:collection/>"}, []string{"<c:calendar/>"}},
+		{"collection", "/dav/cal/8009/1/", "0", http.StatusMultiStatus,
+			[]string{"<d:collection/><c:calendar/>", "<d:displayname>Informatica - 1 anno</d:displayname>", "<cs:getctag>", "<d:owner/>", davNotFound},
+			[]string{"lesson.ics"}},
+		{"members", "/dav/cal/8009/1/", "1", http.StatusMultiStatus,
+			[]string{"<d:href>/dav/cal/8009/1/lesson.ics</d:href>", "<d:href>/dav/cal/8009/1/holiday.ics</d:href>"}, nil},
+		{"event", "/dav/cal/8009/1/first.ics", "0", http.StatusMultiStatus,
+			[]string{"<d:href>/dav/cal/8009/1/first.ics</d:href>", "<d:getetag>&#34;"}, []string{"lesson.ics"}},
+		{"missing event", "/dav/cal/8009/1/missing.ics", "0", http.StatusNotFound, nil, nil},
+		{"missing course", "/dav/cal/1234/1/", "0", http.StatusNotFound, nil, nil},
+		{"invalid path", "/dav/cal/8009/", "0", http.StatusNotFound, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := davRequest(r, "PROPFIND", tt.path, tt.depth, props)
+			assert.Equal(t, tt.wantCode, w.Code)
+			for _, want := range tt.want {
+				assert.Equal(t, true, strings.Contains(w.Body.String(), want))
+			}
+			for _, notWant := range tt.notWant {
+				assert.Equal(t, false, strings.Contains(w.Body.String(), notWant))
+			}
+		})
+	}
+}
+
+func Test_caldavPrincipal(t *testing.T) {
+	calendar := testPreviewCalendar(t)
+	r := testDavRouter(&calendar)
+
+	props := `<d:propfind xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">
+<d:prop><d:resourcetype/><d:current-user-principal/><c:calendar-home-set/></d:prop></d:propfind>`
+
+	// The principal is its own calendar home, which lists no calendars
+	w := davRequest(r, "PROPFIND", "/dav/", "1", props)
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	body := w.Body.String()
+	assert.Equal(t, 1, strings.Count(body, "<d:response>"))
+	assert.Equal(t, true, strings.Contains(body, "<d:principal/>"))
+	assert.Equal(t, true, strings.Contains(body, "<d:current-user-principal><d:href>/dav/</d:href></d:current-user-principal>"))
+	assert.Equal(t, true, strings.Contains(body, "<c:calendar-home-set><d:href>/dav/</d:href></c:calendar-home-set>"))
+}
+
+func Test_caldavReport(t *testing.T) {
+	calendar := testPreviewCalendar(t)
+	r := testDavRouter(&calendar)
+
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+		want     []string
+		notWant  []string
+	}{
+		{"calendar-query", `<c:calendar-query xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">
+<d:prop><d:getetag/><c:calendar-data/></d:prop>
+<c:filter><c:comp-filter name="VCALENDAR"><c:comp-filter name="VEVENT">
+<c:time-range start="20251021T000000Z" end="20251022T000000Z"/>
+</c:comp-filter></c:comp-filter></c:filter></c:calendar-query>`, http.StatusMultiStatus,
+			[]string{"lesson.ics", "SUMMARY:Algebra\\, lineare"}, []string{"first.ics", "holiday.ics"}},
+		{"calendar-query todos", `<c:calendar-query xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">
+<d:prop><d:getetag/></d:prop>
+<c:filter><c:comp-filter name="VCALENDAR"><c:comp-filter name="VTODO"/></c:comp-filter></c:filter></c:calendar-query>`,
+			http.StatusMultiStatus, nil, []string{".ics"}},
+		{"calendar-query invalid range", `<c:calendar-query xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">
+<c:filter><c:comp-filter name="VCALENDAR"><c:comp-filter name="VEVENT"><c:time-range start="2025"/>
+</c:comp-filter></c:comp-filter></c:filter></c:calendar-query>`, http.StatusBadRequest, nil, nil},
+		{"calendar-multiget", `<c:calendar-multiget xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">
+<d:prop><d:getetag/><c:calendar-data/></d:prop>
+<d:href>/dav/cal/8009/1/holiday.ics</d:href><d:href>/dav/cal/8009/1/missing.ics</d:href>
+</c:calendar-multiget>`, http.StatusMultiStatus,
+			[]string{"SUMMARY:Ognissanti", "<d:href>/dav/cal/8009/1/missing.ics</d:href><d:status>" + davNotFound},
+			[]string{"lesson.ics"}},
+		{"unsupported", `<d:expand-property xmlns:d="DAV:"/>`, http.StatusForbidden, nil, nil},
+		{"invalid", `<c:calendar-query`, http.StatusBadRequest, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := davRequest(r, "REPORT", "/dav/cal/8009/1/", "1", tt.body)
+			assert.Equal(t, tt.wantCode, w.Code)
+			for _, want := range tt.want {
+				assert.Equal(t, true, strings.Contains(w.Body.String(), want))
+			}
+			for _, notWant := range tt.notWant {
+				assert.Equal(t, false, strings.Contains(w.Body.String(), notWant))
+			}
+		})
+	}
+}
+
+func Test_caldavSync(t *testing.T) {
+	calendar := testPreviewCalendar(t)
+	r := testDavRouter(&calendar)
+
+	sync := func(token string) *httptest.ResponseRecorder {
+		return davRequest(r, "REPORT", "/dav/cal/8009/1/", "", `<d:sync-collection xmlns:d="DAV:">
+<d:sync-token>`+token+`</d:sync-token><d:sync-level>1</d:sync-level><d:prop><d:getetag/></d:prop>
+</d:sync-collection>`)
+	}
+	token := func(w *httptest.ResponseRecorder) string {
+		body := w.Body.String()
+		start := strings.Index(body, "<d:sync-token>") + len("<d:sync-token>")
+		return body[start:strings.Index(body, "</d:sync-token>")]
+	}
+
+	w := sync("")
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.Equal(t, 3, strings.Count(w.Body.String(), "<d:response>"))
+	first := token(w)
+	assert.Equal(t, true, strings.HasPrefix(first, syncTokenPrefix))
+
+	w = sync(first)
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.Equal(t, 0, strings.Count(w.Body.String(), "<d:response>"))
+	assert.Equal(t, first, token(w))
+
+	// The lesson changes and the holiday is removed
+	cal, err := ics.ParseCalendar(testPreviewCalendar(t))
+	assert.Equal(t, nil, err)
+	cal.RemoveEvent("holiday")
+	for _, e := range cal.Events() {
+		if e.Id() == "lesson" {
+			e.SetLocation("Aula E2")
+		}
+	}
+	calendar = bytes.NewBufferString(cal.Serialize())
+
+	w = sync(first)
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	body := w.Body.String()
+	assert.Equal(t, 2, strings.Count(body, "<d:response>"))
+	assert.Equal(t, true, strings.Contains(body, "<d:href>/dav/cal/8009/1/lesson.ics</d:href><d:propstat>"))
+	assert.Equal(t, true, strings.Contains(body, "<d:href>/dav/cal/8009/1/holiday.ics</d:href><d:status>"+davNotFound))
+	assert.Equal(t, false, strings.Contains(body, "first.ics"))
+	assert.NotEqual(t, first, token(w))
+
+	w = sync(syncTokenPrefix + "unknown")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, true, strings.Contains(w.Body.String(), "<d:valid-sync-token/>"))
+}
+
+func Test_caldavHandler(t *testing.T) {
+	calendar := testPreviewCalendar(t)
+	r := testDavRouter(&calendar)
+
+	w := davRequest(r, http.MethodGet, "/dav/cal/8009/1/lesson.ics", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/calendar; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, 1, strings.Count(w.Body.String(), "BEGIN:VEVENT"))
+	assert.Equal(t, true, strings.Contains(w.Body.String(), "UID:lesson"))
+
+	req := httptest.NewRequest(http.MethodGet, "/dav/cal/8009/1/lesson.ics", nil)
+	req.Header.Set("If-None-Match", w.Header().Get("ETag"))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+
+	// The UID is unescaped once
+	cal, err := ics.ParseCalendar(testPreviewCalendar(t))
+	assert.Equal(t, nil, err)
+	cal.AddEvent("50%40").SetSummary("Percent")
+	calendar = bytes.NewBufferString(cal.Serialize())
+	w = davRequest(r, http.MethodGet, "/dav/cal/8009/1/50%2540.ics", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, true, strings.Contains(w.Body.String(), "UID:50%40"))
+	w = davRequest(r, "REPORT", "/dav/cal/8009/1/", "", `<c:calendar-multiget xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">
+<d:prop><d:getetag/></d:prop><d:href>/dav/cal/8009/1/50%2540.ics</d:href></c:calendar-multiget>`)
+	assert.Equal(t, true, strings.Contains(w.Body.String(), "<d:href>/dav/cal/8009/1/50%2540.ics</d:href><d:propstat>"))
+
+	w = davRequest(r, http.MethodGet, "/dav/cal/8009/1/000-000/", "", "")
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "/cal/8009/1?curr=000-000", w.Header().Get("Location"))
+
+	w = davRequest(r, http.MethodOptions, "/dav/", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, true, strings.Contains(w.Header().Get("DAV"), "calendar-access"))
+
+	w = davRequest(r, http.MethodPut, "/dav/cal/8009/1/new.ics", "", "BEGIN:VCALENDAR")
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.Equal(t, true, strings.Contains(w.Header().Get("Allow"), "PROPFIND"))
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/VaiTon/unibocalendar/unibo_integ"
+)
+
+const (
+	changeLogBucket = "changes"
+
+	// changeLogRetention is how long the changes are kept
+	changeLogRetention = time.Hour * 24 * 60
+	// maxFeedEntries is the number of changes in the Atom feeds, the latest ones
+	maxFeedEntries = 100
+)
+
+// changeLog stores the changes found by the refreshes. It is nil when the
+// store was not opened, in that case the changes are not kept.
+var changeLog *changeLogStore
+
+// changeLogStore is the embedded database of the changes. The keys are
+// "<course>-<year>-<detected>-<curriculum>", so the changes of a course
+// year are sorted by time.
+type changeLogStore struct {
+	db *bolt.DB
+}
+
+func changeLogPrefix(course, year int) []byte {
+	return []byte(fmt.Sprintf("%d-%d-", course, year))
+}
+
+// add stores the changes, removing the ones of the course year older than
+// changeLogRetention.
+func (s *changeLogStore) add(changes timetableChanges) error {
+	data, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+
+	prefix := changeLogPrefix(changes.Course, changes.Year)
+	key := fmt.Sprintf("%s%020d-%s", prefix, changes.Detected.UnixNano(), changes.Curriculum)
+	oldest := []byte(fmt.Sprintf("%s%020d", prefix, changes.Detected.Add(-changeLogRetention).UnixNano()))
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(changeLogBucket))
+
+		var expired [][]byte
+		c := b.Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix) && bytes.Compare(k, oldest) < 0; k, _ = c.Next() {
+			expired = append(expired, slices.Clone(k))
+		}
+		for _, k := range expired {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		return b.Put([]byte(key), data)
+	})
+}
+
+// list returns the changes of the course year, the latest first.
+func (s *changeLogStore) list(course, year int) ([]timetableChanges, error) {
+	prefix := changeLogPrefix(course, year)
+	list := make([]timetableChanges, 0)
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte(changeLogBucket)).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var changes timetableChanges
+			if err := json.Unmarshal(v, &changes); err != nil {
+				return err
+			}
+			list = append(list, changes)
+		}
+		return nil
+	})
+
+	slices.Reverse(list)
+	return list, err
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomEntry struct {
+	Id         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Updated    string         `xml:"updated"`
+	Summary    string         `xml:"summary"`
+	Categories []atomCategory `xml:"category"`
+	Link       atomLink       `xml:"link"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Id      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  string      `xml:"author>name"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+// formatChangeSlot returns when and where a changed lesson or exam takes
+// place, e.g. "20/10/2025 09:00-11:00, Aula E1".
+func formatChangeSlot(lang string, s *changeSlot) string {
+	start, end := s.Start.In(romeLocation), s.End.In(romeLocation)
+	text := start.Format("02/01/2006 15:04") + "-" + end.Format("15:04")
+	if s.Location != "" {
+		text += ", " + s.Location
+	}
+	if s.Online {
+		text += ", " + tr(lang, "changes.online")
+	}
+	return text
+}
+
+// changeEntry returns the entry of a change found at detected.
+func changeEntry(lang string, detected time.Time, c timetableChange, link string) atomEntry {
+	var summary []string
+	if c.Before != nil {
+		summary = append(summary, tr(lang, "changes.before", formatChangeSlot(lang, c.Before)))
+	}
+	if c.After != nil {
+		summary = append(summary, tr(lang, "changes.after", formatChangeSlot(lang, c.After)))
+	}
+	if c.Teacher != "" {
+		summary = append(summary, tr(lang, "cal.teacher", c.Teacher))
+	}
+
+	return atomEntry{
+		Id:         fmt.Sprintf("urn:almacalendar:change:%d:%s", detected.UnixNano(), c.hash()),
+		Title:      tr(lang, "changes."+string(c.Kind), c.Subject),
+		Updated:    detected.UTC().Format(time.RFC3339),
+		Summary:    strings.Join(summary, "\n"),
+		Categories: []atomCategory{{Term: string(c.Kind)}},
+		Link:       atomLink{Href: link},
+	}
+}
+
+// buildChangeFeed returns the Atom feed of the changes, the latest first.
+// Only the changes of the curriculum and of the subjects are kept, if given.
+// The same change found in more curricula within sameChangeWindow is a
+// single entry, the first one found, so that its id doesn't change.
+func buildChangeFeed(
+	lang string, course *unibo_integ.Course, year int, curr string, subjects []string, list []timetableChanges, self, link string,
+) atomFeed {
+	feed := atomFeed{
+		Id:     "urn:almacalendar:changes:" + strconv.Itoa(course.Codice) + ":" + strconv.Itoa(year),
+		Title:  tr(lang, "changes.feed.title", course.Descrizione, year),
+		Author: "AlmaCalendar",
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: self},
+			{Rel: "alternate", Type: "text/html", Href: link},
+		},
+		Entries: make([]atomEntry, 0),
+	}
+
+	// The feed is updated when the latest change was found
+	updated := time.Unix(0, 0)
+	// The changes are read from the oldest, to keep the first of the same ones
+	var found [][]atomEntry
+	first := make(map[string]time.Time)
+	for i := len(list) - 1; i >= 0; i-- {
+		changes := list[i]
+		if curr != "" && changes.Curriculum != curr {
+			continue
+		}
+
+		var entries []atomEntry
+		for _, c := range changes.Changes {
+			if len(subjects) != 0 && !slices.Contains(subjects, c.SubjectCode) {
+				continue
+			}
+
+			hash := c.hash()
+			if detected, ok := first[hash]; ok && changes.Detected.Sub(detected) < sameChangeWindow {
+				continue
+			}
+			first[hash] = changes.Detected
+			entries = append(entries, changeEntry(lang, changes.Detected, c, link))
+
+			if changes.Detected.After(updated) {
+				updated = changes.Detected
+			}
+		}
+		found = append(found, entries)
+	}
+	feed.Updated = updated.UTC().Format(time.RFC3339)
+
+	for i := len(found) - 1; i >= 0 && len(feed.Entries) < maxFeedEntries; i-- {
+		feed.Entries = append(feed.Entries, found[i][:min(len(found[i]), maxFeedEntries-len(feed.Entries))]...)
+	}
+
+	return feed
+}
+
+// requestScheme returns the scheme of the request, as seen by the client: a
+// reverse proxy terminating TLS tells it with X-Forwarded-Proto. The scheme of
+// baseUrl takes precedence, if configured.
+func requestScheme(ctx *gin.Context) string {
+	if baseUrl != nil {
+		return baseUrl.Scheme
+	}
+	if proto := ctx.GetHeader("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		return proto
+	}
+	if ctx.Request.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// getChangeFeed serves the changes of the timetable of a course year found
+// by the refreshes as an Atom feed: moved, added and cancelled lessons, room
+// changes and new exams. The "curr" and "subjects" query parameters select
+// the curriculum and the subjects, as for the calendars.
+func getChangeFeed(courses *unibo_integ.CoursesMap) func(c *gin.Context) {
+	return func(ctx *gin.Context) {
+		if changeLog == nil {
+			ctx.String(http.StatusServiceUnavailable, "Changes are not available")
+			return
+		}
+
+		year, err := strconv.Atoi(ctx.Param("anno"))
+		if err != nil {
+			ctx.String(http.StatusBadRequest, "Invalid year")
+			return
+		}
+		id, err := strconv.Atoi(ctx.Param("id"))
+		if err != nil {
+			ctx.String(http.StatusBadRequest, "Invalid id")
+			return
+		}
+
+		course, found := courses.FindById(id)
+		if !found {
+			ctx.String(http.StatusNotFound, "Course not found")
+			return
+		}
+		if year <= 0 || year > course.DurataAnni {
+			ctx.String(http.StatusBadRequest, "Invalid year")
+			return
+		}
+
+		var subjects []string
+		for _, s := range strings.Split(ctx.Query("subjects"), ",") {
+			if s != "" {
+				subjects = append(subjects, s)
+			}
+		}
+
+		list, err := changeLog.list(course.Codice, year)
+		if err != nil {
+			_ = ctx.Error(err)
+			ctx.String(http.StatusInternalServerError, "Unable to read changes")
+			return
+		}
+
+		host, ok := publicHost(ctx)
+		if !ok {
+			ctx.String(http.StatusBadRequest, "Invalid host")
+			return
+		}
+
+		base := requestScheme(ctx) + "://" + host
+		self := base + ctx.Request.URL.RequestURI()
+		link := fmt.Sprintf("%s/courses/%d", base, course.Codice)
+
+		feed := buildChangeFeed(feedLang(ctx), course, year, ctx.Query("curr"), subjects, list, self, link)
+		body, err := xml.MarshalIndent(feed, "", "  ")
+		if err != nil {
+			_ = ctx.Error(err)
+			ctx.String(http.StatusInternalServerError, "Unable to write feed")
+			return
+		}
+
+		ctx.Data(http.StatusOK, "application/atom+xml; charset=utf-8", append([]byte(xml.Header), body...))
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/assert/v2"
+
+	"github.com/VaiTon/unibocalendar/unibo_integ"
+)
+
+func testChanges(curr string, detected time.Time, subject string) timetableChanges {
+	start := time.Date(2025, 10, 21, 9, 0, 0, 0, romeLocation)
+	return timetableChanges{
+		Course:     8009,
+		Year:       1,
+		Curriculum: curr,
+		Detected:   detected,
+		Changes: []timetableChange{{
+			Kind:        changeLessonMoved,
+			SubjectCode: subject,
+			Subject:     "Analisi",
+			Before:      &changeSlot{Start: start, End: start.Add(time.Hour * 2), Location: "Aula E1"},
+			After:       &changeSlot{Start: start.Add(time.Hour * 24), End: start.Add(time.Hour * 26), Location: "Aula E2"},
+		}},
+	}
+}
+
+func Test_changeLogStore(t *testing.T) {
+	store := &changeLogStore{db: testDatabase(t)}
+
+	now := time.Date(2025, 10, 20, 9, 0, 0, 0, time.UTC)
+	expired := testChanges("A", now.Add(-changeLogRetention-time.Hour), "1")
+	assert.Equal(t, nil, store.add(expired))
+	assert.Equal(t, nil, store.add(testChanges("A", now.Add(-time.Hour), "2")))
+	assert.Equal(t, nil, store.add(testChanges("B", now, "3")))
+
+	other := testChanges("A", now, "4")
+	other.Year = 10
+	assert.Equal(t, nil, store.add(other))
+
+	list, err := store.list(8009, 1)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(list))
+	assert.Equal(t, "B", list[0].Curriculum)
+	assert.Equal(t, "2", list[1].Changes[0].SubjectCode)
+
+	list, err = store.list(8009, 10)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(list))
+}
+
+func Test_buildChangeFeed(t *testing.T) {
+	course := &unibo_integ.Course{Codice: 8009, Descrizione: "Informatica", DurataAnni: 3}
+	now := time.Date(2025, 10, 20, 9, 0, 0, 0, time.UTC)
+
+	// The same change found in two curricula, and an older one
+	list := []timetableChanges{
+		testChanges("B", now, "1"),
+		testChanges("A", now.Add(-time.Minute), "1"),
+		testChanges("A", now.Add(-time.Hour), "2"),
+	}
+	// The same change found again later, e.g. after the lesson was moved back
+	again := append([]timetableChanges{testChanges("A", now.Add(sameChangeWindow), "1")}, list...)
+
+	tests := []struct {
+		name        string
+		list        []timetableChanges
+		curr        string
+		subjects    []string
+		wantLen     int
+		wantUpdated time.Time
+	}{
+		{"all", list, "", nil, 2, now.Add(-time.Minute)},
+		{"curriculum", list, "A", nil, 2, now.Add(-time.Minute)},
+		{"other curriculum", list, "B", nil, 1, now},
+		{"subjects", list, "", []string{"2"}, 1, now.Add(-time.Hour)},
+		{"none", list, "C", nil, 0, time.Unix(0, 0)},
+		{"found again", again, "", nil, 3, now.Add(sameChangeWindow)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			feed := buildChangeFeed("it", course, 1, tt.curr, tt.subjects, tt.list, "https://example.com/feeds/changes/8009/1", "https://example.com/courses/8009")
+			assert.Equal(t, tt.wantLen, len(feed.Entries))
+			assert.Equal(t, tt.wantUpdated.UTC().Format(time.RFC3339), feed.Updated)
+		})
+	}
+
+	feed := buildChangeFeed("it", course, 1, "", nil, list, "", "")
+	entry := feed.Entries[0]
+	assert.Equal(t, "Lezione spostata: Analisi", entry.Title)
+	assert.Equal(t, "Prima: 21/10/2025 09:00-11:00, Aula E1\nOra: 22/10/2025 09:00-11:00, Aula E2", entry.Summary)
+	assert.Equal(t, now.Add(-time.Minute).Format(time.RFC3339), entry.Updated)
+	assert.Equal(t, true, strings.HasPrefix(entry.Id, "urn:almacalendar:change:"))
+
+	// Finding the change in another curriculum doesn't change the entry
+	assert.Equal(t, entry.Id, buildChangeFeed("it", course, 1, "", nil, list[1:], "", "").Entries[0].Id)
+
+	// Finding it again later is another entry
+	feed = buildChangeFeed("it", course, 1, "", nil, again, "", "")
+	assert.NotEqual(t, entry.Id, feed.Entries[0].Id)
+	assert.Equal(t, entry.Id, feed.Entries[1].Id)
+}
+
+func Test_getChangeFeed(t *testing.T) {
+	store := &changeLogStore{db: testDatabase(t)}
+
+	changeLog = store
+	defer func() { changeLog = nil }()
+
+	assert.Equal(t, nil, store.add(testChanges("A", time.Now(), "1")))
+
+	r := setupRouter(unibo_integ.CoursesMap{8009: {Codice: 8009, Descrizione: "Informatica", DurataAnni: 3}})
+
+	tests := []struct {
+		url      string
+		wantCode int
+		wantLen  int
+	}{
+		{"/feeds/changes/8009/1", http.StatusOK, 1},
+		{"/feeds/changes/8009/1?subjects=2,3", http.StatusOK, 0},
+		{"/feeds/changes/8009/2", http.StatusOK, 0},
+		{"/feeds/changes/8009/4", http.StatusBadRequest, 0},
+		{"/feeds/changes/1234/1", http.StatusNotFound, 0},
+		{"/feeds/changes/abc/1", http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.url, nil))
+			assert.Equal(t, tt.wantCode, w.Code)
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			assert.Equal(t, "application/atom+xml; charset=utf-8", w.Header().Get("Content-Type"))
+			var feed atomFeed
+			assert.Equal(t, nil, xml.Unmarshal(w.Body.Bytes(), &feed))
+			assert.Equal(t, tt.wantLen, len(feed.Entries))
+			assert.Equal(t, "http://example.com"+tt.url, feed.Links[0].Href)
+		})
+	}
+}
+
+func Test_requestScheme(t *testing.T) {
+	tests := []struct {
+		name  string
+		url   string
+		proto string
+		want  string
+	}{
+		{"http", "http://example.com/", "", "http"},
+		{"tls", "https://example.com/", "", "https"},
+		{"proxy", "http://example.com/", "https", "https"},
+		{"invalid proxy header", "https://example.com/", "ftp", "https"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+			ctx.Request = httptest.NewRequest(http.MethodGet, tt.url, nil)
+			if tt.proto != "" {
+				ctx.Request.Header.Set("X-Forwarded-Proto", tt.proto)
+			}
+			assert.Equal(t, tt.want, requestScheme(ctx))
+		})
+	}
+	// The configured address takes precedence over the request
+	baseUrl, _ = parseBaseUrl("https://calendar.example.com")
+	defer func() { baseUrl = nil }()
+
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	assert.Equal(t, "https", requestScheme(ctx))
+}
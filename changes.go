@@ -0,0 +1,362 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/cartabinaria/unibo-go/curriculum"
+	"github.com/cartabinaria/unibo-go/exams"
+	"github.com/cartabinaria/unibo-go/timetable"
+	"github.com/patrickmn/go-cache"
+	"github.com/rs/zerolog/log"
+
+	"github.com/VaiTon/unibocalendar/unibo_integ"
+)
+
+// sameChangeWindow is how long a change found again in another curriculum of
+// the same course year is considered the same change. The curricula of a
+// course year share most lessons, so a change is usually found once for each.
+const sameChangeWindow = time.Hour * 24
+
+// changeKind is the kind of a change of a timetable.
+type changeKind string
+
+const (
+	changeLessonAdded     changeKind = "lesson_added"
+	changeLessonMoved     changeKind = "lesson_moved"
+	changeLessonRoom      changeKind = "lesson_room_changed"
+	changeLessonCancelled changeKind = "lesson_cancelled"
+	changeExamAdded       changeKind = "exam_added"
+)
+
+var changeKinds = []changeKind{
+	changeLessonAdded, changeLessonMoved, changeLessonRoom, changeLessonCancelled, changeExamAdded,
+}
+
+// changeSlot is when and where a lesson or an exam takes place.
+type changeSlot struct {
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Location string    `json:"location,omitempty"`
+	Online   bool      `json:"online,omitempty"`
+}
+
+// timetableChange is a lesson or an exam that changed. Before is nil for the
+// added ones, After for the cancelled ones.
+type timetableChange struct {
+	Kind        changeKind  `json:"kind"`
+	SubjectCode string      `json:"subject_code"`
+	Subject     string      `json:"subject"`
+	Teacher     string      `json:"teacher,omitempty"`
+	Before      *changeSlot `json:"before,omitempty"`
+	After       *changeSlot `json:"after,omitempty"`
+}
+
+// start returns the start of the change, after it if any.
+func (c timetableChange) start() time.Time {
+	if c.After != nil {
+		return c.After.Start
+	}
+	return c.Before.Start
+}
+
+// hash identifies the change, regardless of when and in which curriculum it
+// was found.
+func (c timetableChange) hash() string {
+	data, _ := json.Marshal(c)
+	return fmt.Sprintf("%x", sha1.Sum(data))
+}
+
+// timetableChanges are the changes found refreshing the timetable of a
+// course year and curriculum.
+type timetableChanges struct {
+	Course     int               `json:"course"`
+	Year       int               `json:"year"`
+	Curriculum string            `json:"curriculum,omitempty"`
+	Detected   time.Time         `json:"detected"`
+	Changes    []timetableChange `json:"changes"`
+}
+
+func lessonSlot(e timetable.Event) *changeSlot {
+	return &changeSlot{
+		Start:    e.Start.Time,
+		End:      e.End.Time,
+		Location: lessonLocation(e, locationShort),
+		Online:   lessonOnline(e),
+	}
+}
+
+func lessonChange(kind changeKind, e timetable.Event, before, after *changeSlot) timetableChange {
+	return timetableChange{
+		Kind:        kind,
+		SubjectCode: e.CodModulo,
+		Subject:     e.Title,
+		Teacher:     e.Teacher,
+		Before:      before,
+		After:       after,
+	}
+}
+
+// diffTimetables returns how the lessons changed from old to new. A lesson
+// is identified by its subject, part and times: when a lesson of a subject
+// part disappears and another one appears, the lesson moved. Lessons ended before
+// now are ignored.
+func diffTimetables(old, new timetable.Timetable, now time.Time) []timetableChange {
+	key := func(e timetable.Event) string {
+		return fmt.Sprintf("%s|%s|%s|%s", e.CodModulo, e.CodSdoppiamento, e.Start.Time, e.End.Time)
+	}
+
+	oldByKey := make(map[string]timetable.Event, len(old))
+	for _, e := range old {
+		oldByKey[key(e)] = e
+	}
+	newKeys := make(map[string]bool, len(new))
+
+	changes := make([]timetableChange, 0)
+	// The removed and the added lessons, by subject and part
+	removed := make(map[string][]timetable.Event)
+	added := make(map[string][]timetable.Event)
+	var groups []string
+
+	for _, e := range new {
+		newKeys[key(e)] = true
+		if !e.End.After(now) {
+			continue
+		}
+
+		if o, found := oldByKey[key(e)]; found {
+			before, after := lessonSlot(o), lessonSlot(e)
+			if before.Location != after.Location || before.Online != after.Online {
+				changes = append(changes, lessonChange(changeLessonRoom, e, before, after))
+			}
+			continue
+		}
+
+		group := e.CodModulo + "|" + e.CodSdoppiamento
+		if !slices.Contains(groups, group) {
+			groups = append(groups, group)
+		}
+		added[group] = append(added[group], e)
+	}
+
+	for _, e := range old {
+		if newKeys[key(e)] || !e.End.After(now) {
+			continue
+		}
+
+		group := e.CodModulo + "|" + e.CodSdoppiamento
+		if !slices.Contains(groups, group) {
+			groups = append(groups, group)
+		}
+		removed[group] = append(removed[group], e)
+	}
+
+	byStart := func(a, b timetable.Event) int {
+		return a.Start.Compare(b.Start.Time)
+	}
+	for _, group := range groups {
+		r, a := removed[group], added[group]
+		slices.SortFunc(r, byStart)
+		slices.SortFunc(a, byStart)
+
+		// The lessons are paired in order, the others are new or cancelled
+		moved := min(len(r), len(a))
+		for i := 0; i < moved; i++ {
+			changes = append(changes, lessonChange(changeLessonMoved, a[i], lessonSlot(r[i]), lessonSlot(a[i])))
+		}
+		for _, e := range r[moved:] {
+			changes = append(changes, lessonChange(changeLessonCancelled, e, lessonSlot(e), nil))
+		}
+		for _, e := range a[moved:] {
+			changes = append(changes, lessonChange(changeLessonAdded, e, nil, lessonSlot(e)))
+		}
+	}
+
+	slices.SortStableFunc(changes, func(a, b timetableChange) int {
+		return a.start().Compare(b.start())
+	})
+	return changes
+}
+
+// diffExams returns the exams of new that were not in old. Past exams are
+// ignored.
+func diffExams(old, new []exams.Exam, now time.Time) []timetableChange {
+	key := func(e exams.Exam) string {
+		return e.SubjectCode + "|" + e.Date.String()
+	}
+
+	known := make(map[string]bool, len(old))
+	for _, e := range old {
+		known[key(e)] = true
+	}
+
+	changes := make([]timetableChange, 0)
+	for _, e := range new {
+		if known[key(e)] || !e.Date.After(now) {
+			continue
+		}
+		known[key(e)] = true
+
+		changes = append(changes, timetableChange{
+			Kind:        changeExamAdded,
+			SubjectCode: e.SubjectCode,
+			Subject:     e.SubjectName,
+			Teacher:     e.Teacher,
+			After: &changeSlot{
+				Start:    e.Date,
+				End:      e.Date.Add(examDuration(e)),
+				Location: e.Location,
+			},
+		})
+	}
+
+	slices.SortStableFunc(changes, func(a, b timetableChange) int {
+		return a.start().Compare(b.start())
+	})
+	return changes
+}
+
+// The last timetables and exams fetched, to find what changed at the next
+// refresh. They are kept since the start of the server, so the first fetch
+// of every timetable finds no change.
+var (
+	snapshotsMu        sync.Mutex
+	timetableSnapshots = make(map[string]timetable.Timetable)
+	examSnapshots      = make(map[string][]exams.Exam)
+
+	// notifiedChanges are the changes of every course year sent to the
+	// webhooks in the last sameChangeWindow
+	notifiedChanges = cache.New(sameChangeWindow, time.Hour)
+)
+
+// swapSnapshot stores value in m and returns the previous one, if any.
+func swapSnapshot[T any](m map[string]T, key string, value T) (T, bool) {
+	snapshotsMu.Lock()
+	defer snapshotsMu.Unlock()
+
+	old, found := m[key]
+	m[key] = value
+	return old, found
+}
+
+// newChanges returns the changes not already found in another curriculum of
+// the course year.
+func newChanges(course, year int, changes []timetableChange) []timetableChange {
+	fresh := make([]timetableChange, 0, len(changes))
+	for _, c := range changes {
+		key := fmt.Sprintf("%d-%d-%s", course, year, c.hash())
+		if notifiedChanges.Add(key, true, cache.DefaultExpiration) == nil {
+			fresh = append(fresh, c)
+		}
+	}
+	return fresh
+}
+
+// recordChanges adds the changes, if any, to the change log and notifies
+// the webhooks.
+func recordChanges(course, year int, curr string, changes []timetableChange) {
+	if len(changes) == 0 {
+		return
+	}
+
+	c := timetableChanges{
+		Course:     course,
+		Year:       year,
+		Curriculum: curr,
+		Detected:   time.Now(),
+		Changes:    changes,
+	}
+	if changeLog != nil {
+		if err := changeLog.add(c); err != nil {
+			log.Err(err).Int("course-code", course).Int("year", year).Msg("unable to store changes")
+		}
+	}
+	go notifyWebhooks(c, newChanges(course, year, changes))
+}
+
+// recordTimetable compares the timetable just fetched with the previous one
+// of the same course year and curriculum.
+func recordTimetable(course, year int, curr string, t timetable.Timetable) {
+	key := fmt.Sprintf("%d-%d-%s", course, year, curr)
+	if old, found := swapSnapshot(timetableSnapshots, key, t); found {
+		recordChanges(course, year, curr, diffTimetables(old, t, time.Now()))
+	}
+}
+
+// recordExams compares the exams just fetched with the previous ones of the
+// same course year and curriculum.
+func recordExams(course, year int, curr string, e []exams.Exam) {
+	key := fmt.Sprintf("%d-%d-%s", course, year, curr)
+	if old, found := swapSnapshot(examSnapshots, key, e); found {
+		recordChanges(course, year, curr, diffExams(old, e, time.Now()))
+	}
+}
+
+// refreshExams fetches the exams of the course, so that new exams are found
+// even if nobody requests them. Every curriculum is refreshed when the
+// changes are kept for the feeds, otherwise only the ones watched by
+// webhooks. The exams are fetched once and matched with the subjects of
+// every curriculum.
+func refreshExams(course unibo_integ.Course, curricula map[int]curriculum.Curricula) {
+	if changeLog == nil && webhooks == nil {
+		return
+	}
+
+	var hooks []webhook
+	if changeLog == nil {
+		var err error
+		hooks, err = webhooks.watching(course.Codice)
+		if err != nil {
+			log.Err(err).Int("course-code", course.Codice).Msg("unable to get webhooks")
+			return
+		}
+	}
+
+	// The exams and the subjects are read only if a curriculum is refreshed
+	var matcher *examMatcher
+	var subjectsMap subjectMap
+	for year, cs := range curricula {
+		for _, c := range cs {
+			watched := changeLog != nil || slices.ContainsFunc(hooks, func(h webhook) bool {
+				return h.Filter.watches(course.Codice, year, c.Value) &&
+					(len(h.Filter.Kinds) == 0 || slices.Contains(h.Filter.Kinds, changeExamAdded))
+			})
+			if !watched {
+				continue
+			}
+
+			if matcher == nil {
+				m, err := fetchExamMatcher(&course)
+				if err != nil {
+					log.Err(err).Int("course-code", course.Codice).Msg("unable to refresh exams")
+					return
+				}
+				subjectsMap, err = getSubjectsMapFromCourseAndCurricula(&course, curricula)
+				if err != nil {
+					log.Err(err).Int("course-code", course.Codice).Msg("unable to refresh exams")
+					return
+				}
+				matcher = &m
+			}
+			recordExams(course.Codice, year, c.Value, matcher.matchAll(subjectsMap[year][c]))
+		}
+	}
+}
+
+// fetchExamMatcher fetches every exam of the course.
+func fetchExamMatcher(course *unibo_integ.Course) (examMatcher, error) {
+	courseID, err := course.GetCourseWebsiteId()
+	if err != nil {
+		return examMatcher{}, fmt.Errorf("unable to get course website id: %w", err)
+	}
+
+	allExams, err := exams.GetExams(courseID.Tipologia, courseID.Id)
+	if err != nil {
+		return examMatcher{}, fmt.Errorf("unable to get exams: %w", err)
+	}
+	return newExamMatcher(allExams), nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cartabinaria/unibo-go/exams"
+	"github.com/cartabinaria/unibo-go/timetable"
+	"github.com/go-playground/assert/v2"
+)
+
+func testLesson(code string, start time.Time, room string) timetable.Event {
+	return timetable.Event{
+		CodModulo:  code,
+		Title:      "Subject " + code,
+		Start:      timetable.CalendarTime{Time: start},
+		End:        timetable.CalendarTime{Time: start.Add(time.Hour * 2)},
+		Classrooms: []timetable.Classroom{{ResourceDesc: room}},
+	}
+}
+
+func Test_diffTimetables(t *testing.T) {
+	now := time.Date(2025, 10, 20, 12, 0, 0, 0, time.UTC)
+	past := now.Add(-time.Hour * 48)
+	day := func(d int) time.Time { return now.Add(time.Hour * 24 * time.Duration(d)) }
+
+	// Two parallel sessions of the same subject, in different rooms
+	split := func(part, room string) timetable.Event {
+		e := testLesson("1", day(1), room)
+		e.CodSdoppiamento = part
+		return e
+	}
+
+	tests := []struct {
+		name      string
+		old, new  timetable.Timetable
+		wantKinds []changeKind
+	}{
+		{"same", timetable.Timetable{testLesson("1", day(1), "A")}, timetable.Timetable{testLesson("1", day(1), "A")}, []changeKind{}},
+		{"added", timetable.Timetable{}, timetable.Timetable{testLesson("1", day(1), "A")}, []changeKind{changeLessonAdded}},
+		{"cancelled", timetable.Timetable{testLesson("1", day(1), "A")}, timetable.Timetable{}, []changeKind{changeLessonCancelled}},
+		{"moved", timetable.Timetable{testLesson("1", day(1), "A")}, timetable.Timetable{testLesson("1", day(2), "A")}, []changeKind{changeLessonMoved}},
+		{"room", timetable.Timetable{testLesson("1", day(1), "A")}, timetable.Timetable{testLesson("1", day(1), "B")}, []changeKind{changeLessonRoom}},
+		{"past", timetable.Timetable{testLesson("1", past, "A")}, timetable.Timetable{}, []changeKind{}},
+		{"other subject", timetable.Timetable{testLesson("1", day(1), "A")}, timetable.Timetable{testLesson("2", day(2), "A")},
+			[]changeKind{changeLessonCancelled, changeLessonAdded}},
+		{"moved and added", timetable.Timetable{testLesson("1", day(1), "A")},
+			timetable.Timetable{testLesson("1", day(2), "A"), testLesson("1", day(3), "A")},
+			[]changeKind{changeLessonMoved, changeLessonAdded}},
+		{"parallel sessions", timetable.Timetable{split("A", "A"), split("B", "B")},
+			timetable.Timetable{split("A", "A"), split("B", "B")}, []changeKind{}},
+		{"parallel sessions reordered", timetable.Timetable{split("A", "A"), split("B", "B")},
+			timetable.Timetable{split("B", "B"), split("A", "A")}, []changeKind{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes := diffTimetables(tt.old, tt.new, now)
+			kinds := make([]changeKind, 0, len(changes))
+			for _, c := range changes {
+				kinds = append(kinds, c.Kind)
+			}
+			assert.Equal(t, tt.wantKinds, kinds)
+		})
+	}
+
+	changes := diffTimetables(timetable.Timetable{testLesson("1", day(1), "A")}, timetable.Timetable{testLesson("1", day(2), "B")}, now)
+	assert.Equal(t, 1, len(changes))
+	assert.Equal(t, "Subject 1", changes[0].Subject)
+	assert.Equal(t, day(1), changes[0].Before.Start)
+	assert.Equal(t, day(2), changes[0].After.Start)
+	assert.Equal(t, "B", changes[0].After.Location)
+}
+
+func Test_diffExams(t *testing.T) {
+	now := time.Date(2025, 10, 20, 12, 0, 0, 0, time.UTC)
+	first := exams.Exam{SubjectCode: "1", SubjectName: "Analisi", Date: now.Add(time.Hour * 24)}
+	second := exams.Exam{SubjectCode: "1", SubjectName: "Analisi", Date: now.Add(time.Hour * 48), Location: "Aula A"}
+	past := exams.Exam{SubjectCode: "2", Date: now.Add(-time.Hour)}
+
+	changes := diffExams([]exams.Exam{first}, []exams.Exam{first, second, past}, now)
+	assert.Equal(t, 1, len(changes))
+	assert.Equal(t, changeExamAdded, changes[0].Kind)
+	assert.Equal(t, "Analisi", changes[0].Subject)
+	assert.Equal(t, second.Date, changes[0].After.Start)
+	assert.Equal(t, "Aula A", changes[0].After.Location)
+
+	assert.Equal(t, 0, len(diffExams([]exams.Exam{first, second}, []exams.Exam{second}, now)))
+}
+
+func Test_newChanges(t *testing.T) {
+	now := time.Date(2025, 10, 20, 12, 0, 0, 0, time.UTC)
+	first := timetableChange{Kind: changeLessonAdded, SubjectCode: "1", After: &changeSlot{Start: now, End: now}}
+	second := timetableChange{Kind: changeLessonAdded, SubjectCode: "2", After: &changeSlot{Start: now, End: now}}
+
+	assert.Equal(t, []timetableChange{first}, newChanges(1, 1, []timetableChange{first}))
+	// Found again in another curriculum
+	assert.Equal(t, []timetableChange{second}, newChanges(1, 1, []timetableChange{first, second}))
+	// Another year
+	assert.Equal(t, []timetableChange{first}, newChanges(1, 2, []timetableChange{first}))
+}
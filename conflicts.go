@@ -0,0 +1,188 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+	"github.com/cartabinaria/unibo-go/timetable"
+	"github.com/gin-gonic/gin"
+	"github.com/patrickmn/go-cache"
+
+	"github.com/VaiTon/unibocalendar/unibo_integ"
+)
+
+// conflictMarker is prepended to the summary of conflicting lessons
+const conflictMarker = "⚠ "
+
+// conflictEvent is a lesson involved in a conflict.
+type conflictEvent struct {
+	Uid   string    `json:"uid"`
+	Code  string    `json:"code"`
+	Title string    `json:"title"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Rooms []string  `json:"rooms"`
+}
+
+// conflict is a pair of lessons of different subjects that overlap.
+type conflict struct {
+	First   conflictEvent `json:"first"`
+	Second  conflictEvent `json:"second"`
+	Start   time.Time     `json:"start"`
+	End     time.Time     `json:"end"`
+	Minutes int           `json:"minutes"`
+}
+
+func newConflictEvent(event timetable.Event) conflictEvent {
+	uid, _ := lessonUid(event)
+
+	// Only the physical rooms, as in the location of the lessons
+	classrooms := physicalClassrooms(event)
+	rooms := make([]string, 0, len(classrooms))
+	for _, c := range classrooms {
+		rooms = append(rooms, c.ResourceDesc)
+	}
+
+	return conflictEvent{
+		Uid:   uid,
+		Code:  event.CodModulo,
+		Title: event.Title,
+		Start: event.Start.Time,
+		End:   event.End.Time,
+		Rooms: rooms,
+	}
+}
+
+// findConflicts returns every pair of overlapping lessons in the timetable,
+// sorted by start. Lessons of the same module never conflict, because they
+// are alternative groups of the same subject (e.g. A-K and L-Z).
+func findConflicts(t timetable.Timetable) []conflict {
+	sorted := slices.Clone(t)
+	slices.SortFunc(sorted, func(a, b timetable.Event) int {
+		return a.Start.Compare(b.Start.Time)
+	})
+
+	conflicts := make([]conflict, 0)
+	active := make([]timetable.Event, 0)
+	for _, event := range sorted {
+		// Forget the lessons that ended before this one started
+		active = slices.DeleteFunc(active, func(a timetable.Event) bool {
+			return !a.End.After(event.Start.Time)
+		})
+
+		for _, other := range active {
+			if other.CodModulo == event.CodModulo {
+				continue
+			}
+
+			end := other.End.Time
+			if event.End.Before(end) {
+				end = event.End.Time
+			}
+
+			conflicts = append(conflicts, conflict{
+				First:   newConflictEvent(other),
+				Second:  newConflictEvent(event),
+				Start:   event.Start.Time,
+				End:     end,
+				Minutes: int(end.Sub(event.Start.Time).Minutes()),
+			})
+		}
+
+		active = append(active, event)
+	}
+
+	return conflicts
+}
+
+// markConflicts marks the conflicting lessons of the calendar, prepending
+// conflictMarker to their summary and listing the overlapping subjects in
+// their description. A lesson overlapping several others is marked once.
+func markConflicts(cal *ics.Calendar, conflicts []conflict, lang string) {
+	overlaps := make(map[string][]string)
+	addOverlap := func(uid, title string) {
+		if !slices.Contains(overlaps[uid], title) {
+			overlaps[uid] = append(overlaps[uid], title)
+		}
+	}
+	for _, c := range conflicts {
+		addOverlap(c.First.Uid, c.Second.Title)
+		addOverlap(c.Second.Uid, c.First.Title)
+	}
+
+	marked := make(map[string]struct{})
+	for _, e := range cal.Events() {
+		titles, ok := overlaps[e.Id()]
+		if !ok {
+			continue
+		}
+		if _, ok := marked[e.Id()]; ok {
+			continue
+		}
+		marked[e.Id()] = struct{}{}
+
+		if summary := e.GetProperty(ics.ComponentPropertySummary); summary != nil {
+			e.SetSummary(conflictMarker + summary.Value)
+		}
+
+		description := ""
+		if d := e.GetProperty(ics.ComponentPropertyDescription); d != nil {
+			description = d.Value
+		}
+		e.SetDescription(description + tr(lang, "cal.conflict.overlaps", strings.Join(titles, ", ")) + "\n")
+	}
+}
+
+// selectionsConflicts returns the conflicts of the selections. They are
+// cached like the personal calendars, and concurrent requests for the same
+// selections share a single search.
+func selectionsConflicts(selections []feedSelection, courses *unibo_integ.CoursesMap) ([]conflict, error) {
+	keys := make([]string, len(selections))
+	for i, sel := range selections {
+		keys[i] = sel.String()
+	}
+	key := "conflicts-" + strings.Join(keys, "|")
+	if conflicts, found := calcache.Get(key); found {
+		return conflicts.([]conflict), nil
+	}
+
+	conflicts, err, _ := calGroup.Do(key, func() (any, error) {
+		t, err := getSelectionsTimetable(selections, courses)
+		if err != nil {
+			return nil, err
+		}
+
+		conflicts := findConflicts(t)
+		calcache.Set(key, conflicts, cache.DefaultExpiration)
+		return conflicts, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return conflicts.([]conflict), nil
+}
+
+func getConflicts(courses *unibo_integ.CoursesMap) func(c *gin.Context) {
+	return func(ctx *gin.Context) {
+		selections, err := parseFeedSelections(ctx.QueryArray("sel"), courses)
+		if err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		conflicts, err := selectionsConflicts(selections, courses)
+		if err != nil {
+			_ = ctx.Error(err)
+			ctx.String(http.StatusInternalServerError, "Unable to retrieve timetable")
+			return
+		}
+
+		ctx.JSON(http.StatusOK, gin.H{
+			"count":     len(conflicts),
+			"conflicts": conflicts,
+		})
+	}
+}
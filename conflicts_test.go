@@ -0,0 +1,98 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+	"github.com/cartabinaria/unibo-go/timetable"
+	"github.com/go-playground/assert/v2"
+
+	"github.com/VaiTon/unibocalendar/unibo_integ"
+)
+
+func Test_findConflicts(t *testing.T) {
+	start := time.Date(2024, 10, 1, 9, 0, 0, 0, romeLocation)
+	lesson := func(code string, from, to time.Duration, other ...timetable.Classroom) timetable.Event {
+		return timetable.Event{
+			CodModulo:  code,
+			Title:      "Subject " + code,
+			Start:      timetable.CalendarTime{Time: start.Add(from)},
+			End:        timetable.CalendarTime{Time: start.Add(to)},
+			Classrooms: append([]timetable.Classroom{{ResourceDesc: "AULA " + code}}, other...),
+		}
+	}
+
+	tt := timetable.Timetable{
+		lesson("B", time.Hour, 3*time.Hour, timetable.Classroom{ResourceDesc: "Aula virtuale Teams"}),
+		lesson("A", 0, 2*time.Hour),
+		lesson("A", 0, 2*time.Hour+30*time.Minute), // other group of the same module
+		lesson("C", 3*time.Hour, 4*time.Hour),      // starts when B ends
+	}
+
+	conflicts := findConflicts(tt)
+	assert.Equal(t, 2, len(conflicts))
+
+	assert.Equal(t, "A", conflicts[0].First.Code)
+	assert.Equal(t, "B", conflicts[0].Second.Code)
+	assert.Equal(t, start.Add(time.Hour), conflicts[0].Start)
+	assert.Equal(t, 60, conflicts[0].Minutes)
+	assert.Equal(t, []string{"AULA A"}, conflicts[0].First.Rooms)
+	assert.Equal(t, []string{"AULA B"}, conflicts[0].Second.Rooms)
+
+	assert.Equal(t, 90, conflicts[1].Minutes)
+
+	cal := ics.NewCalendar()
+	for _, event := range tt {
+		_ = addLessonEvent(cal, event, lessonOptions{})
+	}
+	markConflicts(cal, conflicts, langIt)
+
+	marked := 0
+	for _, e := range cal.Events() {
+		summary := e.GetProperty(ics.ComponentPropertySummary).Value
+		if strings.HasPrefix(summary, conflictMarker) {
+			marked++
+		}
+		// B overlaps both lessons of A, but is marked once
+		assert.Equal(t, false, strings.HasPrefix(summary, conflictMarker+conflictMarker))
+		if strings.HasSuffix(summary, "Subject B") {
+			description := e.GetProperty(ics.ComponentPropertyDescription).Value
+			assert.Equal(t, 1, strings.Count(description, "Subject A"))
+		}
+	}
+	assert.Equal(t, 3, marked)
+}
+
+func Test_selectionsConflicts(t *testing.T) {
+	defer timetableCache.Flush()
+	defer calcache.Flush()
+
+	start := time.Date(2024, 10, 1, 9, 0, 0, 0, romeLocation)
+	lesson := func(code string) timetable.Event {
+		return timetable.Event{
+			CodModulo: code,
+			Start:     timetable.CalendarTime{Time: start},
+			End:       timetable.CalendarTime{Time: start.Add(time.Hour)},
+		}
+	}
+	storeTimetable("8009-1-", cachedTimetable{
+		Course:    8009,
+		Year:      1,
+		Timetable: timetable.Timetable{lesson("A"), lesson("B")},
+		Fetched:   time.Now(),
+	})
+
+	courses := unibo_integ.CoursesMap{8009: {Codice: 8009, DurataAnni: 3}}
+	selections := []feedSelection{{Course: 8009, Year: 1}}
+	conflicts, err := selectionsConflicts(selections, &courses)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(conflicts))
+
+	// The conflicts are cached
+	timetableCache.Flush()
+	conflicts, err = selectionsConflicts(selections, &courses)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(conflicts))
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"cmp"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/VaiTon/unibocalendar/unibo_integ"
+)
+
+// maxFacetValues is the maximum number of values that can be selected for a facet.
+const maxFacetValues = 20
+
+// courseFacet is an attribute of the courses that can be used to filter the
+// catalogue. Name is also the query parameter of the filter.
+type courseFacet struct {
+	Name   string
+	values func(c unibo_integ.Course) []string
+	// label returns the text shown for a value, if it's not the value itself
+	label func(lang, value string) string
+}
+
+// listSplitter splits the attributes that can list more than one value, e.g.
+// "Italiano, Inglese".
+func listSplitter(r rune) bool {
+	return r == ',' || r == ';' || r == '/'
+}
+
+func singleValue(s string) []string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil
+	}
+	return []string{s}
+}
+
+func listValues(s string) []string {
+	values := make([]string, 0, 1)
+	for _, v := range strings.FieldsFunc(s, listSplitter) {
+		v = strings.TrimSpace(v)
+		if v != "" && !slices.Contains(values, v) {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// courseFacets are the facets of the catalogue, in the order they are shown.
+var courseFacets = []courseFacet{
+	{Name: "tipologia", values: func(c unibo_integ.Course) []string { return singleValue(c.Tipologia) }},
+	{Name: "campus", values: func(c unibo_integ.Course) []string { return singleValue(c.Campus) }},
+	{Name: "sede", values: func(c unibo_integ.Course) []string { return listValues(c.SedeDidattica) }},
+	{Name: "ambito", values: func(c unibo_integ.Course) []string { return singleValue(c.Ambiti) }},
+	{Name: "accesso", values: func(c unibo_integ.Course) []string { return singleValue(c.Accesso) }},
+	{Name: "lingua", values: func(c unibo_integ.Course) []string { return listValues(c.Lingue) }},
+	{
+		Name:   "internazionale",
+		values: func(c unibo_integ.Course) []string { return []string{strconv.FormatBool(c.Internazionale)} },
+		label: func(lang, value string) string {
+			if value == "true" {
+				return tr(lang, "common.yes")
+			}
+			return tr(lang, "common.no")
+		},
+	},
+}
+
+// facetFilter contains the selected values of every facet. A course matches
+// if it has one of the selected values of every facet.
+type facetFilter map[string][]string
+
+// parseFacetFilter reads the facet filters from the query parameters, e.g.
+// campus=Bologna&campus=Cesena&tipologia=Laurea. Unknown parameters are
+// ignored. Values are sorted, so that the same filters give the same URL.
+func parseFacetFilter(query url.Values) facetFilter {
+	f := make(facetFilter)
+	for _, facet := range courseFacets {
+		values := make([]string, 0)
+		for _, v := range query[facet.Name] {
+			v = strings.TrimSpace(v)
+			if v != "" && !slices.Contains(values, v) {
+				values = append(values, v)
+			}
+		}
+		if len(values) == 0 {
+			continue
+		}
+
+		slices.Sort(values)
+		if len(values) > maxFacetValues {
+			values = values[:maxFacetValues]
+		}
+		f[facet.Name] = values
+	}
+	return f
+}
+
+// matches tells if the course matches the filter, ignoring the facet named
+// except. Counting the values of a facet without its own filter allows
+// selecting more than one value.
+func (f facetFilter) matches(c unibo_integ.Course, except string) bool {
+	for _, facet := range courseFacets {
+		selected := f[facet.Name]
+		if facet.Name == except || len(selected) == 0 {
+			continue
+		}
+		if !slices.ContainsFunc(facet.values(c), func(v string) bool { return slices.Contains(selected, v) }) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f facetFilter) apply(courses []unibo_integ.Course) []unibo_integ.Course {
+	filtered := make([]unibo_integ.Course, 0, len(courses))
+	for _, c := range courses {
+		if f.matches(c, "") {
+			filtered = append(filtered, c)
+		}
+	}
+	return filtered
+}
+
+// toggle returns a copy of the filter with the value selected if it was not,
+// and removed otherwise.
+func (f facetFilter) toggle(name, value string) facetFilter {
+	toggled := make(facetFilter, len(f))
+	for k, v := range f {
+		toggled[k] = slices.Clone(v)
+	}
+
+	if i := slices.Index(toggled[name], value); i != -1 {
+		toggled[name] = slices.Delete(toggled[name], i, i+1)
+	} else {
+		toggled[name] = append(toggled[name], value)
+		slices.Sort(toggled[name])
+	}
+	if len(toggled[name]) == 0 {
+		delete(toggled, name)
+	}
+	return toggled
+}
+
+// url returns the URL of the index page with the given query and filters.
+// Parameters are sorted by url.Values.Encode, so every selection has a single
+// shareable URL.
+func (f facetFilter) url(query string) string {
+	values := make(url.Values, len(f)+1)
+	for k, v := range f {
+		values[k] = v
+	}
+	if query != "" {
+		values.Set("q", query)
+	}
+
+	if len(values) == 0 {
+		return "/"
+	}
+	return "/?" + values.Encode()
+}
+
+// facetValue is a value of a facet with the number of courses that would be
+// shown by selecting it.
+type facetValue struct {
+	Value    string `json:"value"`
+	Label    string `json:"label"`
+	Count    int    `json:"count"`
+	Selected bool   `json:"selected"`
+	Url      string `json:"url"`
+}
+
+type facetCounts struct {
+	Name   string       `json:"name"`
+	Label  string       `json:"label"`
+	Values []facetValue `json:"values"`
+}
+
+// SelectedCount returns the number of selected values of the facet.
+func (f facetCounts) SelectedCount() int {
+	n := 0
+	for _, v := range f.Values {
+		if v.Selected {
+			n++
+		}
+	}
+	return n
+}
+
+// countFacets returns the values of every facet found in the courses, with the
+// number of courses having them. Selected values are included even if no
+// course has them, so that they can be removed.
+func countFacets(courses []unibo_integ.Course, f facetFilter, query, lang string) []facetCounts {
+	facets := make([]facetCounts, 0, len(courseFacets))
+	for _, facet := range courseFacets {
+		counts := make(map[string]int)
+		for _, v := range f[facet.Name] {
+			counts[v] = 0
+		}
+		for _, c := range courses {
+			if !f.matches(c, facet.Name) {
+				continue
+			}
+			for _, v := range facet.values(c) {
+				counts[v]++
+			}
+		}
+
+		values := make([]facetValue, 0, len(counts))
+		for v, count := range counts {
+			label := v
+			if facet.label != nil {
+				label = facet.label(lang, v)
+			}
+			values = append(values, facetValue{
+				Value:    v,
+				Label:    label,
+				Count:    count,
+				Selected: slices.Contains(f[facet.Name], v),
+				Url:      f.toggle(facet.Name, v).url(query),
+			})
+		}
+		slices.SortFunc(values, func(a, b facetValue) int {
+			return cmp.Or(b.Count-a.Count, strings.Compare(a.Value, b.Value))
+		})
+
+		facets = append(facets, facetCounts{
+			Name:   facet.Name,
+			Label:  tr(lang, "index.facet."+facet.Name),
+			Values: values,
+		})
+	}
+	return facets
+}
+
+func getCourses(courses unibo_integ.CoursesMap) func(c *gin.Context) {
+	return func(ctx *gin.Context) {
+		query := strings.TrimSpace(ctx.Query("q"))
+		filter := parseFacetFilter(ctx.Request.URL.Query())
+		lang := requestLang(ctx)
+
+		coursesList := courses.ToList()
+		slices.SortFunc(coursesList, func(a, b unibo_integ.Course) int {
+			return b.Codice - a.Codice
+		})
+		if query != "" {
+			coursesList = searchedCourses(coursesList, query)
+		}
+
+		results := make([]courseResult, 0)
+		for _, c := range filter.apply(coursesList) {
+			results = append(results, newCourseResult(c, 0))
+		}
+
+		ctx.JSON(http.StatusOK, gin.H{
+			"query":   query,
+			"filters": filter,
+			"url":     filter.url(query),
+			"facets":  countFacets(coursesList, filter, query, lang),
+			"courses": results,
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+
+	"github.com/VaiTon/unibocalendar/unibo_integ"
+)
+
+func Test_courseFacets_labels(t *testing.T) {
+	for _, facet := range courseFacets {
+		_, ok := catalogues[defaultLang]["index.facet."+facet.Name]
+		assert.Equal(t, true, ok)
+	}
+}
+
+func Test_parseFacetFilter(t *testing.T) {
+	query, _ := url.ParseQuery("campus=Cesena&campus=Bologna&campus=Cesena&lingua=+Inglese+&foo=bar&sede=")
+	f := parseFacetFilter(query)
+
+	assert.Equal(t, facetFilter{"campus": {"Bologna", "Cesena"}, "lingua": {"Inglese"}}, f)
+	assert.Equal(t, "/?campus=Bologna&campus=Cesena&lingua=Inglese&q=fisica", f.url("fisica"))
+	assert.Equal(t, "/", facetFilter{}.url(""))
+}
+
+func Test_facetFilter_toggle(t *testing.T) {
+	f := facetFilter{"campus": {"Cesena"}}
+
+	added := f.toggle("campus", "Bologna")
+	assert.Equal(t, facetFilter{"campus": {"Bologna", "Cesena"}}, added)
+	assert.Equal(t, facetFilter{"campus": {"Cesena"}}, f)
+
+	assert.Equal(t, facetFilter{}, f.toggle("campus", "Cesena"))
+}
+
+func Test_countFacets(t *testing.T) {
+	courses := []unibo_integ.Course{
+		{Codice: 1, Tipologia: "Laurea", Campus: "Bologna", Lingue: "Italiano"},
+		{Codice: 2, Tipologia: "Laurea Magistrale", Campus: "Bologna", Lingue: "Inglese"},
+		{Codice: 3, Tipologia: "Laurea", Campus: "Cesena", Lingue: "Italiano, Inglese"},
+		{Codice: 4, Tipologia: "Laurea", Campus: "Forlì", Lingue: "Italiano", Internazionale: true},
+	}
+	f := facetFilter{"campus": {"Bologna", "Cesena"}, "tipologia": {"Laurea"}}
+
+	filtered := f.apply(courses)
+	assert.Equal(t, 2, len(filtered))
+	assert.Equal(t, 1, filtered[0].Codice)
+	assert.Equal(t, 3, filtered[1].Codice)
+
+	counts := make(map[string]map[string]int)
+	selected := make(map[string]int)
+	for _, facet := range countFacets(courses, f, "", langIt) {
+		counts[facet.Name] = make(map[string]int)
+		for _, v := range facet.Values {
+			counts[facet.Name][v.Value] = v.Count
+		}
+		selected[facet.Name] = facet.SelectedCount()
+	}
+
+	// The counts of a facet ignore its own filter
+	assert.Equal(t, map[string]int{"Bologna": 1, "Cesena": 1, "Forlì": 1}, counts["campus"])
+	assert.Equal(t, map[string]int{"Laurea": 2, "Laurea Magistrale": 1}, counts["tipologia"])
+	assert.Equal(t, map[string]int{"Italiano": 2, "Inglese": 1}, counts["lingua"])
+	assert.Equal(t, map[string]int{"false": 2}, counts["internazionale"])
+	assert.Equal(t, 2, selected["campus"])
+	assert.Equal(t, 0, selected["lingua"])
+}
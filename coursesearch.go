@@ -0,0 +1,248 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/VaiTon/unibocalendar/unibo_integ"
+)
+
+const (
+	defaultCourseResults = 10
+	maxCourseResults     = 50
+)
+
+// Weights of the fields of a course in the search. A word found in the
+// description counts more than one found in the campus or in the languages.
+const (
+	weightCode        = 5
+	weightDescription = 4
+	weightAmbiti      = 2
+	weightCampus      = 2
+	weightTipologia   = 1
+	weightLingue      = 1
+)
+
+// Quality of the match between a word of the query and a word of a course
+const (
+	matchFuzzy  = 1
+	matchPrefix = 2
+	matchExact  = 3
+)
+
+// courseTypeAbbreviations are the abbreviations of the course types shown in
+// the index page, so that "lm informatica" finds the master's degree.
+var courseTypeAbbreviations = map[string]string{
+	"Laurea":                          "lt",
+	"Laurea Magistrale":               "lm",
+	"Laurea Magistrale a ciclo unico": "lmcu",
+}
+
+// courseResult is a course returned by the search API.
+type courseResult struct {
+	Code        int    `json:"code"`
+	Description string `json:"description"`
+	Type        string `json:"type"`
+	Campus      string `json:"campus"`
+	Year        string `json:"year"`
+	Url         string `json:"url"`
+	Score       int    `json:"score,omitempty"`
+}
+
+type searchField struct {
+	weight int
+	tokens []string
+}
+
+func courseSearchFields(c unibo_integ.Course) []searchField {
+	tipologia := normalizeTokens(c.Tipologia)
+	if abbr, ok := courseTypeAbbreviations[c.Tipologia]; ok {
+		tipologia = append(tipologia, abbr)
+	}
+
+	return []searchField{
+		{weightDescription, normalizeTokens(c.Descrizione)},
+		{weightAmbiti, normalizeTokens(c.Ambiti)},
+		{weightCampus, normalizeTokens(c.Campus)},
+		{weightTipologia, tipologia},
+		{weightLingue, normalizeTokens(c.Lingue)},
+	}
+}
+
+// maxEdits returns how many typos are tolerated in a word of the query. Short
+// words must match exactly, or every query would match every course.
+func maxEdits(word []rune) int {
+	switch {
+	case len(word) < 4:
+		return 0
+	case len(word) < 8:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+// wordMatch returns the quality of the match between a word of the query and
+// a word of a course, or 0 if they don't match. A typo is tolerated in the
+// prefix too, so "infromat" matches "informatica".
+func wordMatch(query, word string) int {
+	if query == word {
+		return matchExact
+	}
+	if len(query) < len(word) && word[:len(query)] == query {
+		return matchPrefix
+	}
+
+	q, w := []rune(query), []rune(word)
+	edits := maxEdits(q)
+	if edits == 0 {
+		return 0
+	}
+	if len(w) > len(q) && editDistance(q, w[:len(q)]) <= edits {
+		return matchFuzzy
+	}
+	if editDistance(q, w) <= edits {
+		return matchFuzzy
+	}
+	return 0
+}
+
+// courseScore returns the score of a course for the given query words, or 0
+// if one of the words is not found in the course.
+func courseScore(c unibo_integ.Course, fields []searchField, queryTokens []string) int {
+	code := strconv.Itoa(c.Codice)
+
+	score := 0
+	for _, q := range queryTokens {
+		best := 0
+		if q == code {
+			best = weightCode * matchExact
+		}
+		for _, f := range fields {
+			for _, token := range f.tokens {
+				best = max(best, f.weight*wordMatch(q, token))
+			}
+		}
+
+		if best == 0 {
+			return 0
+		}
+		score += best
+	}
+	return score
+}
+
+type scoredCourse struct {
+	course unibo_integ.Course
+	score  int
+}
+
+// searchCourses returns the courses matching every word of the query,
+// ignoring case and accents and tolerating typos. The courses are sorted by
+// score, then by code as in the index page.
+func searchCourses(courses []unibo_integ.Course, query string) []scoredCourse {
+	queryTokens := normalizeTokens(query)
+	if len(queryTokens) == 0 {
+		return nil
+	}
+
+	results := make([]scoredCourse, 0)
+	for _, c := range courses {
+		score := courseScore(c, courseSearchFields(c), queryTokens)
+		if score > 0 {
+			results = append(results, scoredCourse{c, score})
+		}
+	}
+
+	slices.SortFunc(results, func(a, b scoredCourse) int {
+		if a.score != b.score {
+			return b.score - a.score
+		}
+		return b.course.Codice - a.course.Codice
+	})
+	return results
+}
+
+// searchedCourses returns the courses of searchCourses, without the score.
+func searchedCourses(courses []unibo_integ.Course, query string) []unibo_integ.Course {
+	found := searchCourses(courses, query)
+	results := make([]unibo_integ.Course, 0, len(found))
+	for _, r := range found {
+		results = append(results, r.course)
+	}
+	return results
+}
+
+func newCourseResult(c unibo_integ.Course, score int) courseResult {
+	return courseResult{
+		Code:        c.Codice,
+		Description: c.Descrizione,
+		Type:        c.Tipologia,
+		Campus:      c.Campus,
+		Year:        c.AnnoAccademico,
+		Url:         "/courses/" + strconv.Itoa(c.Codice),
+		Score:       score,
+	}
+}
+
+func searchCoursesApi(courses unibo_integ.CoursesMap) func(c *gin.Context) {
+	return func(ctx *gin.Context) {
+		query := ctx.Query("q")
+		if query == "" {
+			ctx.String(http.StatusBadRequest, "Missing query")
+			return
+		}
+
+		limit := defaultCourseResults
+		if l := ctx.Query("limit"); l != "" {
+			var err error
+			limit, err = strconv.Atoi(l)
+			if err != nil || limit < 1 || limit > maxCourseResults {
+				ctx.String(http.StatusBadRequest, "Invalid limit")
+				return
+			}
+		}
+
+		filter := parseFacetFilter(ctx.Request.URL.Query())
+
+		found := searchCourses(filter.apply(courses.ToList()), query)
+		if len(found) > limit {
+			found = found[:limit]
+		}
+
+		results := make([]courseResult, 0, len(found))
+		for _, r := range found {
+			results = append(results, newCourseResult(r.course, r.score))
+		}
+
+		ctx.JSON(http.StatusOK, gin.H{
+			"query":   query,
+			"courses": results,
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+
+	"github.com/VaiTon/unibocalendar/unibo_integ"
+)
+
+func Test_editDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"informatica", "informatica", 0},
+		{"informatca", "informatica", 1},
+		{"infromatica", "informatica", 2},
+		{"", "abc", 3},
+		{"fisica", "chimica", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.a+"-"+tt.b, func(t *testing.T) {
+			assert.Equal(t, tt.want, editDistance([]rune(tt.a), []rune(tt.b)))
+			assert.Equal(t, tt.want, editDistance([]rune(tt.b), []rune(tt.a)))
+		})
+	}
+}
+
+func Test_wordMatch(t *testing.T) {
+	tests := []struct {
+		query string
+		word  string
+		want  int
+	}{
+		{"informatica", "informatica", matchExact},
+		{"inform", "informatica", matchPrefix},
+		{"informatca", "informatica", matchFuzzy},
+		{"infromat", "informatica", matchFuzzy},
+		{"fis", "fisica", matchPrefix},
+		{"fsi", "fisica", 0},
+		{"lm", "lt", 0},
+		{"chimica", "fisica", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query+"-"+tt.word, func(t *testing.T) {
+			assert.Equal(t, tt.want, wordMatch(tt.query, tt.word))
+		})
+	}
+}
+
+func Test_searchCourses(t *testing.T) {
+	courses := []unibo_integ.Course{
+		{Codice: 8009, Descrizione: "Informatica", Tipologia: "Laurea", Campus: "Bologna", Ambiti: "Scienze", Lingue: "Italiano"},
+		{Codice: 8028, Descrizione: "Informatica", Tipologia: "Laurea Magistrale", Campus: "Bologna", Ambiti: "Scienze", Lingue: "Inglese"},
+		{Codice: 8014, Descrizione: "Informatica per il management", Tipologia: "Laurea", Campus: "Bologna", Ambiti: "Scienze", Lingue: "Italiano"},
+		{Codice: 9254, Descrizione: "Ingegneria e scienze informatiche", Tipologia: "Laurea", Campus: "Cesena", Ambiti: "Ingegneria", Lingue: "Italiano"},
+		{Codice: 8010, Descrizione: "Fisica", Tipologia: "Laurea", Campus: "Bologna", Ambiti: "Scienze", Lingue: "Italiano"},
+		{Codice: 5900, Descrizione: "Università e società", Tipologia: "Laurea", Campus: "Forlì", Ambiti: "Scienze politiche", Lingue: "Italiano"},
+	}
+
+	codes := func(results []scoredCourse) []int {
+		c := make([]int, 0, len(results))
+		for _, r := range results {
+			c = append(c, r.course.Codice)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  []int
+	}{
+		{"empty", "  ", []int{}},
+		{"description first", "informatica", []int{8028, 8014, 8009, 9254}},
+		{"every word", "informatica cesena", []int{9254}},
+		{"type abbreviation", "lm informatica", []int{8028}},
+		{"typo", "infromatica bologna", []int{8028, 8014, 8009}},
+		{"accents", "FORLI universita", []int{5900}},
+		{"code", "8010", []int{8010}},
+		{"language", "informatica inglese", []int{8028}},
+		{"no match", "medicina", []int{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, codes(searchCourses(courses, tt.query)))
+		})
+	}
+}
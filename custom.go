@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	ics "github.com/arran4/golang-ical"
+	"github.com/cartabinaria/unibo-go/curriculum"
+	"github.com/cartabinaria/unibo-go/timetable"
+	"github.com/gin-gonic/gin"
+
+	"github.com/VaiTon/unibocalendar/unibo_integ"
+)
+
+// maxFeedSelections is the maximum number of selections of a composite feed.
+// Every selection requires a request to the unibo website.
+const maxFeedSelections = 10
+
+// feedSelection is a set of subjects of a course year and curriculum, to be
+// included in a composite feed.
+//
+// In URLs it is encoded as "course:year:curriculum:subject1,subject2", where
+// the curriculum and the subjects may be empty (e.g. "8009:2::").
+type feedSelection struct {
+	Course     int
+	Year       int
+	Curriculum string
+	Subjects   []string
+}
+
+func parseFeedSelection(s string) (feedSelection, error) {
+	parts := strings.SplitN(s, ":", 4)
+	if len(parts) < 2 {
+		return feedSelection{}, fmt.Errorf("invalid selection %q", s)
+	}
+
+	course, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return feedSelection{}, fmt.Errorf("invalid course in selection %q", s)
+	}
+
+	year, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return feedSelection{}, fmt.Errorf("invalid year in selection %q", s)
+	}
+
+	sel := feedSelection{Course: course, Year: year}
+	if len(parts) > 2 {
+		sel.Curriculum = parts[2]
+	}
+	if len(parts) > 3 {
+		for _, subject := range strings.Split(parts[3], ",") {
+			if subject != "" {
+				sel.Subjects = append(sel.Subjects, subject)
+			}
+		}
+		slices.Sort(sel.Subjects)
+	}
+
+	return sel, nil
+}
+
+func (s feedSelection) String() string {
+	return fmt.Sprintf("%d:%d:%s:%s", s.Course, s.Year, s.Curriculum, strings.Join(s.Subjects, ","))
+}
+
+// parseFeedSelections parses and validates every "sel" query parameter.
+// The returned selections are sorted, so they can be used as a cache key.
+func parseFeedSelections(values []string, courses *unibo_integ.CoursesMap) ([]feedSelection, error) {
+	if len(values) == 0 {
+		return nil, fmt.Errorf("no selection")
+	}
+	if len(values) > maxFeedSelections {
+		return nil, fmt.Errorf("too many selections (max %d)", maxFeedSelections)
+	}
+
+	selections := make([]feedSelection, 0, len(values))
+	for _, v := range values {
+		sel, err := parseFeedSelection(v)
+		if err != nil {
+			return nil, err
+		}
+
+		course, found := courses.FindById(sel.Course)
+		if !found {
+			return nil, fmt.Errorf("course %d not found", sel.Course)
+		}
+		if sel.Year <= 0 || sel.Year > course.DurataAnni {
+			return nil, fmt.Errorf("invalid year in selection %q", v)
+		}
+
+		selections = append(selections, sel)
+	}
+
+	slices.SortFunc(selections, func(a, b feedSelection) int {
+		return strings.Compare(a.String(), b.String())
+	})
+	return selections, nil
+}
+
+// getSelectionsTimetable returns the timetables of the given selections, from
+// timetableCache if possible, keeping only the selected subjects. Lessons included in more than one
+// selection are returned only once.
+func getSelectionsTimetable(selections []feedSelection, courses *unibo_integ.CoursesMap) (timetable.Timetable, error) {
+	merged := make(timetable.Timetable, 0)
+	seen := make(map[string]struct{})
+
+	for _, sel := range selections {
+		course, _ := courses.FindById(sel.Course)
+
+		t, err := getCachedTimetable(course, sel.Year, curriculum.Curriculum{Value: sel.Curriculum})
+		if err != nil {
+			return nil, fmt.Errorf("unable to retrieve timetable for course %d: %w", sel.Course, err)
+		}
+
+		if sel.Subjects != nil {
+			t = filterTimetableBySubjects(t, sel.Subjects)
+		}
+
+		for _, event := range t {
+			uid, err := lessonUid(event)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := seen[uid]; ok {
+				continue
+			}
+			seen[uid] = struct{}{}
+			merged = append(merged, event)
+		}
+	}
+
+	slices.SortFunc(merged, func(a, b timetable.Event) int {
+		return a.Start.Compare(b.Start.Time)
+	})
+	return merged, nil
+}
+
+func createCustomCal(t timetable.Timetable, opts lessonOptions) (*ics.Calendar, error) {
+	cal := ics.NewCalendar()
+	cal.SetMethod(ics.MethodRequest)
+
+	for _, event := range t {
+		err := addLessonEvent(cal, event, opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cal.SetName(tr(opts.Lang, "cal.custom.name"))
+	cal.SetDescription(tr(opts.Lang, "cal.custom.description"))
+
+	return cal, nil
+}
+
+func getCustomCal(courses *unibo_integ.CoursesMap) func(c *gin.Context) {
+	return func(ctx *gin.Context) {
+		cal, err := customFeed(ctx, courses)
+		sendCalendar(ctx, cal, err)
+	}
+}
+
+// customFeed returns the calendar of the selections of the "sel" query
+// parameters.
+func customFeed(q feedParams, courses *unibo_integ.CoursesMap) (*bytes.Buffer, error) {
+	selections, err := parseFeedSelections(q.QueryArray("sel"), courses)
+	if err != nil {
+		return nil, &calError{http.StatusBadRequest, err.Error(), nil}
+	}
+
+	keys := make([]string, len(selections))
+	for i, sel := range selections {
+		keys[i] = sel.String()
+	}
+
+	// Mark overlapping lessons of different subjects, if requested
+	mark := q.Query("conflicts") == "mark"
+
+	// Keep only in-person or online lessons, if requested
+	attendance, err := parseAttendanceMode(q.Query("attendance"))
+	if err != nil {
+		return nil, &calError{http.StatusBadRequest, err.Error(), nil}
+	}
+
+	opts, err := parseLessonOptions(q)
+	if err != nil {
+		return nil, &calError{http.StatusBadRequest, err.Error(), nil}
+	}
+
+	cacheKey := fmt.Sprintf("custom-%s-%t-%s-%s", strings.Join(keys, "|"), mark, attendance, opts.key())
+	return cachedCalendar(calcache, cacheKey, func() (*ics.Calendar, error) {
+		t, err := getSelectionsTimetable(selections, courses)
+		if err != nil {
+			return nil, &calError{http.StatusInternalServerError, "Unable to retrieve timetable", err}
+		}
+
+		t = filterTimetableByAttendance(t, attendance)
+
+		cal, err := createCustomCal(t, opts)
+		if err != nil {
+			return nil, &calError{http.StatusInternalServerError, "Unable to create calendar", err}
+		}
+
+		if mark {
+			markConflicts(cal, findConflicts(t), opts.Lang)
+		}
+		return cal, nil
+	})
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+
+	"github.com/VaiTon/unibocalendar/unibo_integ"
+)
+
+func Test_parseFeedSelections(t *testing.T) {
+	courses := unibo_integ.CoursesMap{
+		8009: {Codice: 8009, DurataAnni: 3},
+		9254: {Codice: 9254, DurataAnni: 2},
+	}
+
+	selections, err := parseFeedSelections([]string{"9254:1:000-000:b,a,", "8009:2"}, &courses)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []feedSelection{
+		{Course: 8009, Year: 2},
+		{Course: 9254, Year: 1, Curriculum: "000-000", Subjects: []string{"a", "b"}},
+	}, selections)
+	assert.Equal(t, "9254:1:000-000:a,b", selections[1].String())
+
+	invalid := [][]string{
+		nil,
+		{"8009"},
+		{"x:1"},
+		{"8009:4"},
+		{"1234:1"},
+	}
+	for _, v := range invalid {
+		_, err := parseFeedSelections(v, &courses)
+		assert.NotEqual(t, nil, err)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const databasePath = "data/almacalendar.db"
+
+// databaseBuckets are the buckets of the stores kept in the database.
+var databaseBuckets = []string{linksBucket, webhooksBucket, changeLogBucket}
+
+// openDatabase opens the embedded database at p, creating it and the buckets
+// of the stores if needed.
+func openDatabase(p string) (*bolt.DB, error) {
+	err := os.MkdirAll(filepath.Dir(p), os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(p, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("unable to open database: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range databaseBuckets {
+			if _, err := tx.CreateBucketIfNotExists([]byte(bucket)); err != nil {
+				return fmt.Errorf("unable to create %s bucket: %w", bucket, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
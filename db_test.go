@@ -0,0 +1,37 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+	bolt "go.etcd.io/bbolt"
+)
+
+// testDatabase opens a new database, closed at the end of the test.
+func testDatabase(t *testing.T) *bolt.DB {
+	db, err := openDatabase(filepath.Join(t.TempDir(), "data", "almacalendar.db"))
+	assert.Equal(t, nil, err)
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+func Test_openDatabase(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "data", "almacalendar.db")
+	db, err := openDatabase(p)
+	assert.Equal(t, nil, err)
+
+	err = db.View(func(tx *bolt.Tx) error {
+		for _, bucket := range databaseBuckets {
+			assert.NotEqual(t, nil, tx.Bucket([]byte(bucket)))
+		}
+		return nil
+	})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, db.Close())
+
+	// The existing database is opened again
+	db, err = openDatabase(p)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, db.Close())
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"unicode/utf8"
+
+	"github.com/cartabinaria/unibo-go/timetable"
+)
+
+// maxTemplateLength is the maximum length of the templates given by users.
+const maxTemplateLength = 500
+
+// maxAliases is the maximum number of subject aliases of a feed.
+const maxAliases = 50
+
+// eventStyle is a predefined format of the summary of lessons.
+type eventStyle string
+
+const (
+	// styleDefault uses the title of the timetable
+	styleDefault eventStyle = "default"
+	// styleShort removes groups and modules from the title
+	styleShort eventStyle = "short"
+	// styleRoom is styleShort with the classroom as prefix
+	styleRoom eventStyle = "room"
+)
+
+var styleSummaries = map[eventStyle]string{
+	styleDefault: "{{.Title}}",
+	styleShort:   "{{.Short}}",
+	styleRoom:    "{{if .Room}}[{{.Room}}] {{end}}{{.Short}}",
+}
+
+// lessonData is the data available to the templates of lessons.
+type lessonData struct {
+	Title    string // Title of the timetable, or the alias of the subject
+	Short    string // Title without groups and modules, or the alias of the subject
+	Teacher  string
+	Room     string // Names of the classrooms
+	Location string // Classrooms with building and address
+	Code     string // Code of the module
+	Cfu      int
+	Period   string
+	Online   string // Link of the virtual room, if any
+}
+
+func newLessonData(event timetable.Event, aliases map[string]string) lessonData {
+	d := lessonData{
+		Title:    event.Title,
+		Short:    shortTitle(event.Title),
+		Teacher:  event.Teacher,
+		Room:     lessonLocation(event, locationShort),
+		Location: lessonLocation(event, locationFull),
+		Code:     event.CodModulo,
+		Cfu:      event.Cfu,
+		Period:   event.Interval,
+		Online:   event.Teams,
+	}
+
+	alias, ok := aliases[event.CodModulo]
+	if !ok {
+		alias, ok = aliases[normalizeSubjectCode(event.CodModulo)]
+	}
+	if ok {
+		d.Title = alias
+		d.Short = alias
+	}
+
+	return d
+}
+
+// shortTitle removes the group and the module from a timetable title, e.g.
+// "FONDAMENTI DI INFORMATICA T-1 / (A-K) / (1) Modulo 1" becomes
+// "FONDAMENTI DI INFORMATICA T-1".
+func shortTitle(title string) string {
+	if short := strings.TrimSpace(moduleTitleSuffix.ReplaceAllString(title, "")); short != "" {
+		return short
+	}
+	return title
+}
+
+// templateFuncs are the only functions allowed in the templates.
+var templateFuncs = template.FuncMap{
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"trunc": func(n int, s string) string {
+		if n < 0 || utf8.RuneCountInString(s) <= n {
+			return s
+		}
+		return string([]rune(s)[:n]) + "…"
+	},
+	"eq":  func(a, b string) bool { return a == b },
+	"not": func(b bool) bool { return !b },
+}
+
+// lessonFields are the field names of lessonData.
+var lessonFields = func() []string {
+	t := reflect.TypeOf(lessonData{})
+	fields := make([]string, t.NumField())
+	for i := range fields {
+		fields[i] = t.Field(i).Name
+	}
+	return fields
+}()
+
+// parseEventTemplate parses a template given by a user. Only a safe subset of
+// text/template is accepted: fields of lessonData, the functions in
+// templateFuncs, strings, numbers and "if" statements.
+func parseEventTemplate(name, text string) (*template.Template, error) {
+	if len(text) > maxTemplateLength {
+		return nil, fmt.Errorf("%s template is too long (max %d characters)", name, maxTemplateLength)
+	}
+
+	t, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s template: %w", name, err)
+	}
+
+	for _, tree := range t.Templates() {
+		if tree.Name() != name {
+			return nil, fmt.Errorf("invalid %s template: defining templates is not allowed", name)
+		}
+	}
+
+	err = checkTemplateNode(t.Tree.Root)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s template: %w", name, err)
+	}
+
+	// Catch the errors that can only be found on execution, e.g. wrong arguments
+	_, err = executeEventTemplate(t, lessonData{})
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s template: %w", name, err)
+	}
+
+	return t, nil
+}
+
+func checkTemplateNode(node parse.Node) error {
+	switch n := node.(type) {
+	case nil:
+		return nil
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			if err := checkTemplateNode(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	case *parse.TextNode:
+		return nil
+	case *parse.ActionNode:
+		return checkTemplatePipe(n.Pipe)
+	case *parse.IfNode:
+		if err := checkTemplatePipe(n.Pipe); err != nil {
+			return err
+		}
+		if err := checkTemplateNode(n.List); err != nil {
+			return err
+		}
+		return checkTemplateNode(n.ElseList)
+	default:
+		return fmt.Errorf("%q is not allowed", node.String())
+	}
+}
+
+func checkTemplatePipe(pipe *parse.PipeNode) error {
+	if len(pipe.Decl) != 0 {
+		return fmt.Errorf("variables are not allowed")
+	}
+
+	for _, cmd := range pipe.Cmds {
+		for _, arg := range cmd.Args {
+			switch a := arg.(type) {
+			case *parse.FieldNode:
+				if len(a.Ident) != 1 || !slices.Contains(lessonFields, a.Ident[0]) {
+					return fmt.Errorf("unknown field %q", a.String())
+				}
+			case *parse.IdentifierNode:
+				if _, ok := templateFuncs[a.Ident]; !ok {
+					return fmt.Errorf("unknown function %q", a.Ident)
+				}
+			case *parse.StringNode, *parse.NumberNode, *parse.BoolNode:
+			case *parse.PipeNode:
+				if err := checkTemplatePipe(a); err != nil {
+					return err
+				}
+			default:
+				return fmt.Errorf("%q is not allowed", arg.String())
+			}
+		}
+	}
+
+	return nil
+}
+
+// eventFormat tells how the summary and the description of lessons are written.
+// The zero value keeps the title and the default description.
+type eventFormat struct {
+	Summary     *template.Template
+	Description *template.Template
+	Aliases     map[string]string
+	key         string
+}
+
+// parseEventFormat parses the "style", "summary", "description" and "alias"
+// query parameters. A summary template replaces the style. Aliases are given
+// as "code:name", e.g. alias=04642:Algebra.
+func parseEventFormat(style, summary, description string, aliases []string) (eventFormat, error) {
+	var f eventFormat
+	var err error
+
+	if style == "" {
+		style = string(styleDefault)
+	}
+	styleSummary, ok := styleSummaries[eventStyle(style)]
+	if !ok {
+		return eventFormat{}, fmt.Errorf("invalid style %q", style)
+	}
+
+	if summary == "" && eventStyle(style) != styleDefault {
+		summary = styleSummary
+	}
+	if summary != "" {
+		f.Summary, err = parseEventTemplate("summary", summary)
+		if err != nil {
+			return eventFormat{}, err
+		}
+	}
+
+	if description != "" {
+		f.Description, err = parseEventTemplate("description", description)
+		if err != nil {
+			return eventFormat{}, err
+		}
+	}
+
+	if len(aliases) > maxAliases {
+		return eventFormat{}, fmt.Errorf("too many aliases (max %d)", maxAliases)
+	}
+	if len(aliases) != 0 {
+		f.Aliases = make(map[string]string, len(aliases))
+		for _, a := range aliases {
+			code, name, found := strings.Cut(a, ":")
+			code, name = strings.TrimSpace(code), strings.TrimSpace(name)
+			if !found || code == "" || name == "" {
+				return eventFormat{}, fmt.Errorf("invalid alias %q", a)
+			}
+			f.Aliases[code] = name
+		}
+	}
+
+	sortedAliases := slices.Clone(aliases)
+	slices.Sort(sortedAliases)
+	f.key = fmt.Sprintf("%q-%q-%q", summary, description, sortedAliases)
+
+	return f, nil
+}
+
+func executeEventTemplate(t *template.Template, data lessonData) (string, error) {
+	var b strings.Builder
+	err := t.Execute(&b, data)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(b.String()), nil
+}
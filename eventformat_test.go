@@ -0,0 +1,105 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+	"github.com/cartabinaria/unibo-go/timetable"
+	"github.com/go-playground/assert/v2"
+)
+
+func Test_parseEventTemplate(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		wantErr bool
+	}{
+		{"text", "Lezione", false},
+		{"field", "{{.Short}} ({{.Room}})", false},
+		{"functions", "{{.Title | lower | trunc 10}} {{upper .Teacher}}", false},
+		{"if", `{{if .Online}}Online{{else if eq .Room "AULA 1"}}Aula 1{{end}}`, false},
+		{"unknown field", "{{.Foo}}", true},
+		{"nested field", "{{.Title.Len}}", true},
+		{"dot", "{{.}}", true},
+		{"builtin function", `{{printf "%s" .Title}}`, true},
+		{"call", "{{call .Title}}", true},
+		{"range", "{{range .Title}}x{{end}}", true},
+		{"variable", "{{$x := .Title}}{{$x}}", true},
+		{"define", `{{define "x"}}x{{end}}`, true},
+		{"template", `{{template "summary"}}`, true},
+		{"wrong arguments", "{{trunc .Title 3}}", true},
+		{"syntax", "{{.Title", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseEventTemplate("summary", tt.text)
+			assert.Equal(t, tt.wantErr, err != nil)
+		})
+	}
+}
+
+func Test_parseEventFormat(t *testing.T) {
+	_, err := parseEventFormat("fancy", "", "", nil)
+	assert.NotEqual(t, nil, err)
+
+	_, err = parseEventFormat("", "", "", []string{"04642"})
+	assert.NotEqual(t, nil, err)
+
+	f, err := parseEventFormat("", "", "", nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, f.Summary == nil)
+
+	f, err = parseEventFormat("room", "", "", []string{"04642:Algebra"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, map[string]string{"04642": "Algebra"}, f.Aliases)
+
+	g, err := parseEventFormat("short", "", "", []string{"04642:Algebra"})
+	assert.Equal(t, nil, err)
+	assert.NotEqual(t, f.key, g.key)
+}
+
+func Test_addLessonEvent_format(t *testing.T) {
+	start := time.Date(2024, 10, 1, 9, 0, 0, 0, romeLocation)
+	event := timetable.Event{
+		CodModulo:  "04642_1",
+		Title:      "ALGEBRA E GEOMETRIA / (A-K) / (1) Modulo 1",
+		Teacher:    "Mario Rossi",
+		Start:      timetable.CalendarTime{Time: start},
+		End:        timetable.CalendarTime{Time: start.Add(2 * time.Hour)},
+		Classrooms: []timetable.Classroom{{ResourceDesc: "AULA 1.4"}},
+	}
+
+	tests := []struct {
+		name            string
+		style           string
+		summary         string
+		description     string
+		aliases         []string
+		wantSummary     string
+		wantDescription string
+	}{
+		{"default", "", "", "", nil, event.Title, ""},
+		{"short", "short", "", "", nil, "ALGEBRA E GEOMETRIA", ""},
+		{"room", "room", "", "", nil, "[AULA 1.4] ALGEBRA E GEOMETRIA", ""},
+		{"alias", "room", "", "", []string{"04642:Algebra"}, "[AULA 1.4] Algebra", ""},
+		{"alias of the module", "", "", "", []string{"04642_1:Algebra 1"}, "Algebra 1", ""},
+		{"templates", "", "{{.Short | lower}}", "{{.Teacher}} - {{.Room}}", nil, "algebra e geometria", "Mario Rossi - AULA 1.4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format, err := parseEventFormat(tt.style, tt.summary, tt.description, tt.aliases)
+			assert.Equal(t, nil, err)
+
+			cal := ics.NewCalendar()
+			err = addLessonEvent(cal, event, lessonOptions{Format: format})
+			assert.Equal(t, nil, err)
+
+			e := cal.Events()[0]
+			assert.Equal(t, tt.wantSummary, e.GetProperty(ics.ComponentPropertySummary).Value)
+			if tt.wantDescription != "" {
+				assert.Equal(t, tt.wantDescription, e.GetProperty(ics.ComponentPropertyDescription).Value)
+			}
+		})
+	}
+}
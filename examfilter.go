@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cartabinaria/unibo-go/exams"
+)
+
+// examFilter selects the exams to include in a feed.
+type examFilter struct {
+	// Types are lowercase words that must be found in the type of the exam
+	// (e.g. "scritto", "orale", "parziale"). If empty, every type is included.
+	Types []string
+	// Upcoming excludes the exams of the past days.
+	Upcoming bool
+	// From and To limit the exams to a range of days. A zero value means no limit.
+	From time.Time
+	To   time.Time
+	// Next is the maximum number of exams for each subject, 0 for no limit.
+	// It implies Upcoming, since the next exams are the ones not taken yet.
+	Next int
+}
+
+// upcoming reports whether the exams of the past days are excluded.
+func (f examFilter) upcoming() bool {
+	return f.Upcoming || f.Next != 0
+}
+
+// parseExamFilter parses the "type", "upcoming", "from", "to" and "next"
+// query parameters, e.g. type=scritto&upcoming=true&next=2.
+func parseExamFilter(q feedParams) (examFilter, error) {
+	var f examFilter
+
+	for _, t := range strings.Split(q.Query("type"), ",") {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t != "" && !slices.Contains(f.Types, t) {
+			f.Types = append(f.Types, t)
+		}
+	}
+	slices.Sort(f.Types)
+
+	f.Upcoming = q.Query("upcoming") == "true"
+
+	var err error
+	if from := q.Query("from"); from != "" {
+		f.From, err = time.ParseInLocation(dateLayout, from, romeLocation)
+		if err != nil {
+			return examFilter{}, fmt.Errorf("invalid from date")
+		}
+	}
+	if to := q.Query("to"); to != "" {
+		f.To, err = time.ParseInLocation(dateLayout, to, romeLocation)
+		if err != nil {
+			return examFilter{}, fmt.Errorf("invalid to date")
+		}
+	}
+	if !f.From.IsZero() && !f.To.IsZero() && f.To.Before(f.From) {
+		return examFilter{}, fmt.Errorf("to date is before from date")
+	}
+
+	if next := q.Query("next"); next != "" {
+		f.Next, err = strconv.Atoi(next)
+		if err != nil || f.Next <= 0 {
+			return examFilter{}, fmt.Errorf("invalid next")
+		}
+	}
+
+	return f, nil
+}
+
+// key returns a string representation of the filter, to be used in cache keys.
+// Upcoming filters include the current day, as their result changes every day.
+func (f examFilter) key(now time.Time) string {
+	var from, to, today string
+	if !f.From.IsZero() {
+		from = f.From.Format(dateLayout)
+	}
+	if !f.To.IsZero() {
+		to = f.To.Format(dateLayout)
+	}
+	if f.upcoming() {
+		today = now.In(romeLocation).Format(dateLayout)
+	}
+	return fmt.Sprintf("%s_%s_%s_%s_%d", strings.Join(f.Types, ","), today, from, to, f.Next)
+}
+
+// apply returns the exams selected by the filter, sorted by date.
+func (f examFilter) apply(all []exams.Exam, now time.Time) []exams.Exam {
+	filtered := make([]exams.Exam, 0, len(all))
+	for _, exam := range all {
+		if f.matches(exam, now) {
+			filtered = append(filtered, exam)
+		}
+	}
+
+	slices.SortStableFunc(filtered, func(a, b exams.Exam) int {
+		return a.Date.Compare(b.Date)
+	})
+
+	if f.Next == 0 {
+		return filtered
+	}
+
+	count := make(map[string]int)
+	limited := make([]exams.Exam, 0, len(filtered))
+	for _, exam := range filtered {
+		code := normalizeSubjectCode(exam.SubjectCode)
+		if count[code] < f.Next {
+			count[code]++
+			limited = append(limited, exam)
+		}
+	}
+	return limited
+}
+
+func (f examFilter) matches(exam exams.Exam, now time.Time) bool {
+	if len(f.Types) != 0 {
+		examType := strings.ToLower(exam.Type)
+		if !slices.ContainsFunc(f.Types, func(t string) bool { return strings.Contains(examType, t) }) {
+			return false
+		}
+	}
+
+	day := exam.Date.In(romeLocation)
+	day = time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, romeLocation)
+
+	if f.upcoming() {
+		today := now.In(romeLocation)
+		today = time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, romeLocation)
+		if day.Before(today) {
+			return false
+		}
+	}
+	if !f.From.IsZero() && day.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && day.After(f.To) {
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/cartabinaria/unibo-go/exams"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/assert/v2"
+
+	"github.com/VaiTon/unibocalendar/unibo_integ"
+)
+
+func Test_parseExamFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		want    examFilter
+		wantErr bool
+	}{
+		{"empty", "", examFilter{}, false},
+		{"types", "type=Orale,%20scritto,,orale", examFilter{Types: []string{"orale", "scritto"}}, false},
+		{"upcoming", "upcoming=true&next=2", examFilter{Upcoming: true, Next: 2}, false},
+		{"next", "next=2", examFilter{Next: 2}, false},
+		{
+			"window",
+			"from=2025-01-07&to=2025-02-28",
+			examFilter{
+				From: time.Date(2025, 1, 7, 0, 0, 0, 0, romeLocation),
+				To:   time.Date(2025, 2, 28, 0, 0, 0, 0, romeLocation),
+			},
+			false,
+		},
+		{"invalid date", "from=07/01/2025", examFilter{}, true},
+		{"reversed window", "from=2025-02-28&to=2025-01-07", examFilter{}, true},
+		{"invalid next", "next=0", examFilter{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+			ctx.Request = httptest.NewRequest("GET", "/exams/8009/1?"+tt.query, nil)
+
+			got, err := parseExamFilter(ctx)
+			assert.Equal(t, tt.wantErr, err != nil)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_examFilter_apply(t *testing.T) {
+	day := func(month time.Month, d int) time.Time {
+		return time.Date(2025, month, d, 9, 0, 0, 0, romeLocation)
+	}
+	all := []exams.Exam{
+		{SubjectCode: "04642", Type: "Orale", Date: day(time.February, 10)},
+		{SubjectCode: "04642", Type: "Scritto", Date: day(time.January, 10)},
+		{SubjectCode: "04642", Type: "Scritto", Date: day(time.June, 10)},
+		{SubjectCode: "79006", Type: "Prova parziale scritta", Date: day(time.January, 20)},
+		{SubjectCode: "79006", Type: "Scritto e orale", Date: day(time.July, 1)},
+	}
+	now := time.Date(2025, time.January, 20, 18, 0, 0, 0, romeLocation)
+
+	tests := []struct {
+		name   string
+		filter examFilter
+		want   []exams.Exam
+	}{
+		{"none", examFilter{}, []exams.Exam{all[1], all[3], all[0], all[2], all[4]}},
+		{"type", examFilter{Types: []string{"scritt"}}, []exams.Exam{all[1], all[3], all[2], all[4]}},
+		{"types", examFilter{Types: []string{"orale", "parziale"}}, []exams.Exam{all[3], all[0], all[4]}},
+		{"upcoming", examFilter{Upcoming: true}, []exams.Exam{all[3], all[0], all[2], all[4]}},
+		{
+			"window",
+			examFilter{
+				From: time.Date(2025, time.February, 1, 0, 0, 0, 0, romeLocation),
+				To:   time.Date(2025, time.June, 10, 0, 0, 0, 0, romeLocation),
+			},
+			[]exams.Exam{all[0], all[2]},
+		},
+		{"next per subject", examFilter{Upcoming: true, Next: 1}, []exams.Exam{all[3], all[0]}},
+		// The past exam of 04642 is not the next one
+		{"next without upcoming", examFilter{Next: 1}, []exams.Exam{all[3], all[0]}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.filter.apply(all, now))
+		})
+	}
+}
+
+func Test_examFilter_key(t *testing.T) {
+	now := time.Date(2025, time.January, 20, 18, 0, 0, 0, romeLocation)
+	tomorrow := now.Add(time.Hour * 24)
+
+	assert.Equal(t, examFilter{}.key(now), examFilter{}.key(tomorrow))
+	assert.NotEqual(t, examFilter{Upcoming: true}.key(now), examFilter{Upcoming: true}.key(tomorrow))
+	assert.NotEqual(t, examFilter{Next: 1}.key(now), examFilter{Next: 1}.key(tomorrow))
+}
+
+func Test_examFilterOnlyWithExams(t *testing.T) {
+	courses := unibo_integ.CoursesMap{8009: {Codice: 8009, DurataAnni: 3}}
+	params := map[string]string{"id": "8009", "anno": "1"}
+
+	tests := []struct {
+		name      string
+		query     string
+		wantExams bool
+		wantErr   bool
+	}{
+		{"invalid filter with exams", "exams=true&next=0", true, true},
+		{"filter with exams", "exams=true&next=2", true, false},
+		// Lesson feeds ignore the exam filters
+		{"invalid filter without exams", "next=0&from=x", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, _ := url.ParseQuery(tt.query)
+			req, withExams, err := parseLessonsRequest(feedUrlParams{params, query}, &courses)
+			assert.Equal(t, tt.wantErr, err != nil)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, tt.wantExams, withExams)
+			assert.Equal(t, tt.wantExams, req.ExamFilter.Next != 0)
+		})
+	}
+
+	// The exams feed always reads them
+	req, err := parseCalRequest(feedUrlParams{params, url.Values{"next": {"0"}}}, &courses)
+	assert.Equal(t, nil, err)
+	assert.NotEqual(t, nil, req.parseExams(feedUrlParams{params, url.Values{"next": {"0"}}}))
+}
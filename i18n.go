@@ -0,0 +1,135 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/text/language"
+)
+
+// Languages of the web pages and of the calendars
+const (
+	langIt = "it"
+	langEn = "en"
+	// defaultLang is used when the client doesn't ask for a supported language
+	defaultLang = langIt
+)
+
+// supportedLangs are the languages with a catalogue, in the same order as the
+// tags of langMatcher.
+var supportedLangs = []string{langIt, langEn}
+
+var langMatcher = language.NewMatcher([]language.Tag{language.Italian, language.English})
+
+// langCookie remembers the language chosen with the "lang" parameter in the web pages.
+const langCookie = "lang"
+
+// jsMessagePrefix is the prefix of the messages used by the scripts of the pages.
+const jsMessagePrefix = "js."
+
+//go:embed resources/locales/*.json
+var localesFS embed.FS
+
+// catalogues contains the messages of every supported language, by key.
+var catalogues = mustLoadCatalogues(localesFS)
+
+func mustLoadCatalogues(fs embed.FS) map[string]map[string]string {
+	c := make(map[string]map[string]string, len(supportedLangs))
+	for _, lang := range supportedLangs {
+		data, err := fs.ReadFile(path.Join("resources/locales", lang+".json"))
+		if err != nil {
+			panic(fmt.Errorf("missing catalogue for %q: %w", lang, err))
+		}
+
+		messages := make(map[string]string)
+		err = json.Unmarshal(data, &messages)
+		if err != nil {
+			panic(fmt.Errorf("invalid catalogue for %q: %w", lang, err))
+		}
+		c[lang] = messages
+	}
+	return c
+}
+
+// tr returns the message with the given key in the given language, formatted
+// with args as in fmt.Sprintf. Messages missing in a language are taken from
+// the default one.
+func tr(lang, key string, args ...any) string {
+	msg, ok := catalogues[lang][key]
+	if !ok {
+		msg, ok = catalogues[defaultLang][key]
+	}
+	if !ok {
+		return key
+	}
+
+	if len(args) == 0 {
+		return msg
+	}
+	return fmt.Sprintf(msg, args...)
+}
+
+// jsMessages returns the messages used by the scripts of the pages, without
+// jsMessagePrefix.
+func jsMessages(lang string) map[string]string {
+	messages := make(map[string]string)
+	for key := range catalogues[defaultLang] {
+		if name, found := strings.CutPrefix(key, jsMessagePrefix); found {
+			messages[name] = tr(lang, key)
+		}
+	}
+	return messages
+}
+
+func isSupportedLang(lang string) bool {
+	return slices.Contains(supportedLangs, lang)
+}
+
+// requestLang returns the language of the response: the "lang" query
+// parameter, the language chosen before in the web pages or the best match
+// of the Accept-Language header, in this order.
+func requestLang(ctx *gin.Context) string {
+	if lang := ctx.Query("lang"); isSupportedLang(lang) {
+		return lang
+	}
+
+	if lang, err := ctx.Cookie(langCookie); err == nil && isSupportedLang(lang) {
+		return lang
+	}
+
+	tags, _, err := language.ParseAcceptLanguage(ctx.GetHeader("Accept-Language"))
+	if err != nil || len(tags) == 0 {
+		return defaultLang
+	}
+
+	_, index, confidence := langMatcher.Match(tags...)
+	if confidence == language.No {
+		return defaultLang
+	}
+	return supportedLangs[index]
+}
+
+// feedLang returns the language of a feed: the "lang" query parameter or
+// the default language. Feeds are fetched by calendar apps and servers, so
+// the cookies and the Accept-Language header don't tell who reads them.
+func feedLang(q feedParams) string {
+	if lang := q.Query("lang"); isSupportedLang(lang) {
+		return lang
+	}
+	return defaultLang
+}
+
+// pageLang works like requestLang, but it also remembers the language given
+// with the "lang" query parameter for the next pages.
+func pageLang(ctx *gin.Context) string {
+	lang := requestLang(ctx)
+	if isSupportedLang(ctx.Query("lang")) {
+		ctx.SetCookie(langCookie, lang, 365*24*60*60, "/", "", false, false)
+	}
+	return lang
+}
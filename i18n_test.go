@@ -0,0 +1,140 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/assert/v2"
+)
+
+var formatVerb = regexp.MustCompile(`%[a-z]`)
+
+func Test_catalogues(t *testing.T) {
+	for _, lang := range supportedLangs {
+		for _, other := range supportedLangs {
+			for key, msg := range catalogues[lang] {
+				otherMsg, ok := catalogues[other][key]
+				if !ok {
+					t.Errorf("%q is missing in %q", key, other)
+					continue
+				}
+
+				verbs, otherVerbs := formatVerb.FindAllString(msg, -1), formatVerb.FindAllString(otherMsg, -1)
+				if !slices.Equal(verbs, otherVerbs) {
+					t.Errorf("%q has different arguments in %q and %q", key, lang, other)
+				}
+			}
+		}
+	}
+}
+
+// usedKeys returns the message keys found by re in the files matching pattern.
+func usedKeys(t *testing.T, pattern string, re *regexp.Regexp) []string {
+	files, err := filepath.Glob(pattern)
+	assert.Equal(t, nil, err)
+
+	var keys []string
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		assert.Equal(t, nil, err)
+		for _, m := range re.FindAllStringSubmatch(string(data), -1) {
+			keys = append(keys, m[1])
+		}
+	}
+	return keys
+}
+
+func Test_usedKeys(t *testing.T) {
+	keys := usedKeys(t, "templates/*.gohtml", regexp.MustCompile(`t \$?\.[lL]ang "([^"]+)"`))
+	keys = append(keys, usedKeys(t, "*.go", regexp.MustCompile(`tr\([^,]+, "([^"]+)"[,)]`))...)
+	assert.NotEqual(t, 0, len(keys))
+
+	for _, key := range keys {
+		if _, ok := catalogues[defaultLang][key]; !ok {
+			t.Errorf("%q is not in the catalogue", key)
+		}
+	}
+}
+
+func Test_tr(t *testing.T) {
+	tests := []struct {
+		name string
+		lang string
+		key  string
+		args []any
+		want string
+	}{
+		{"italian", langIt, "cal.category.lesson", nil, catalogues[langIt]["cal.category.lesson"]},
+		{"english", langEn, "cal.category.lesson", nil, catalogues[langEn]["cal.category.lesson"]},
+		{"unsupported language", "fr", "cal.category.lesson", nil, catalogues[defaultLang]["cal.category.lesson"]},
+		{"missing key", langEn, "missing.key", nil, "missing.key"},
+		{"arguments", langEn, "cal.course.name", []any{"Informatica", 1}, "Informatica - year 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tr(tt.lang, tt.key, tt.args...))
+		})
+	}
+}
+
+func Test_requestLang(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          string
+		cookie         string
+		acceptLanguage string
+		want           string
+	}{
+		{"default", "", "", "", langIt},
+		{"query", "?lang=en", "", "", langEn},
+		{"unsupported query", "?lang=fr", "", "", langIt},
+		{"cookie", "", langEn, "", langEn},
+		{"query before cookie", "?lang=it", langEn, "", langIt},
+		{"accept language", "", "", "en-US,en;q=0.9", langEn},
+		{"unsupported accept language", "", "", "fr", langIt},
+		{"cookie before accept language", "", langIt, "en", langIt},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+			ctx.Request = httptest.NewRequest(http.MethodGet, "/"+tt.query, nil)
+			if tt.cookie != "" {
+				ctx.Request.AddCookie(&http.Cookie{Name: langCookie, Value: tt.cookie})
+			}
+			if tt.acceptLanguage != "" {
+				ctx.Request.Header.Set("Accept-Language", tt.acceptLanguage)
+			}
+
+			assert.Equal(t, tt.want, requestLang(ctx))
+		})
+	}
+}
+
+func Test_feedLang(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"default", "", langIt},
+		{"query", "?lang=en", langEn},
+		{"unsupported query", "?lang=fr", langIt},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+			ctx.Request = httptest.NewRequest(http.MethodGet, "/"+tt.query, nil)
+			// The cookie and the Accept-Language header are ignored
+			ctx.Request.AddCookie(&http.Cookie{Name: langCookie, Value: langEn})
+			ctx.Request.Header.Set("Accept-Language", "en")
+
+			assert.Equal(t, tt.want, feedLang(ctx))
+		})
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/cartabinaria/unibo-go/timetable"
+)
+
+// buildingsVersion is the version of the buildings file format supported by
+// loadBuildings.
+const buildingsVersion = 1
+
+//go:embed resources/buildings.json
+var buildingsData []byte
+
+// buildingTable contains the buildings embedded in the executable.
+var buildingTable = mustLoadBuildings(buildingsData)
+
+// building contains the full address and the coordinates of a building.
+type building struct {
+	// Address is the address as found in the timetable, e.g.
+	// "Viale del Risorgimento, 2 - Bologna".
+	Address string `json:"address"`
+	// Codes are the codes of the building in the timetables, e.g. "331".
+	// A building can have more codes, one for each part.
+	Codes       []string `json:"codes,omitempty"`
+	Name        string   `json:"name"`
+	FullAddress string   `json:"full_address"`
+	Lat         float64  `json:"lat"`
+	Lng         float64  `json:"lng"`
+}
+
+func (b building) hasGeo() bool {
+	return b.Lat != 0 || b.Lng != 0
+}
+
+// mapUrl returns a link to the building on OpenStreetMap.
+func (b building) mapUrl() string {
+	lat := strconv.FormatFloat(b.Lat, 'f', -1, 64)
+	lng := strconv.FormatFloat(b.Lng, 'f', -1, 64)
+	return fmt.Sprintf("https://www.openstreetmap.org/?mlat=%s&mlon=%s#map=18/%s/%s", lat, lng, lat, lng)
+}
+
+// buildingsFile is the format of resources/buildings.json. It complements the
+// data of the timetables, where buildings often lack the postal code or the
+// coordinates.
+type buildingsFile struct {
+	Version   int        `json:"version"`
+	Revision  string     `json:"revision"`
+	Buildings []building `json:"buildings"`
+}
+
+// addressKey normalizes an address ignoring case, accents and punctuation.
+func addressKey(address string) string {
+	return strings.Join(normalizeTokens(address), " ")
+}
+
+// buildingIndex finds the buildings of a buildings file by code or address.
+type buildingIndex struct {
+	byCode    map[string]building
+	byAddress map[string]building
+	buildings []building
+}
+
+// splitAddress returns the tokens of the street and of the city of an
+// address written as in the timetables, e.g. "Via Zamboni, 33 - Bologna".
+func splitAddress(address string) ([]string, []string) {
+	street, city, _ := strings.Cut(address, " - ")
+	return normalizeTokens(street), normalizeTokens(city)
+}
+
+// find returns the building of the classroom: the one with its building
+// code, or the one with its address. Addresses are compared ignoring case,
+// accents and punctuation, and the street may be followed by more details,
+// e.g. "Via Zamboni, 33/A - Bologna" is in "Via Zamboni, 33 - Bologna".
+func (idx buildingIndex) find(c timetable.Classroom) (building, bool) {
+	if code := c.Raw.Building.Code; code != "" {
+		if b, ok := idx.byCode[code]; ok {
+			return b, true
+		}
+	}
+
+	if b, ok := idx.byAddress[addressKey(c.AddressDesc)]; ok {
+		return b, true
+	}
+	raw := c.Raw.Building
+	if b, ok := idx.byAddress[addressKey(raw.Via+" "+raw.Comune)]; ok {
+		return b, true
+	}
+
+	// The building with the longest street the address starts with
+	tokens := normalizeTokens(c.AddressDesc)
+	var found building
+	longest := 0
+	for _, b := range idx.buildings {
+		street, city := splitAddress(b.Address)
+		if len(street) <= longest || len(street)+len(city) > len(tokens) {
+			continue
+		}
+		if slices.Equal(tokens[:len(street)], street) && slices.Equal(tokens[len(tokens)-len(city):], city) {
+			found, longest = b, len(street)
+		}
+	}
+	return found, longest != 0
+}
+
+// loadBuildings reads and validates a buildings file.
+func loadBuildings(r io.Reader) (buildingIndex, error) {
+	var f buildingsFile
+	err := json.NewDecoder(r).Decode(&f)
+	if err != nil {
+		return buildingIndex{}, fmt.Errorf("unable to decode buildings: %w", err)
+	}
+
+	if f.Version != buildingsVersion {
+		return buildingIndex{}, fmt.Errorf("unsupported buildings version %d", f.Version)
+	}
+
+	idx := buildingIndex{
+		byCode:    make(map[string]building),
+		byAddress: make(map[string]building, len(f.Buildings)),
+		buildings: f.Buildings,
+	}
+	for _, b := range f.Buildings {
+		key := addressKey(b.Address)
+		if street, _ := splitAddress(b.Address); len(street) == 0 {
+			return buildingIndex{}, fmt.Errorf("building %q has no address", b.Name)
+		}
+		if _, ok := idx.byAddress[key]; ok {
+			return buildingIndex{}, fmt.Errorf("duplicated building %q", b.Address)
+		}
+		if b.Lat < -90 || b.Lat > 90 || b.Lng < -180 || b.Lng > 180 {
+			return buildingIndex{}, fmt.Errorf("building %q has invalid coordinates", b.Address)
+		}
+		idx.byAddress[key] = b
+
+		for _, code := range b.Codes {
+			if _, ok := idx.byCode[code]; ok {
+				return buildingIndex{}, fmt.Errorf("duplicated building code %q", code)
+			}
+			idx.byCode[code] = b
+		}
+	}
+
+	return idx, nil
+}
+
+func mustLoadBuildings(data []byte) buildingIndex {
+	buildings, err := loadBuildings(bytes.NewReader(data))
+	if err != nil {
+		panic(err)
+	}
+	return buildings
+}
+
+// classroomBuilding returns the building of the classroom. The buildings table
+// is searched first, then the data of the timetable is used.
+func classroomBuilding(c timetable.Classroom) building {
+	if b, ok := buildingTable.find(c); ok {
+		return b
+	}
+
+	raw := c.Raw.Building
+	b := building{
+		Address:     c.AddressDesc,
+		Name:        c.BuildingDesc,
+		FullAddress: c.AddressDesc,
+		Lat:         raw.Geo.Lat,
+		Lng:         raw.Geo.Lng,
+	}
+
+	if raw.Via != "" && raw.Comune != "" {
+		b.FullAddress = fmt.Sprintf("%s, %s", raw.Via, strings.TrimSpace(raw.CAP+" "+raw.Comune))
+		if raw.Provincia != "" {
+			b.FullAddress += " " + raw.Provincia
+		}
+	}
+
+	return b
+}
+
+// locationMode tells how the location of a lesson is written.
+type locationMode string
+
+const (
+	// locationShort lists the names of the classrooms
+	locationShort locationMode = "short"
+	// locationFull adds the building and its address to every classroom
+	locationFull locationMode = "full"
+)
+
+func parseLocationMode(s string) (locationMode, error) {
+	switch m := locationMode(s); m {
+	case "":
+		return locationShort, nil
+	case locationShort, locationFull:
+		return m, nil
+	default:
+		return "", fmt.Errorf("invalid location %q", s)
+	}
+}
+
+// classroomLocation returns the location of a single classroom.
+func classroomLocation(c timetable.Classroom, mode locationMode) string {
+	if mode != locationFull {
+		return c.ResourceDesc
+	}
+
+	b := classroomBuilding(c)
+	parts := []string{c.ResourceDesc}
+	if b.Name != "" {
+		parts = append(parts, b.Name)
+	}
+	if b.FullAddress != "" {
+		parts = append(parts, b.FullAddress)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// lessonLocation returns the location of the lesson, listing every physical
+// classroom.
+func lessonLocation(event timetable.Event, mode locationMode) string {
+	separator := ", "
+	if mode == locationFull {
+		separator = "; "
+	}
+
+	locations := make([]string, 0, len(event.Classrooms))
+	for _, c := range physicalClassrooms(event) {
+		locations = append(locations, classroomLocation(c, mode))
+	}
+	return strings.Join(locations, separator)
+}
+
+// lessonBuilding returns the first building of the lesson with coordinates.
+func lessonBuilding(event timetable.Event) (building, bool) {
+	for _, c := range physicalClassrooms(event) {
+		if b := classroomBuilding(c); b.hasGeo() {
+			return b, true
+		}
+	}
+	return building{}, false
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+	"github.com/cartabinaria/unibo-go/timetable"
+	"github.com/go-playground/assert/v2"
+)
+
+func Test_loadBuildings(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{"embedded", string(buildingsData), false},
+		{"wrong version", `{"version": 2, "buildings": []}`, true},
+		{"no address", `{"version": 1, "buildings": [{"name": "X"}]}`, true},
+		{
+			"duplicated",
+			`{"version": 1, "buildings": [{"address": "Via Zamboni, 33 - Bologna"}, {"address": "via zamboni 33 bologna"}]}`,
+			true,
+		},
+		{"invalid coordinates", `{"version": 1, "buildings": [{"address": "Via X", "lat": 100}]}`, true},
+		{
+			"duplicated code",
+			`{"version": 1, "buildings": [{"address": "Via X", "codes": ["1"]}, {"address": "Via Y", "codes": ["1"]}]}`,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadBuildings(strings.NewReader(tt.data))
+			assert.Equal(t, tt.wantErr, err != nil)
+		})
+	}
+}
+
+func Test_buildingIndex_find(t *testing.T) {
+	idx, err := loadBuildings(strings.NewReader(`{"version": 1, "buildings": [
+		{"address": "Viale del Risorgimento, 2 - Bologna", "codes": ["331"], "name": "Ingegneria"},
+		{"address": "Via Zamboni, 33 - Bologna", "name": "Palazzo Poggi"},
+		{"address": "Via Zamboni, 3 - Bologna", "name": "Zamboni 3"}
+	]}`))
+	assert.Equal(t, nil, err)
+
+	// A classroom as returned by the timetables
+	upstream := timetable.Classroom{
+		ResourceDesc: "AULA 6.2",
+		FloorDesc:    "Piano Secondo",
+		BuildingDesc: "AULA 6.2",
+		AddressDesc:  "Viale del Risorgimento, 2 - Bologna",
+	}
+	upstream.Raw.Building.Code = "331"
+	upstream.Raw.Building.Via = "Viale del Risorgimento, 2"
+	upstream.Raw.Building.Comune = "Bologna"
+	upstream.Raw.Building.CAP = "40136"
+
+	byCode := timetable.Classroom{AddressDesc: "Viale Risorgimento 2"}
+	byCode.Raw.Building.Code = "331"
+
+	byVia := timetable.Classroom{}
+	byVia.Raw.Building.Via = "Via Zamboni, 33"
+	byVia.Raw.Building.Comune = "Bologna"
+
+	tests := []struct {
+		name      string
+		classroom timetable.Classroom
+		want      string
+	}{
+		{"upstream", upstream, "Ingegneria"},
+		{"code", byCode, "Ingegneria"},
+		{"address", timetable.Classroom{AddressDesc: "VIA ZAMBONI 33 - BOLOGNA"}, "Palazzo Poggi"},
+		{"street and city", byVia, "Palazzo Poggi"},
+		{"address with details", timetable.Classroom{AddressDesc: "Via Zamboni, 33/A - Bologna"}, "Palazzo Poggi"},
+		{"other number", timetable.Classroom{AddressDesc: "Via Zamboni, 38 - Bologna"}, ""},
+		{"other city", timetable.Classroom{AddressDesc: "Via Zamboni, 33 - Cesena"}, ""},
+		{"shorter number", timetable.Classroom{AddressDesc: "Via Zamboni, 3 - Bologna"}, "Zamboni 3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, _ := idx.find(tt.classroom)
+			assert.Equal(t, tt.want, b.Name)
+		})
+	}
+}
+
+func Test_lessonLocation(t *testing.T) {
+	known := timetable.Classroom{
+		ResourceDesc: "AULA 0.4",
+		AddressDesc:  "Viale del Risorgimento, 2 - Bologna",
+	}
+	unknown := timetable.Classroom{
+		ResourceDesc: "AULA B",
+		BuildingDesc: "Complesso Navile",
+		AddressDesc:  "Via della Beverara, 123 - Bologna",
+	}
+	unknown.Raw.Building.Via = "Via della Beverara 123"
+	unknown.Raw.Building.CAP = "40131"
+	unknown.Raw.Building.Comune = "Bologna"
+	unknown.Raw.Building.Provincia = "BO"
+	virtual := timetable.Classroom{ResourceDesc: "AULA VIRTUALE"}
+
+	event := timetable.Event{Classrooms: []timetable.Classroom{known, virtual, unknown}}
+
+	assert.Equal(t, "AULA 0.4, AULA B", lessonLocation(event, locationShort))
+	assert.Equal(t,
+		"AULA 0.4, Scuola di Ingegneria, Viale del Risorgimento 2, 40136 Bologna BO; "+
+			"AULA B, Complesso Navile, Via della Beverara 123, 40131 Bologna BO",
+		lessonLocation(event, locationFull))
+
+	b, ok := lessonBuilding(event)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "Scuola di Ingegneria", b.Name)
+
+	_, ok = lessonBuilding(timetable.Event{Classrooms: []timetable.Classroom{unknown}})
+	assert.Equal(t, false, ok)
+}
+
+func Test_addLessonEvent_location(t *testing.T) {
+	start := time.Date(2024, 10, 1, 9, 0, 0, 0, romeLocation)
+	event := timetable.Event{
+		CodModulo: "04642",
+		Start:     timetable.CalendarTime{Time: start},
+		End:       timetable.CalendarTime{Time: start.Add(2 * time.Hour)},
+		Classrooms: []timetable.Classroom{
+			{ResourceDesc: "AULA 0.4", AddressDesc: "Viale del Risorgimento, 2 - Bologna"},
+			{ResourceDesc: "AULA 0.5", AddressDesc: "Viale del Risorgimento, 2 - Bologna"},
+		},
+	}
+
+	cal := ics.NewCalendar()
+	err := addLessonEvent(cal, event, lessonOptions{Location: locationShort})
+	assert.Equal(t, nil, err)
+
+	e := cal.Events()[0]
+	assert.Equal(t, "AULA 0.4, AULA 0.5", e.GetProperty(ics.ComponentPropertyLocation).Value)
+	assert.Equal(t, "44.48792;11.32881", e.GetProperty(ics.ComponentPropertyGeo).Value)
+
+	description := e.GetProperty(ics.ComponentPropertyDescription).Value
+	assert.Equal(t, true, strings.Contains(description, "Aule: AULA 0.4, Scuola di Ingegneria"))
+	assert.Equal(t, true, strings.Contains(description, "Mappa: https://www.openstreetmap.org/?mlat=44.48792&mlon=11.32881"))
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
@@ -13,12 +14,13 @@ import (
 
 	"github.com/cartabinaria/unibo-go/curriculum"
 	"github.com/cartabinaria/unibo-go/exams"
+	"github.com/cartabinaria/unibo-go/timetable"
 
+	ics "github.com/arran4/golang-ical"
 	"github.com/gin-contrib/multitemplate"
 	limits "github.com/gin-contrib/size"
 	"github.com/gin-gonic/gin"
 	compress "github.com/lf4096/gin-compress"
-	"github.com/patrickmn/go-cache"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
@@ -39,6 +41,10 @@ func createMyRender() multitemplate.Renderer {
 			}
 			return r
 		},
+		"add": func(a, b int) int {
+			return a + b
+		},
+		"t": tr,
 		"dict": func(values ...interface{}) map[string]interface{} {
 			if len(values)%2 != 0 {
 				panic("invalid dict call: odd number of arguments")
@@ -64,6 +70,21 @@ func createMyRender() multitemplate.Renderer {
 	r.AddFromFilesFuncs("course", funcMap,
 		path.Join(templateDir, "course.gohtml"), path.Join(templateDir, "base.gohtml"),
 	)
+	r.AddFromFilesFuncs("feed", funcMap,
+		path.Join(templateDir, "feed.gohtml"), path.Join(templateDir, "base.gohtml"),
+	)
+	r.AddFromFilesFuncs("week", funcMap,
+		path.Join(templateDir, "week.gohtml"), path.Join(templateDir, "base.gohtml"),
+	)
+	r.AddFromFilesFuncs("preview", funcMap,
+		path.Join(templateDir, "preview.gohtml"), path.Join(templateDir, "base.gohtml"),
+	)
+	r.AddFromFilesFuncs("teachers", funcMap,
+		path.Join(templateDir, "teachers.gohtml"), path.Join(templateDir, "base.gohtml"),
+	)
+	r.AddFromFilesFuncs("rooms", funcMap,
+		path.Join(templateDir, "rooms.gohtml"), path.Join(templateDir, "base.gohtml"),
+	)
 	return r
 }
 
@@ -77,6 +98,22 @@ func main() {
 		log.Fatal().Err(err).Msg("Unable to open open data file")
 	}
 
+	db, err := openDatabase(databasePath)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Unable to open database")
+	}
+	defer db.Close()
+
+	feedLinks = &linkStore{db: db}
+	webhooks = &webhookStore{db: db}
+	changeLog = &changeLogStore{db: db}
+	webhooksAllowPrivate = os.Getenv("WEBHOOKS_ALLOW_PRIVATE") == "true"
+
+	baseUrl, err = parseBaseUrl(os.Getenv("BASE_URL"))
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid BASE_URL")
+	}
+
 	go fillSubjectsCache(courses)
 
 	r := setupRouter(courses)
@@ -94,6 +131,9 @@ func setupRouter(courses unibo_integ.CoursesMap) *gin.Engine {
 	r.Use(limits.RequestSizeLimiter(10 * 1024 * 1024))
 	r.HTMLRender = createMyRender()
 
+	// The preview and CalDAV read the feeds without requesting them
+	feeds := siteFeeds(&courses)
+
 	r.Static("/static", "./static")
 
 	coursesList := courses.ToList()
@@ -103,14 +143,51 @@ func setupRouter(courses unibo_integ.CoursesMap) *gin.Engine {
 	r.GET("/", indexPage(courses))
 
 	r.GET("/courses/:id", coursePage(courses))
+	r.GET("/courses/:id/feed", subjectFormPage(courses))
+	r.GET("/courses/:id/week", weekPage(courses))
+	r.GET("/courses/:id/week.pdf", getWeekPdf(courses))
 
 	r.GET("/courses/", func(c *gin.Context) {
 		c.Redirect(http.StatusMovedPermanently, "/")
 	})
 
+	r.GET("/teachers", teachersPage())
+	r.GET("/rooms", roomsPage())
+	r.GET("/api/rooms/free", getFreeRooms())
+	r.GET("/api/conflicts", getConflicts(&courses))
+	r.GET("/api/courses", getCourses(courses))
+	r.GET("/api/courses/search", searchCoursesApi(courses))
+
 	r.GET("/cal/:id/:anno", getCoursesCal(&courses))
+	r.GET("/cal/custom", getCustomCal(&courses))
+	r.GET("/cal/academic", getAcademicCal())
+	r.GET("/cal/teacher/:slug", getTeacherCal())
+	r.GET("/cal/room/:id", getRoomCal())
 
 	r.GET("/exams/:id/:anno", getExams(&courses))
+	r.GET("/feeds/changes/:id/:anno", getChangeFeed(&courses))
+
+	r.GET("/qr", getQrCode())
+	r.GET("/preview", previewPage())
+	r.GET("/api/events", getFeedEvents(feeds))
+	r.GET("/s/:token", getLinkCal(&courses))
+	r.POST("/api/links", createLink(&courses))
+	r.GET("/api/links/:token", getLink())
+	r.PUT("/api/links/:token", updateLink(&courses))
+	r.GET("/api/exams/:id/:anno/unmatched", getUnmatchedExams(&courses))
+	r.POST("/api/webhooks", createWebhook(&courses))
+	r.GET("/api/webhooks/:id", getWebhook())
+	r.DELETE("/api/webhooks/:id", deleteWebhook())
+
+	caldav := caldavHandler(feeds)
+	for _, method := range append(slices.Clone(davMethods), davWriteMethods...) {
+		r.Handle(method, "/dav/*path", caldav)
+	}
+	for _, method := range []string{http.MethodGet, "PROPFIND"} {
+		r.Handle(method, "/.well-known/caldav", func(c *gin.Context) {
+			c.Redirect(http.StatusMovedPermanently, davRoot)
+		})
+	}
 	return r
 }
 
@@ -121,9 +198,19 @@ func indexPage(courses unibo_integ.CoursesMap) func(c *gin.Context) {
 			return b.Codice - a.Codice
 		})
 
+		// With a query only the matching courses are shown, best matches first
+		query := strings.TrimSpace(ctx.Query("q"))
+		if query != "" {
+			coursesList = searchedCourses(coursesList, query)
+		}
+
+		lang := pageLang(ctx)
+		filter := parseFacetFilter(ctx.Request.URL.Query())
+		facets := countFacets(coursesList, filter, query, lang)
+
 		// group courses by year
 		yearCourses := make(map[string][]unibo_integ.Course)
-		for _, course := range coursesList {
+		for _, course := range filter.apply(coursesList) {
 			aa := course.AnnoAccademico
 			if _, ok := yearCourses[aa]; !ok {
 				yearCourses[aa] = make([]unibo_integ.Course, 0)
@@ -132,7 +219,12 @@ func indexPage(courses unibo_integ.CoursesMap) func(c *gin.Context) {
 		}
 
 		ctx.HTML(http.StatusOK, "index", gin.H{
-			"courses": yearCourses,
+			"courses":  yearCourses,
+			"filter":   query,
+			"filters":  filter,
+			"facets":   facets,
+			"clearUrl": facetFilter{}.url(query),
+			"Lang":     lang,
 		})
 	}
 }
@@ -168,235 +260,256 @@ func coursePage(courses unibo_integ.CoursesMap) func(c *gin.Context) {
 			_ = ctx.Error(fmt.Errorf("unable to retrieve subjects: %w", err))
 		}
 
+		// The timetables are already cached by getSubjectsMapFromCourseAndCurricula
+		periods := make(map[int]map[curriculum.Curriculum][]period)
+		if m != nil {
+			for y, cs := range curricula {
+				periods[y] = make(map[curriculum.Curriculum][]period)
+				for _, c := range cs {
+					p, err := getCoursePeriods(course, y, c)
+					if err != nil {
+						continue
+					}
+					periods[y][c] = p
+				}
+			}
+		}
+
+		lang := pageLang(ctx)
 		ctx.HTML(http.StatusOK, "course", gin.H{
 			"Course":    course,
 			"Curricula": curricula,
 			"Teachings": m,
+			"Periods":   periods,
+			"Lang":      lang,
+			"Messages":  jsMessages(lang),
 		})
 	}
 }
 
 func getCoursesCal(courses *unibo_integ.CoursesMap) func(c *gin.Context) {
 	return func(ctx *gin.Context) {
-		id := ctx.Param("id")
-		anno := ctx.Param("anno")
-
-		// Check if id is a number, otherwise return 400
-		annoInt, err := strconv.Atoi(anno)
-		if err != nil {
-			ctx.String(http.StatusBadRequest, "Invalid year")
-			return
-		}
-
-		// Check if id is a number, otherwise return 400
-		idInt, err := strconv.Atoi(id)
-		if err != nil {
-			ctx.String(http.StatusBadRequest, "Invalid id")
-			return
-		}
+		cal, err := lessonsFeed(ctx, courses)
+		sendCalendar(ctx, cal, err)
+	}
+}
 
-		// Check if course exists, otherwise return 404
-		course, found := courses.FindById(idInt)
-		if !found {
-			ctx.String(http.StatusNotFound, "Course not found")
-			return
-		}
+// lessonsFeed returns the lessons calendar of a course year, with its exams
+// if requested.
+// parseLessonsRequest parses the request of a lessons feed, and whether the
+// exams are included in it. The exam filters are parsed only in that case.
+func parseLessonsRequest(q feedParams, courses *unibo_integ.CoursesMap) (calRequest, bool, error) {
+	req, err := parseCalRequest(q, courses)
+	if err != nil {
+		return calRequest{}, false, err
+	}
 
-		if annoInt <= 0 || annoInt > course.DurataAnni {
-			ctx.String(http.StatusBadRequest, "Invalid year")
-			return
+	withExams := q.Query("exams") == "true"
+	if withExams {
+		if err := req.parseExams(q); err != nil {
+			return calRequest{}, false, err
 		}
+	}
+	return req, withExams, nil
+}
 
-		curriculumId := ctx.Query("curr")
-		curr := curriculum.Curriculum{}
-		if curriculumId != "" {
-			curr.Value = curriculumId
-		}
+func lessonsFeed(q feedParams, courses *unibo_integ.CoursesMap) (*bytes.Buffer, error) {
+	// Include the exams in the same calendar, if requested
+	req, withExams, err := parseLessonsRequest(q, courses)
+	if err != nil {
+		return nil, err
+	}
 
-		subjectIds := ctx.Query("subjects")
-		var subjects []string
-		if subjectIds != "" {
-			tmp := strings.Split(subjectIds, ",")
-			for i := range tmp {
-				if len(tmp[i]) != 0 {
-					subjects = append(subjects, tmp[i])
-				}
-			}
-			log.Debug().Strs("subjects", subjects).Msg("queried subjects")
-		}
+	// Restrict the timetable to a period, if requested
+	interval, err := parseIntervalQuery(q, req.Course, req.Year, req.Curriculum)
+	if errors.Is(err, errInvalidInterval) {
+		return nil, &calError{http.StatusBadRequest, err.Error(), nil}
+	} else if err != nil {
+		return nil, &calError{http.StatusInternalServerError, "Unable to retrieve periods", err}
+	}
 
-		slices.Sort(subjects)
+	// Keep only in-person or online lessons, if requested
+	attendance, err := parseAttendanceMode(q.Query("attendance"))
+	if err != nil {
+		return nil, &calError{http.StatusBadRequest, err.Error(), nil}
+	}
 
-		cacheKey := fmt.Sprintf("%s-%s-%s-%s", id, anno, curr.Value, subjects)
-		if cal, found := calcache.Get(cacheKey); found {
-			successCalendar(ctx, cal.(*bytes.Buffer))
-			return
-		}
+	opts, err := parseLessonOptions(q)
+	if err != nil {
+		return nil, &calError{http.StatusBadRequest, err.Error(), nil}
+	}
 
+	cacheKey := fmt.Sprintf("lessons-%s-%t-%s-%s-%s",
+		req.cacheKey(), withExams, intervalKey(interval), attendance, opts.key())
+	return cachedCalendar(calcache, cacheKey, func() (*ics.Calendar, error) {
 		// Try to retrieve timetable, otherwise return 500
-		courseTimetable, err := course.GetTimetable(annoInt, curr, nil)
+		courseTimetable, err := req.Course.GetTimetable(req.Year, req.Curriculum, interval)
 		if err != nil {
-			_ = ctx.Error(err)
-			ctx.String(http.StatusInternalServerError, "Unable to retrieve timetable")
-			return
+			return nil, &calError{http.StatusInternalServerError, "Unable to retrieve timetable", err}
 		}
 
-		cal, err := createCourseCal(courseTimetable, course, annoInt, subjects)
+		courseTimetable = filterTimetableByAttendance(courseTimetable, attendance)
+
+		cal, err := createCourseCal(courseTimetable, req.Course, req.Year, req.Subjects, opts)
 		if err != nil {
-			_ = ctx.Error(err)
-			ctx.String(http.StatusInternalServerError, "Unable to create calendar")
-			return
+			return nil, &calError{http.StatusInternalServerError, "Unable to create calendar", err}
 		}
 
-		buf := bytes.NewBuffer(nil)
-		err = cal.SerializeTo(buf)
-		if err != nil {
-			_ = ctx.Error(err)
-			ctx.String(http.StatusInternalServerError, "Unable to serialize calendar")
-			return
+		if withExams {
+			courseExams, err := req.exams()
+			if err != nil {
+				return nil, err
+			}
+
+			err = addExamsToCal(cal, courseExams, req.Course, req.Year, req.Registrations, req.Lang)
+			if err != nil {
+				return nil, &calError{http.StatusInternalServerError, "Unable to create calendar", err}
+			}
 		}
 
-		calcache.Set(cacheKey, buf, cache.DefaultExpiration)
+		err = req.addAcademic(cal)
+		if err != nil {
+			return nil, err
+		}
 
-		successCalendar(ctx, buf)
-	}
+		return cal, nil
+	})
 }
 
 func getExams(courses *unibo_integ.CoursesMap) func(c *gin.Context) {
 	return func(ctx *gin.Context) {
-		id := ctx.Param("id")
-		anno := ctx.Param("anno")
+		cal, err := examsFeed(ctx, courses)
+		sendCalendar(ctx, cal, err)
+	}
+}
+
+// examsFeed returns the exams calendar of a course year.
+func examsFeed(q feedParams, courses *unibo_integ.CoursesMap) (*bytes.Buffer, error) {
+	req, err := parseCalRequest(q, courses)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.parseExams(q); err != nil {
+		return nil, err
+	}
 
-		// Check if id is a number, otherwise return 400
-		annoInt, err := strconv.Atoi(anno)
+	cacheKey := fmt.Sprintf("exams-%s", req.cacheKey())
+	return cachedCalendar(examscache, cacheKey, func() (*ics.Calendar, error) {
+		filteredExams, err := req.exams()
 		if err != nil {
-			ctx.String(http.StatusBadRequest, "Invalid year")
-			return
+			return nil, err
 		}
 
-		// Check if id is a number, otherwise return 400
-		idInt, err := strconv.Atoi(id)
+		calName := tr(req.Lang, "cal.exams.name", req.Year, req.Course.Descrizione)
+		description := tr(req.Lang, "cal.exams.description", req.Year, req.Course.Descrizione)
+
+		cal, err := createExamsCal(filteredExams, calName, description, req.Registrations, req.Lang)
 		if err != nil {
-			ctx.String(http.StatusBadRequest, "Invalid id")
-			return
+			return nil, &calError{http.StatusInternalServerError, "Unable to create calendar", err}
 		}
 
-		// Check if course exists, otherwise return 404
-		course, found := courses.FindById(idInt)
-		if !found {
-			ctx.String(http.StatusNotFound, "Course not found")
-			return
+		err = req.addAcademic(cal)
+		if err != nil {
+			return nil, err
 		}
 
-		if annoInt <= 0 || annoInt > course.DurataAnni {
-			ctx.String(http.StatusBadRequest, "Invalid year")
-			return
-		}
+		return cal, nil
+	})
+}
 
-		curriculumId := ctx.Query("curr")
-		curr := curriculum.Curriculum{}
-		isCurrValid := false
-		if curriculumId != "" {
-			curr.Value = curriculumId
-			isCurrValid = true
-		}
+var errInvalidCurriculum = errors.New("invalid curriculum")
+
+// getCourseExams returns the exams of the given year and curriculum of the
+// course. If isCurrValid is false, the first curriculum of the year is used.
+//
+// If subjects is not empty, only the exams of those subjects are returned.
+func getCourseExams(
+	course *unibo_integ.Course,
+	annoInt int,
+	curr curriculum.Curriculum,
+	isCurrValid bool,
+	subjects []string,
+) ([]exams.Exam, error) {
+	filteredExams, _, err := matchCourseExams(course, annoInt, curr, isCurrValid, subjects)
+	return filteredExams, err
+}
 
-		subjectIds := ctx.Query("subjects")
-		var subjects []string
-		if subjectIds != "" {
-			tmp := strings.Split(subjectIds, ",")
-			for i := range tmp {
-				if len(tmp[i]) != 0 {
-					subjects = append(subjects, tmp[i])
-				}
-			}
-			log.Debug().Strs("subjects", subjects).Msg("queried subjects")
-		}
+// matchCourseExams works like getCourseExams, but it also returns the
+// subjects without any matching exam.
+func matchCourseExams(
+	course *unibo_integ.Course,
+	annoInt int,
+	curr curriculum.Curriculum,
+	isCurrValid bool,
+	subjects []string,
+) ([]exams.Exam, []timetable.SimpleSubject, error) {
+	courseID, err := course.GetCourseWebsiteId()
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to get course website id: %w", err)
+	}
 
-		slices.Sort(subjects)
+	curricula, err := course.GetAllCurricula()
+	if err != nil {
+		log.Err(err).Msg("unable to retrieve curricula")
+		curricula = nil
+	}
 
-		courseID, err := course.GetCourseWebsiteId()
-		if err != nil {
-			ctx.String(http.StatusInternalServerError, "Unable to get course website id")
-			return
-		}
+	subjectsMap, err := getSubjectsMapFromCourseAndCurricula(course, curricula)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to get subjects for course and curricula: %w", err)
+	}
 
-		curricula, err := course.GetAllCurricula()
-		if err != nil {
-			_ = ctx.Error(fmt.Errorf("unable to retrieve curricula: %w", err))
-			curricula = nil
+	if isCurrValid {
+		index := slices.IndexFunc([]curriculum.Curriculum(curricula[annoInt]), func(c curriculum.Curriculum) bool { return c.Value == curr.Value })
+		if index == -1 {
+			return nil, nil, errInvalidCurriculum
 		}
 
-		subjectsMap, err := getSubjectsMapFromCourseAndCurricula(course, curricula)
-		if err != nil {
-			ctx.String(http.StatusInternalServerError, "Unable to get subjects for course and curricula")
-			return
-		}
+		curr = curricula[annoInt][index]
+	} else {
+		curr = curricula[annoInt][0]
+	}
+	validSubjects := subjectsMap[annoInt][curr]
 
-		if isCurrValid {
-			log.Printf("%v", curr)
-			index := slices.IndexFunc([]curriculum.Curriculum(curricula[annoInt]), func(c curriculum.Curriculum) bool { return c.Value == curr.Value })
-			if index == -1 {
-				ctx.String(http.StatusBadRequest, "Invalid curriculum")
-				return
-			}
+	log.Debug().Any("validSubjects", validSubjects).Msg("validSubjects")
 
-			curr = curricula[annoInt][index]
-		} else {
-			curr = curricula[annoInt][0]
-		}
-		validSubjects := subjectsMap[annoInt][curr]
-
-		filteredValidSubjectsCodes := make([]string, 0)
-		for _, s := range validSubjects {
-			if slices.Contains(subjects, s.Code) || len(subjects) == 0 {
-				// Some subject codes are not valid, because have the module number in the code.
-				// Something like "04642_1". We need to extract only the first part.
-				// TODO: Some codes are like "SPOT_79006" for the 8005 course. I've no idea what that means. We should check if they are valid on the exams period.
-				filteredValidSubjectsCodes = append(filteredValidSubjectsCodes, strings.Split(s.Code, "_")[0])
-			}
-		}
+	allExams, err := exams.GetExams(courseID.Tipologia, courseID.Id)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to get exams: %w", err)
+	}
 
-		log.Debug().Any("validSubjects", validSubjects).Msg("validSubjects")
+	log.Debug().Any("allExams", allExams).Msg("allExams")
 
-		allExams, err := exams.GetExams(courseID.Tipologia, courseID.Id)
-		if err != nil {
-			ctx.String(http.StatusInternalServerError, "Unable to get exams")
-			return
+	matcher := newExamMatcher(allExams)
+
+	filteredExams := make([]exams.Exam, 0)
+	unmatched := make([]timetable.SimpleSubject, 0)
+	for _, s := range validSubjects {
+		if len(subjects) != 0 && !slices.Contains(subjects, s.Code) {
+			continue
 		}
 
-		log.Debug().Any("allExams", allExams).Msg("allExams")
-		log.Debug().Any("filteredValidSubjectsCodes", filteredValidSubjectsCodes).Msg("filteredValidSubjectsCodes")
+		matched := matcher.match(s)
+		if len(matched) == 0 {
+			unmatched = append(unmatched, s)
+			continue
+		}
 
-		filteredExams := make([]exams.Exam, 0)
-		for _, exam := range allExams {
-			if slices.Contains(filteredValidSubjectsCodes, exam.SubjectCode) {
+		// Modules of the same integrated course share its exams
+		for _, exam := range matched {
+			if !slices.Contains(filteredExams, exam) {
 				filteredExams = append(filteredExams, exam)
 			}
 		}
+	}
 
-		log.Debug().Any("filteredExams", filteredExams).Msg("filteredExams")
-
-		calName := fmt.Sprintf("Esami %d anno %s", annoInt, course.Descrizione)
-		description := fmt.Sprintf("Esami del %d anno del corso di %s", annoInt, course.Descrizione)
-
-		cal, err := createExamsCal(filteredExams, calName, description)
-		if err != nil {
-			_ = ctx.Error(err)
-			ctx.String(http.StatusInternalServerError, "Unable to create calendar")
-			return
-		}
+	slices.SortStableFunc(filteredExams, func(a, b exams.Exam) int {
+		return a.Date.Compare(b.Date)
+	})
 
-		buf := bytes.NewBuffer(nil)
-		err = cal.SerializeTo(buf)
-		if err != nil {
-			_ = ctx.Error(err)
-			ctx.String(http.StatusInternalServerError, "Unable to serialize calendar")
-			return
-		}
+	log.Debug().Any("filteredExams", filteredExams).Msg("filteredExams")
 
-		successCalendar(ctx, buf)
-	}
+	return filteredExams, unmatched, nil
 }
 
 func successCalendar(c *gin.Context, cal *bytes.Buffer) {
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	ics "github.com/arran4/golang-ical"
+	"github.com/cartabinaria/unibo-go/timetable"
+)
+
+// attendanceMode selects lessons by the way they can be attended.
+type attendanceMode string
+
+const (
+	attendanceAll      attendanceMode = ""
+	attendanceInPerson attendanceMode = "inperson"
+	attendanceOnline   attendanceMode = "online"
+)
+
+func parseAttendanceMode(s string) (attendanceMode, error) {
+	switch m := attendanceMode(s); m {
+	case attendanceAll, attendanceInPerson, attendanceOnline:
+		return m, nil
+	default:
+		return "", fmt.Errorf("invalid attendance %q", s)
+	}
+}
+
+// virtualClassroomWords are found in the description of the classrooms that
+// are not physical rooms.
+var virtualClassroomWords = []string{"virtuale", "virtual", "online", "teams", "teledidattica"}
+
+func isVirtualClassroom(c timetable.Classroom) bool {
+	desc := strings.ToLower(c.ResourceDesc)
+	for _, word := range virtualClassroomWords {
+		if strings.Contains(desc, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// physicalClassrooms returns the classrooms of the event that are not virtual.
+func physicalClassrooms(event timetable.Event) []timetable.Classroom {
+	classrooms := make([]timetable.Classroom, 0, len(event.Classrooms))
+	for _, c := range event.Classrooms {
+		if !isVirtualClassroom(c) {
+			classrooms = append(classrooms, c)
+		}
+	}
+	return classrooms
+}
+
+// lessonOnline reports whether the lesson can be attended remotely. Hybrid
+// lessons are both online and in person.
+func lessonOnline(event timetable.Event) bool {
+	if event.RemoteLearning || event.Teams != "" {
+		return true
+	}
+	return len(event.Classrooms) != 0 && len(physicalClassrooms(event)) == 0
+}
+
+// lessonInPerson reports whether the lesson takes place in a physical classroom.
+// Lessons without any classroom are considered in person, unless they are online.
+func lessonInPerson(event timetable.Event) bool {
+	if len(event.Classrooms) == 0 {
+		return !lessonOnline(event)
+	}
+	return len(physicalClassrooms(event)) != 0
+}
+
+// filterTimetableByAttendance returns the lessons of the timetable that can be
+// attended in the given mode.
+func filterTimetableByAttendance(t timetable.Timetable, mode attendanceMode) timetable.Timetable {
+	if mode == attendanceAll {
+		return t
+	}
+
+	filtered := make(timetable.Timetable, 0, len(t))
+	for _, event := range t {
+		if (mode == attendanceOnline && lessonOnline(event)) ||
+			(mode == attendanceInPerson && lessonInPerson(event)) {
+			filtered = append(filtered, event)
+		}
+	}
+	return filtered
+}
+
+// addOnlineProperties adds the link of the virtual room of the lesson as the
+// URL of the event and as a CONFERENCE property (RFC 7986).
+func addOnlineProperties(e *ics.VEvent, event timetable.Event) {
+	if event.Teams == "" {
+		return
+	}
+
+	e.SetURL(event.Teams)
+	e.AddProperty("CONFERENCE", event.Teams,
+		ics.WithValue("URI"),
+		&ics.KeyValues{Key: "FEATURE", Value: []string{"AUDIO", "VIDEO"}},
+		&ics.KeyValues{Key: "LABEL", Value: []string{"Microsoft Teams"}},
+	)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+	"github.com/cartabinaria/unibo-go/timetable"
+	"github.com/go-playground/assert/v2"
+)
+
+func Test_lessonAttendance(t *testing.T) {
+	room := timetable.Classroom{ResourceDesc: "AULA 1.4"}
+	virtual := timetable.Classroom{ResourceDesc: "AULA VIRTUALE TEAMS"}
+
+	tests := []struct {
+		name         string
+		event        timetable.Event
+		wantOnline   bool
+		wantInPerson bool
+	}{
+		{"in person", timetable.Event{Classrooms: []timetable.Classroom{room}}, false, true},
+		{"no classrooms", timetable.Event{}, false, true},
+		{"remote learning", timetable.Event{RemoteLearning: true}, true, false},
+		{"virtual classroom", timetable.Event{Classrooms: []timetable.Classroom{virtual}}, true, false},
+		{
+			"hybrid",
+			timetable.Event{Teams: "https://teams.microsoft.com/l/x", Classrooms: []timetable.Classroom{room}},
+			true,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantOnline, lessonOnline(tt.event))
+			assert.Equal(t, tt.wantInPerson, lessonInPerson(tt.event))
+		})
+	}
+}
+
+func Test_filterTimetableByAttendance(t *testing.T) {
+	inPerson := timetable.Event{CodModulo: "A", Classrooms: []timetable.Classroom{{ResourceDesc: "AULA 1"}}}
+	online := timetable.Event{CodModulo: "B", RemoteLearning: true}
+	hybrid := timetable.Event{CodModulo: "C", Teams: "https://teams", Classrooms: inPerson.Classrooms}
+	tt := timetable.Timetable{inPerson, online, hybrid}
+
+	assert.Equal(t, tt, filterTimetableByAttendance(tt, attendanceAll))
+	assert.Equal(t, timetable.Timetable{inPerson, hybrid}, filterTimetableByAttendance(tt, attendanceInPerson))
+	assert.Equal(t, timetable.Timetable{online, hybrid}, filterTimetableByAttendance(tt, attendanceOnline))
+}
+
+func Test_addLessonEvent_online(t *testing.T) {
+	start := time.Date(2024, 10, 1, 9, 0, 0, 0, romeLocation)
+	event := timetable.Event{
+		CodModulo: "04642",
+		Title:     "ALGEBRA E GEOMETRIA",
+		Teams:     "https://teams.microsoft.com/l/meetup-join/abc",
+		Start:     timetable.CalendarTime{Time: start},
+		End:       timetable.CalendarTime{Time: start.Add(2 * time.Hour)},
+	}
+
+	cal := ics.NewCalendar()
+	err := addLessonEvent(cal, event, lessonOptions{})
+	assert.Equal(t, nil, err)
+
+	e := cal.Events()[0]
+	assert.Equal(t, "Online", e.GetProperty(ics.ComponentPropertyLocation).Value)
+	assert.Equal(t, event.Teams, e.GetProperty(ics.ComponentPropertyUrl).Value)
+	assert.Equal(t, event.Teams, e.GetProperty("CONFERENCE").Value)
+	assert.Equal(t, true, strings.Contains(e.GetProperty(ics.ComponentPropertyDescription).Value, "Online: "+event.Teams))
+}
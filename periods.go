@@ -0,0 +1,204 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cartabinaria/unibo-go/curriculum"
+	"github.com/cartabinaria/unibo-go/timetable"
+
+	"github.com/VaiTon/unibocalendar/unibo_integ"
+)
+
+// dateLayout is the layout of the dates given in query parameters
+const dateLayout = "2006-01-02"
+
+var errInvalidInterval = errors.New("invalid interval")
+
+var italianMonths = map[string]time.Month{
+	"gennaio":   time.January,
+	"febbraio":  time.February,
+	"marzo":     time.March,
+	"aprile":    time.April,
+	"maggio":    time.May,
+	"giugno":    time.June,
+	"luglio":    time.July,
+	"agosto":    time.August,
+	"settembre": time.September,
+	"ottobre":   time.October,
+	"novembre":  time.November,
+	"dicembre":  time.December,
+}
+
+// period is a teaching period (usually a semester) of a timetable.
+type period struct {
+	Label string
+	Start time.Time
+	End   time.Time
+}
+
+func (p period) Interval() *timetable.Interval {
+	return &timetable.Interval{Start: p.Start, End: p.End}
+}
+
+// Key returns the start and end dates of the period, which identify it in the
+// "period" query parameter. Unlike its position, the key doesn't change when
+// other periods are added to or removed from the timetable.
+func (p period) Key() string {
+	return intervalKey(p.Interval())
+}
+
+// findPeriod returns the period of periods with the given key.
+func findPeriod(periods []period, key string) (period, bool) {
+	i := slices.IndexFunc(periods, func(p period) bool { return p.Key() == key })
+	if i < 0 {
+		return period{}, false
+	}
+	return periods[i], true
+}
+
+// parseItalianDay parses a date like "19 settembre 2023".
+func parseItalianDay(s string) (time.Time, error) {
+	fields := strings.Fields(strings.ToLower(s))
+	if len(fields) != 3 {
+		return time.Time{}, fmt.Errorf("invalid date %q", s)
+	}
+
+	day, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid day in date %q", s)
+	}
+
+	month, ok := italianMonths[fields[1]]
+	if !ok {
+		return time.Time{}, fmt.Errorf("invalid month in date %q", s)
+	}
+
+	year, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid year in date %q", s)
+	}
+
+	return time.Date(year, month, day, 0, 0, 0, 0, romeLocation), nil
+}
+
+// parsePeriod parses a period like "18 settembre 2023 - 20 dicembre 2023",
+// as found in the timetable events.
+func parsePeriod(s string) (period, error) {
+	start, end, found := strings.Cut(s, " - ")
+	if !found {
+		return period{}, fmt.Errorf("invalid period %q", s)
+	}
+
+	startDate, err := parseItalianDay(start)
+	if err != nil {
+		return period{}, err
+	}
+
+	endDate, err := parseItalianDay(end)
+	if err != nil {
+		return period{}, err
+	}
+
+	return period{Label: strings.TrimSpace(s), Start: startDate, End: endDate}, nil
+}
+
+// timetablePeriods returns the distinct calendar periods of the timetable,
+// sorted by start date. Periods that can't be parsed are ignored.
+func timetablePeriods(t timetable.Timetable) []period {
+	periods := make([]period, 0)
+	for _, event := range t {
+		label := event.CalendarInterval
+		if label == "" {
+			label = event.Interval
+		}
+
+		if slices.ContainsFunc(periods, func(p period) bool { return p.Label == label }) {
+			continue
+		}
+
+		p, err := parsePeriod(label)
+		if err != nil {
+			continue
+		}
+		periods = append(periods, p)
+	}
+
+	slices.SortFunc(periods, func(a, b period) int {
+		if c := a.Start.Compare(b.Start); c != 0 {
+			return c
+		}
+		return a.End.Compare(b.End)
+	})
+	return periods
+}
+
+// getCoursePeriods returns the periods of the given course year and curriculum.
+func getCoursePeriods(course *unibo_integ.Course, year int, curr curriculum.Curriculum) ([]period, error) {
+	t, err := getCachedTimetable(course, year, curr)
+	if err != nil {
+		return nil, err
+	}
+	return timetablePeriods(t), nil
+}
+
+// parseIntervalQuery returns the interval requested with the "start" and
+// "end" query parameters (e.g. start=2024-09-16&end=2024-12-20) or with
+// the "period" parameter, the key of one of the periods returned by
+// getCoursePeriods (e.g. period=2024-09-16_2024-12-20). If none is given, nil
+// is returned.
+func parseIntervalQuery(
+	q feedParams,
+	course *unibo_integ.Course,
+	year int,
+	curr curriculum.Curriculum,
+) (*timetable.Interval, error) {
+	startStr, endStr := q.Query("start"), q.Query("end")
+	if startStr != "" || endStr != "" {
+		start, err := time.ParseInLocation(dateLayout, startStr, romeLocation)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid start date", errInvalidInterval)
+		}
+
+		end, err := time.ParseInLocation(dateLayout, endStr, romeLocation)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid end date", errInvalidInterval)
+		}
+
+		if end.Before(start) {
+			return nil, fmt.Errorf("%w: end date is before start date", errInvalidInterval)
+		}
+
+		return &timetable.Interval{Start: start, End: end}, nil
+	}
+
+	periodStr := q.Query("period")
+	if periodStr == "" {
+		return nil, nil
+	}
+
+	periods, err := getCoursePeriods(course, year, curr)
+	if err != nil {
+		return nil, err
+	}
+
+	p, ok := findPeriod(periods, periodStr)
+	if !ok {
+		return nil, fmt.Errorf("%w: invalid period", errInvalidInterval)
+	}
+
+	return p.Interval(), nil
+}
+
+// intervalKey returns a string representation of the interval, to be used in
+// cache keys.
+func intervalKey(interval *timetable.Interval) string {
+	if interval == nil {
+		return ""
+	}
+	return interval.Start.Format(dateLayout) + "_" + interval.End.Format(dateLayout)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cartabinaria/unibo-go/timetable"
+	"github.com/go-playground/assert/v2"
+)
+
+func Test_timetablePeriods(t *testing.T) {
+	tt := timetable.Timetable{
+		{CalendarInterval: "17 febbraio 2025 - 6 giugno 2025"},
+		{CalendarInterval: "16 settembre 2024 - 20 dicembre 2024"},
+		{CalendarInterval: "17 febbraio 2025 - 6 giugno 2025"},
+		{Interval: "23 settembre 2024 - 20 dicembre 2024"},
+		{CalendarInterval: "non valido"},
+	}
+
+	periods := timetablePeriods(tt)
+	assert.Equal(t, 3, len(periods))
+
+	assert.Equal(t, "16 settembre 2024 - 20 dicembre 2024", periods[0].Label)
+	assert.Equal(t, time.Date(2024, time.September, 16, 0, 0, 0, 0, romeLocation), periods[0].Start)
+	assert.Equal(t, time.Date(2024, time.December, 20, 0, 0, 0, 0, romeLocation), periods[0].End)
+
+	assert.Equal(t, "23 settembre 2024 - 20 dicembre 2024", periods[1].Label)
+	assert.Equal(t, time.Date(2025, time.June, 6, 0, 0, 0, 0, romeLocation), periods[2].End)
+
+	// Periods are identified by their dates, not by their position
+	assert.Equal(t, "2024-09-23_2024-12-20", periods[1].Key())
+	p, ok := findPeriod(periods[1:], "2024-09-23_2024-12-20")
+	assert.Equal(t, true, ok)
+	assert.Equal(t, periods[1], p)
+	_, ok = findPeriod(periods, "2")
+	assert.Equal(t, false, ok)
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+	"github.com/gin-gonic/gin"
+
+	"github.com/VaiTon/unibocalendar/unibo_integ"
+)
+
+// feedEvent is an event of a feed, as returned by the events API.
+type feedEvent struct {
+	Uid         string    `json:"uid"`
+	Summary     string    `json:"summary"`
+	Description string    `json:"description,omitempty"`
+	Location    string    `json:"location,omitempty"`
+	Categories  []string  `json:"categories,omitempty"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	// AllDay events start and end at midnight UTC of their dates, the end is
+	// the day after the last one
+	AllDay bool `json:"all_day"`
+}
+
+// utcDate returns the midnight UTC of the date of t.
+func utcDate(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// propertyValue returns the value of a property of the event, or an empty
+// string if it is missing.
+func propertyValue(e *ics.VEvent, property ics.ComponentProperty) string {
+	p := e.GetProperty(property)
+	if p == nil {
+		return ""
+	}
+	return p.Value
+}
+
+// calendarEvents returns the events of the calendar sorted by start.
+// Events without valid dates are skipped.
+func calendarEvents(cal *ics.Calendar) []feedEvent {
+	events := make([]feedEvent, 0)
+	for _, e := range cal.Events() {
+		if event, err := newFeedEvent(e); err == nil {
+			events = append(events, event)
+		}
+	}
+
+	slices.SortStableFunc(events, func(a, b feedEvent) int {
+		return a.Start.Compare(b.Start)
+	})
+	return events
+}
+
+// newFeedEvent reads the event, which must have valid dates.
+func newFeedEvent(e *ics.VEvent) (feedEvent, error) {
+	event := feedEvent{
+		Uid:         e.Id(),
+		Summary:     propertyValue(e, ics.ComponentPropertySummary),
+		Description: propertyValue(e, ics.ComponentPropertyDescription),
+		Location:    propertyValue(e, ics.ComponentPropertyLocation),
+	}
+
+	if p := e.GetProperty(ics.ComponentPropertyCategories); p != nil {
+		event.Categories = strings.Split(p.Value, ",")
+	}
+
+	var err error
+	if p := e.GetProperty(ics.ComponentPropertyDtStart); p != nil && slices.Contains(p.ICalParameters["VALUE"], "DATE") {
+		event.AllDay = true
+		event.Start, err = e.GetAllDayStartAt()
+		if err == nil {
+			event.End, err = e.GetAllDayEndAt()
+		}
+		event.Start, event.End = utcDate(event.Start), utcDate(event.End)
+	} else {
+		event.Start, err = e.GetStartAt()
+		if err == nil {
+			event.End, err = e.GetEndAt()
+		}
+	}
+	return event, err
+}
+
+// calendarName returns the name of the calendar, if it has one.
+func calendarName(cal *ics.Calendar) string {
+	for _, p := range cal.CalendarProperties {
+		if p.IANAToken == string(ics.PropertyName) || p.IANAToken == string(ics.PropertyXWRCalName) {
+			return p.Value
+		}
+	}
+	return ""
+}
+
+// feedUrlParams are the parameters of a feed read by the server itself,
+// from its path and query.
+type feedUrlParams struct {
+	params map[string]string
+	query  url.Values
+}
+
+func (p feedUrlParams) Param(key string) string {
+	return p.params[key]
+}
+
+func (p feedUrlParams) Query(key string) string {
+	return p.query.Get(key)
+}
+
+func (p feedUrlParams) QueryArray(key string) []string {
+	return p.query[key]
+}
+
+// feedSource returns the calendar of a feed of this site, given its path and
+// query as returned by parseFeedPath. Errors are *calError when they carry
+// the response for the client.
+type feedSource func(feed *url.URL) (*bytes.Buffer, error)
+
+// matchFeedRoute matches the path with a route like "/cal/:id/:anno",
+// returning its path parameters.
+func matchFeedRoute(route, path string) (map[string]string, bool) {
+	routeParts := strings.Split(strings.Trim(route, "/"), "/")
+	pathParts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(routeParts) != len(pathParts) {
+		return nil, false
+	}
+
+	params := make(map[string]string)
+	for i, part := range routeParts {
+		if name, found := strings.CutPrefix(part, ":"); found && pathParts[i] != "" {
+			params[name] = pathParts[i]
+		} else if part != pathParts[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// siteFeeds reads the feeds of this site from their builders, the same used
+// by the handlers of the feeds, so every filter is supported and the cached
+// calendars are used. Nothing of the request that asked for the feed is
+// passed on.
+func siteFeeds(courses *unibo_integ.CoursesMap) feedSource {
+	// The static routes come before the ones with the same number of parts
+	routes := []struct {
+		route string
+		build func(q feedParams) (*bytes.Buffer, error)
+	}{
+		{"/cal/custom", func(q feedParams) (*bytes.Buffer, error) { return customFeed(q, courses) }},
+		{"/cal/academic", academicFeed},
+		{"/cal/teacher/:slug", teacherFeed},
+		{"/cal/room/:id", roomFeed},
+		{"/cal/:id/:anno", func(q feedParams) (*bytes.Buffer, error) { return lessonsFeed(q, courses) }},
+		{"/exams/:id/:anno", func(q feedParams) (*bytes.Buffer, error) { return examsFeed(q, courses) }},
+		{"/s/:token", func(q feedParams) (*bytes.Buffer, error) { return linkFeed(q, courses) }},
+	}
+
+	return func(feed *url.URL) (*bytes.Buffer, error) {
+		for _, r := range routes {
+			if params, ok := matchFeedRoute(r.route, feed.Path); ok {
+				return r.build(feedUrlParams{params: params, query: feed.Query()})
+			}
+		}
+		return nil, &calError{http.StatusNotFound, "Feed not found", nil}
+	}
+}
+
+// readFeed returns the calendar of the feed read from feeds.
+func readFeed(feeds feedSource, feed *url.URL) (*ics.Calendar, error) {
+	buf, err := feeds(feed)
+	if err != nil {
+		return nil, err
+	}
+
+	cal, err := ics.ParseCalendar(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		return nil, &calError{http.StatusInternalServerError, "Unable to read calendar", err}
+	}
+	return cal, nil
+}
+
+// getFeedEvents returns as JSON the events of the feed given with the "url"
+// query parameter, e.g. "/cal/8009/1?curr=000-000&subjects=00013", read
+// from feeds.
+func getFeedEvents(feeds feedSource) func(c *gin.Context) {
+	return func(ctx *gin.Context) {
+		feed, err := parseFeedPath(ctx.Query("url"))
+		if err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		cal, err := readFeed(feeds, feed)
+		if err != nil {
+			sendCalError(ctx, err)
+			return
+		}
+
+		ctx.JSON(http.StatusOK, gin.H{
+			"url":    feed.String(),
+			"name":   calendarName(cal),
+			"events": calendarEvents(cal),
+		})
+	}
+}
+
+// previewPage shows the events of the feed given with the "url" query
+// parameter, as an agenda or a week. The events are loaded by
+// static/js/preview.js from getFeedEvents.
+func previewPage() func(c *gin.Context) {
+	return func(ctx *gin.Context) {
+		feed, err := parseFeedPath(ctx.Query("url"))
+		if err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		host, ok := publicHost(ctx)
+		if !ok {
+			ctx.String(http.StatusBadRequest, "Invalid host")
+			return
+		}
+
+		lang := pageLang(ctx)
+		ctx.HTML(http.StatusOK, "preview", gin.H{
+			"Feed":     feed.String(),
+			"Host":     host,
+			"Lang":     lang,
+			"Messages": jsMessages(lang),
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/assert/v2"
+
+	"github.com/VaiTon/unibocalendar/unibo_integ"
+)
+
+func testPreviewCalendar(t *testing.T) *bytes.Buffer {
+	cal := ics.NewCalendar()
+	cal.SetName("Informatica - 1 anno")
+
+	lesson := cal.AddEvent("lesson")
+	lesson.SetSummary("Algebra, lineare")
+	lesson.SetLocation("Aula E1")
+	lesson.AddCategory("Lezione")
+	lesson.SetStartAt(time.Date(2025, 10, 21, 9, 0, 0, 0, time.UTC))
+	lesson.SetEndAt(time.Date(2025, 10, 21, 11, 0, 0, 0, time.UTC))
+
+	holiday := cal.AddEvent("holiday")
+	holiday.SetSummary("Ognissanti")
+	holiday.SetAllDayStartAt(time.Date(2025, 11, 1, 0, 0, 0, 0, romeLocation))
+	holiday.SetAllDayEndAt(time.Date(2025, 11, 2, 0, 0, 0, 0, romeLocation))
+
+	first := cal.AddEvent("first")
+	first.SetSummary("Analisi")
+	first.SetStartAt(time.Date(2025, 10, 20, 9, 0, 0, 0, time.UTC))
+	first.SetEndAt(time.Date(2025, 10, 20, 11, 0, 0, 0, time.UTC))
+
+	buf := bytes.NewBuffer(nil)
+	assert.Equal(t, nil, cal.SerializeTo(buf))
+	return buf
+}
+
+func Test_calendarEvents(t *testing.T) {
+	cal, err := ics.ParseCalendar(testPreviewCalendar(t))
+	assert.Equal(t, nil, err)
+
+	assert.Equal(t, "Informatica - 1 anno", calendarName(cal))
+
+	events := calendarEvents(cal)
+	assert.Equal(t, 3, len(events))
+	assert.Equal(t, "first", events[0].Uid)
+
+	lesson := events[1]
+	assert.Equal(t, "Algebra, lineare", lesson.Summary)
+	assert.Equal(t, "Aula E1", lesson.Location)
+	assert.Equal(t, []string{"Lezione"}, lesson.Categories)
+	assert.Equal(t, false, lesson.AllDay)
+	assert.Equal(t, time.Date(2025, 10, 21, 9, 0, 0, 0, time.UTC), lesson.Start.UTC())
+
+	holiday := events[2]
+	assert.Equal(t, true, holiday.AllDay)
+	assert.Equal(t, time.Date(2025, 11, 1, 0, 0, 0, 0, time.UTC), holiday.Start)
+	assert.Equal(t, time.Date(2025, 11, 2, 0, 0, 0, 0, time.UTC), holiday.End)
+}
+
+func Test_getFeedEvents(t *testing.T) {
+	calendar := testPreviewCalendar(t)
+
+	feeds := func(feed *url.URL) (*bytes.Buffer, error) {
+		if feed.Path != "/cal/8009/1" {
+			return nil, &calError{http.StatusNotFound, "Feed not found", nil}
+		}
+		assert.Equal(t, "000-000", feed.Query().Get("curr"))
+		return bytes.NewBuffer(calendar.Bytes()), nil
+	}
+
+	r := gin.New()
+	r.GET("/api/events", getFeedEvents(feeds))
+
+	request := func(feed string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/events?"+url.Values{"url": {feed}}.Encode(), nil))
+		return w
+	}
+
+	w := request("/cal/8009/1?curr=000-000")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var got struct {
+		Url    string      `json:"url"`
+		Name   string      `json:"name"`
+		Events []feedEvent `json:"events"`
+	}
+	assert.Equal(t, nil, json.Unmarshal(w.Body.Bytes(), &got))
+	assert.Equal(t, "/cal/8009/1?curr=000-000", got.Url)
+	assert.Equal(t, "Informatica - 1 anno", got.Name)
+	assert.Equal(t, 3, len(got.Events))
+
+	assert.Equal(t, http.StatusBadRequest, request("https://example.com/cal/8009/1").Code)
+	assert.Equal(t, http.StatusBadRequest, request("/api/events?url=/cal/8009/1").Code)
+	assert.Equal(t, http.StatusNotFound, request("/exams/8009/1").Code)
+}
+
+func Test_previewPage(t *testing.T) {
+	r := setupRouter(unibo_integ.CoursesMap{})
+
+	for _, tt := range []struct {
+		feed     string
+		wantCode int
+	}{
+		{"/cal/8009/1?subjects=1,2", http.StatusOK},
+		{"/courses/8009", http.StatusBadRequest},
+		{"", http.StatusBadRequest},
+	} {
+		t.Run(tt.feed, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/preview?"+url.Values{"url": {tt.feed}}.Encode(), nil))
+			assert.Equal(t, tt.wantCode, w.Code)
+		})
+	}
+
+	// The subscribe link is built only from a valid host
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/preview?url=%2Fcal%2F8009%2F1", nil))
+	assert.Equal(t, true, strings.Contains(w.Body.String(), `href="webcal://example.com/cal/8009/1"`))
+
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/preview?url=%2Fcal%2F8009%2F1", nil)
+	req.Host = "evil.example/phishing?"
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func Test_matchFeedRoute(t *testing.T) {
+	tests := []struct {
+		route, path string
+		want        map[string]string
+		wantOk      bool
+	}{
+		{"/cal/:id/:anno", "/cal/8009/1", map[string]string{"id": "8009", "anno": "1"}, true},
+		{"/cal/custom", "/cal/custom", map[string]string{}, true},
+		{"/cal/:id/:anno", "/cal/custom", nil, false},
+		{"/cal/:id/:anno", "/cal/8009/1/x", nil, false},
+		{"/cal/teacher/:slug", "/cal/room/1", nil, false},
+		{"/s/:token", "/s/", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.route+" "+tt.path, func(t *testing.T) {
+			got, ok := matchFeedRoute(tt.route, tt.path)
+			assert.Equal(t, tt.wantOk, ok)
+			if ok {
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func Test_siteFeeds(t *testing.T) {
+	feeds := siteFeeds(&unibo_integ.CoursesMap{8009: {Codice: 8009, DurataAnni: 3}})
+
+	tests := []struct {
+		feed     string
+		wantCode int
+	}{
+		{"/cal/academic?lang=en", http.StatusOK},
+		{"/exams/1234/1", http.StatusNotFound},
+		{"/cal/8009/4", http.StatusBadRequest},
+		{"/exams/8009/1?next=0", http.StatusBadRequest},
+		{"/cal/teacher/nobody", http.StatusNotFound},
+		{"/cal/8009/1/2", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.feed, func(t *testing.T) {
+			feed, err := parseFeedPath(tt.feed)
+			assert.Equal(t, nil, err)
+
+			_, err = readFeed(feeds, feed)
+			code := http.StatusOK
+			var ce *calError
+			if errors.As(err, &ce) {
+				code = ce.Status
+			}
+			assert.Equal(t, tt.wantCode, code)
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skip2/go-qrcode"
+)
+
+const (
+	maxFeedUrlLength = 2000
+	defaultQrSize    = 256
+	minQrSize        = 64
+	maxQrSize        = 1024
+)
+
+var errInvalidFeedUrl = errors.New("invalid url")
+
+// feedPathPrefixes are the paths of the feeds of this site.
+var feedPathPrefixes = []string{"/cal/", "/exams/", "/s/"}
+
+// parseFeedPath parses the path of a feed of this site, with its query, e.g.
+// "/cal/8009/1?curr=000-000". Absolute URLs and other pages are rejected, so
+// that the feed can be requested or encoded without reaching other sites.
+func parseFeedPath(feed string) (*url.URL, error) {
+	if len(feed) > maxFeedUrlLength {
+		return nil, fmt.Errorf("%w: too long", errInvalidFeedUrl)
+	}
+
+	u, err := url.Parse(feed)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.User != nil {
+		return nil, fmt.Errorf("%w: only paths are allowed", errInvalidFeedUrl)
+	}
+
+	for _, prefix := range feedPathPrefixes {
+		if strings.HasPrefix(u.Path, prefix) && !strings.Contains(u.Path, "..") {
+			u.Fragment = ""
+			return u, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: not a calendar", errInvalidFeedUrl)
+}
+
+// qrContent returns the URL encoded in the QR code of the given feed path,
+// e.g. "/cal/8009/1?curr=000-000" on host "example.com" becomes
+// "webcal://example.com/cal/8009/1?curr=000-000".
+func qrContent(scheme, host, feed string) (string, error) {
+	u, err := parseFeedPath(feed)
+	if err != nil {
+		return "", err
+	}
+
+	u.Scheme = scheme
+	u.Host = host
+	return u.String(), nil
+}
+
+// qrSvg draws the modules of the QR code as a single SVG path. The image is
+// scalable, so it can be used on slides and flyers of any size.
+func qrSvg(code *qrcode.QRCode) []byte {
+	bitmap := code.Bitmap()
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		len(bitmap), len(bitmap))
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, len(bitmap), len(bitmap))
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&b, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+	b.WriteString(`"/></svg>`)
+
+	return b.Bytes()
+}
+
+// getQrCode returns the QR code of the feed path given with the "url" query
+// parameter, as PNG or SVG ("format" parameter). The "size" of the PNG is in
+// pixels. With "scheme=https" the link is opened in the browser instead of the
+// calendar app.
+func getQrCode() func(c *gin.Context) {
+	return func(ctx *gin.Context) {
+		scheme := ctx.DefaultQuery("scheme", "webcal")
+		if scheme != "webcal" && scheme != "https" {
+			ctx.String(http.StatusBadRequest, "Invalid scheme")
+			return
+		}
+
+		host, ok := publicHost(ctx)
+		if !ok {
+			ctx.String(http.StatusBadRequest, "Invalid host")
+			return
+		}
+
+		content, err := qrContent(scheme, host, ctx.Query("url"))
+		if err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		size := defaultQrSize
+		if s := ctx.Query("size"); s != "" {
+			size, err = strconv.Atoi(s)
+			if err != nil || size < minQrSize || size > maxQrSize {
+				ctx.String(http.StatusBadRequest, "Invalid size")
+				return
+			}
+		}
+
+		code, err := qrcode.New(content, qrcode.Medium)
+		if err != nil {
+			_ = ctx.Error(err)
+			ctx.String(http.StatusInternalServerError, "Unable to create QR code")
+			return
+		}
+
+		// The QR code of a URL never changes
+		ctx.Header("Cache-Control", "public, max-age=86400")
+
+		switch ctx.DefaultQuery("format", "png") {
+		case "png":
+			png, err := code.PNG(size)
+			if err != nil {
+				_ = ctx.Error(err)
+				ctx.String(http.StatusInternalServerError, "Unable to create QR code")
+				return
+			}
+			ctx.Data(http.StatusOK, "image/png", png)
+		case "svg":
+			ctx.Data(http.StatusOK, "image/svg+xml", qrSvg(code))
+		default:
+			ctx.String(http.StatusBadRequest, "Invalid format")
+		}
+	}
+}
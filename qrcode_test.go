@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+	"github.com/skip2/go-qrcode"
+
+	"github.com/VaiTon/unibocalendar/unibo_integ"
+)
+
+func Test_qrContent(t *testing.T) {
+	tests := []struct {
+		name    string
+		scheme  string
+		feed    string
+		want    string
+		wantErr bool
+	}{
+		{"lessons", "webcal", "/cal/8009/1?curr=000-000&subjects=1,2", "webcal://example.com/cal/8009/1?curr=000-000&subjects=1,2", false},
+		{"exams", "https", "/exams/8009/1", "https://example.com/exams/8009/1", false},
+		{"short link", "webcal", "/s/abc#x", "webcal://example.com/s/abc", false},
+		{"custom", "webcal", "/cal/custom?sel=8009:1::", "webcal://example.com/cal/custom?sel=8009:1::", false},
+		{"absolute url", "webcal", "https://evil.example/cal/1/1", "", true},
+		{"scheme relative url", "webcal", "//evil.example/cal/1/1", "", true},
+		{"other page", "webcal", "/courses/8009", "", true},
+		{"parent directory", "webcal", "/cal/../courses/8009", "", true},
+		{"empty", "webcal", "", "", true},
+		{"too long", "webcal", "/cal/8009/1?subjects=" + strings.Repeat("1", maxFeedUrlLength), "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := qrContent(tt.scheme, "example.com", tt.feed)
+			assert.Equal(t, tt.wantErr, err != nil)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_qrSvg(t *testing.T) {
+	code, err := qrcode.New("webcal://example.com/cal/8009/1", qrcode.Medium)
+	assert.Equal(t, nil, err)
+
+	svg := string(qrSvg(code))
+	assert.Equal(t, true, strings.HasPrefix(svg, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 37 37"`))
+	assert.Equal(t, true, strings.HasSuffix(svg, `"/></svg>`))
+}
+
+func Test_getQrCode(t *testing.T) {
+	r := setupRouter(unibo_integ.CoursesMap{})
+
+	tests := []struct {
+		query       string
+		wantCode    int
+		contentType string
+	}{
+		{"url=/cal/8009/1", http.StatusOK, "image/png"},
+		{"url=/cal/8009/1&format=svg", http.StatusOK, "image/svg+xml"},
+		{"url=/cal/8009/1&size=32", http.StatusBadRequest, ""},
+		{"url=/cal/8009/1&format=gif", http.StatusBadRequest, ""},
+		{"url=/cal/8009/1&scheme=ftp", http.StatusBadRequest, ""},
+		{"url=" + url.QueryEscape("https://example.com/"), http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/qr?"+tt.query, nil))
+
+			assert.Equal(t, tt.wantCode, w.Code)
+			if tt.contentType != "" {
+				assert.Equal(t, tt.contentType, w.Header().Get("Content-Type"))
+			}
+			if tt.contentType == "image/png" {
+				assert.Equal(t, true, bytes.HasPrefix(w.Body.Bytes(), []byte("\x89PNG")))
+			}
+		})
+	}
+	// The QR code never points to an invalid host
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/qr?url=/cal/8009/1", nil)
+	req.Host = "evil.example/phishing?"
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
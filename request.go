@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+	"github.com/cartabinaria/unibo-go/curriculum"
+	"github.com/cartabinaria/unibo-go/exams"
+	"github.com/gin-gonic/gin"
+	"github.com/patrickmn/go-cache"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/VaiTon/unibocalendar/unibo_integ"
+)
+
+// calGroup makes concurrent requests for the same calendar share a single
+// computation, so that a burst of subscriptions hits the unibo website once.
+var calGroup singleflight.Group
+
+// calError is an error that carries the response for the client.
+type calError struct {
+	Status  int
+	Message string
+	Err     error
+}
+
+func (e *calError) Error() string {
+	if e.Err == nil {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %v", e.Message, e.Err)
+}
+
+func (e *calError) Unwrap() error {
+	return e.Err
+}
+
+// calRequest contains the validated parameters shared by the lessons and the
+// exams feeds of a course year.
+type calRequest struct {
+	Course *unibo_integ.Course
+	Year   int
+	// Curriculum is the requested curriculum. If it was not given, its value
+	// is empty and HasCurriculum is false.
+	Curriculum    curriculum.Curriculum
+	HasCurriculum bool
+	// Subjects are the sorted codes of the requested subjects, nil for every subject
+	Subjects      []string
+	Academic      bool
+	Registrations registrationMode
+	ExamFilter    examFilter
+	Lang          string
+}
+
+// feedParams are the path and query parameters of a feed. They are given by
+// *gin.Context when a client requests the feed, or by feedUrlParams when the
+// server reads one of its feeds.
+type feedParams interface {
+	Param(key string) string
+	Query(key string) string
+	QueryArray(key string) []string
+}
+
+// parseCalRequest parses the ":id" and ":anno" path parameters and the
+// "curr", "subjects", "academic" and "registrations" query parameters. The
+// exam filters are parsed by parseExams, only for the feeds with exams.
+//
+// If they are not valid, a *calError is returned.
+func parseCalRequest(q feedParams, courses *unibo_integ.CoursesMap) (calRequest, error) {
+	id := q.Param("id")
+	anno := q.Param("anno")
+
+	// Check if anno is a number, otherwise return 400
+	annoInt, err := strconv.Atoi(anno)
+	if err != nil {
+		return calRequest{}, &calError{http.StatusBadRequest, "Invalid year", nil}
+	}
+
+	// Check if id is a number, otherwise return 400
+	idInt, err := strconv.Atoi(id)
+	if err != nil {
+		return calRequest{}, &calError{http.StatusBadRequest, "Invalid id", nil}
+	}
+
+	// Check if course exists, otherwise return 404
+	course, found := courses.FindById(idInt)
+	if !found {
+		return calRequest{}, &calError{http.StatusNotFound, "Course not found", nil}
+	}
+
+	if annoInt <= 0 || annoInt > course.DurataAnni {
+		return calRequest{}, &calError{http.StatusBadRequest, "Invalid year", nil}
+	}
+
+	req := calRequest{Course: course, Year: annoInt}
+
+	curriculumId := q.Query("curr")
+	if curriculumId != "" {
+		req.Curriculum.Value = curriculumId
+		req.HasCurriculum = true
+	}
+
+	subjectIds := q.Query("subjects")
+	if subjectIds != "" {
+		tmp := strings.Split(subjectIds, ",")
+		for i := range tmp {
+			if len(tmp[i]) != 0 {
+				req.Subjects = append(req.Subjects, tmp[i])
+			}
+		}
+		log.Debug().Strs("subjects", req.Subjects).Msg("queried subjects")
+	}
+
+	slices.Sort(req.Subjects)
+
+	// Include holidays, exam sessions and breaks, if requested
+	req.Academic = q.Query("academic") == "true"
+
+	req.Registrations, err = parseRegistrationMode(q.Query("registrations"))
+	if err != nil {
+		return calRequest{}, &calError{http.StatusBadRequest, err.Error(), nil}
+	}
+
+	req.Lang = feedLang(q)
+
+	return req, nil
+}
+
+// parseExams parses the exam filters of a request of a feed with exams, with
+// parseExamFilter.
+//
+// If they are not valid, a *calError is returned.
+func (r *calRequest) parseExams(q feedParams) error {
+	var err error
+	r.ExamFilter, err = parseExamFilter(q)
+	if err != nil {
+		return &calError{http.StatusBadRequest, err.Error(), nil}
+	}
+	return nil
+}
+
+// parseLessonOptions parses the query parameters that change how lessons are
+// written: "location" ("short" or "full") and the ones of parseEventFormat.
+// The language is chosen by feedLang.
+func parseLessonOptions(q feedParams) (lessonOptions, error) {
+	location, err := parseLocationMode(q.Query("location"))
+	if err != nil {
+		return lessonOptions{}, err
+	}
+
+	format, err := parseEventFormat(
+		q.Query("style"), q.Query("summary"), q.Query("description"), q.QueryArray("alias"),
+	)
+	if err != nil {
+		return lessonOptions{}, err
+	}
+
+	return lessonOptions{Lang: feedLang(q), Location: location, Format: format}, nil
+}
+
+// cacheKey returns a key identifying the parameters of the request.
+func (r calRequest) cacheKey() string {
+	return fmt.Sprintf("%d-%d-%s-%s-%t-%s-%s-%s",
+		r.Course.Codice, r.Year, r.Curriculum.Value, r.Subjects, r.Academic, r.Registrations,
+		r.ExamFilter.key(time.Now()), r.Lang)
+}
+
+// exams returns the exams of the requested course year, curriculum and
+// subjects, selected by the exam filter.
+func (r calRequest) exams() ([]exams.Exam, error) {
+	courseExams, err := getCourseExams(r.Course, r.Year, r.Curriculum, r.HasCurriculum, r.Subjects)
+	if errors.Is(err, errInvalidCurriculum) {
+		return nil, &calError{http.StatusBadRequest, "Invalid curriculum", nil}
+	} else if err != nil {
+		return nil, &calError{http.StatusInternalServerError, "Unable to get exams", err}
+	}
+	return r.ExamFilter.apply(courseExams, time.Now()), nil
+}
+
+// addAcademic adds the academic calendar to cal, if requested.
+func (r calRequest) addAcademic(cal *ics.Calendar) error {
+	if !r.Academic {
+		return nil
+	}
+
+	err := addAcademicEvents(cal, academicCal, r.Course.Campus, r.Lang)
+	if err != nil {
+		return &calError{http.StatusInternalServerError, "Unable to create calendar", err}
+	}
+	return nil
+}
+
+// cachedCalendar returns the calendar stored in c with the given key. If it
+// is not cached, it is created with build and then cached. Concurrent
+// requests for the same key wait for a single call of build.
+func cachedCalendar(c *cache.Cache, key string, build func() (*ics.Calendar, error)) (*bytes.Buffer, error) {
+	if cal, found := c.Get(key); found {
+		return cal.(*bytes.Buffer), nil
+	}
+
+	buf, err, _ := calGroup.Do(key, func() (any, error) {
+		cal, err := build()
+		if err != nil {
+			return nil, err
+		}
+
+		buf := bytes.NewBuffer(nil)
+		err = cal.SerializeTo(buf)
+		if err != nil {
+			return nil, &calError{http.StatusInternalServerError, "Unable to serialize calendar", err}
+		}
+
+		c.Set(key, buf, cache.DefaultExpiration)
+		return buf, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf.(*bytes.Buffer), nil
+}
+
+// sendCalError sends the response for an error of a feed. If it is a
+// *calError, its status and message are sent to the client.
+func sendCalError(ctx *gin.Context, err error) {
+	var ce *calError
+	if !errors.As(err, &ce) {
+		ce = &calError{http.StatusInternalServerError, "Unable to create calendar", err}
+	}
+	if ce.Err != nil {
+		_ = ctx.Error(ce.Err)
+	}
+	ctx.String(ce.Status, ce.Message)
+}
+
+// sendCalendar sends the calendar of a feed, or the error that prevented
+// its creation.
+func sendCalendar(ctx *gin.Context, cal *bytes.Buffer, err error) {
+	if err != nil {
+		sendCalError(ctx, err)
+		return
+	}
+	successCalendar(ctx, cal)
+}
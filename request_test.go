@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/assert/v2"
+	"github.com/patrickmn/go-cache"
+)
+
+func Test_cachedCalendar(t *testing.T) {
+	c := cache.New(time.Minute, time.Minute)
+
+	var builds atomic.Int32
+	build := func() (*ics.Calendar, error) {
+		builds.Add(1)
+		time.Sleep(50 * time.Millisecond)
+		return ics.NewCalendar(), nil
+	}
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf, err := cachedCalendar(c, "key", build)
+			assert.Equal(t, nil, err)
+			assert.NotEqual(t, 0, buf.Len())
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), builds.Load())
+	_, found := c.Get("key")
+	assert.Equal(t, true, found)
+
+	_, err := cachedCalendar(c, "error", func() (*ics.Calendar, error) {
+		return nil, &calError{http.StatusBadRequest, "Invalid curriculum", errors.New("test")}
+	})
+	assert.NotEqual(t, nil, err)
+	_, found = c.Get("error")
+	assert.Equal(t, false, found)
+
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	sendCalendar(ctx, nil, err)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "Invalid curriculum", w.Body.String())
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+	"github.com/cartabinaria/unibo-go/timetable"
+	"github.com/gin-gonic/gin"
+	"github.com/patrickmn/go-cache"
+)
+
+const (
+	roomIndexKey      = "rooms"
+	defaultFreeWindow = time.Hour
+	maxRoomResults    = 100
+)
+
+// room is a classroom found in the timetables of the courses, with every
+// lesson held in it.
+type room struct {
+	Id       string
+	Name     string
+	Building string
+	Address  string
+	Campus   string
+	Seats    int
+	Lessons  timetable.Timetable
+}
+
+// freeRoom is a room that has no lessons in the requested window.
+type freeRoom struct {
+	Id        string     `json:"id"`
+	Name      string     `json:"name"`
+	Building  string     `json:"building"`
+	Address   string     `json:"address"`
+	Campus    string     `json:"campus"`
+	Seats     int        `json:"seats"`
+	Calendar  string     `json:"calendar"`
+	FreeUntil *time.Time `json:"free_until,omitempty"`
+}
+
+// roomId returns the identifier of a classroom. The building code is included
+// because rooms in different buildings often share the same name.
+func roomId(c timetable.Classroom) string {
+	return strings.Join(normalizeTokens(c.Raw.Building.Code+" "+c.ResourceDesc), "-")
+}
+
+// classroomCampus returns the town where the classroom is, falling back to
+// the last part of the address (e.g. "Viale del Risorgimento, 2 - Bologna").
+func classroomCampus(c timetable.Classroom) string {
+	if c.Raw.Building.Comune != "" {
+		return c.Raw.Building.Comune
+	}
+	if i := strings.LastIndex(c.AddressDesc, " - "); i != -1 {
+		return strings.TrimSpace(c.AddressDesc[i+3:])
+	}
+	return ""
+}
+
+// sameCampus compares two campus names ignoring case and accents.
+func sameCampus(a, b string) bool {
+	return slices.Equal(normalizeTokens(a), normalizeTokens(b))
+}
+
+// buildRoomIndex groups the lessons of the given timetables by classroom.
+// Every classroom of an event is considered, not only the first one, except
+// the virtual ones.
+func buildRoomIndex(timetables []cachedTimetable) map[string]*room {
+	index := make(map[string]*room)
+	seen := make(map[string]map[string]struct{})
+
+	for _, t := range timetables {
+		for _, event := range t.Timetable {
+			uid, err := lessonUid(event)
+			if err != nil {
+				continue
+			}
+
+			for _, classroom := range physicalClassrooms(event) {
+				id := roomId(classroom)
+				if id == "" {
+					continue
+				}
+
+				r, ok := index[id]
+				if !ok {
+					building := classroom.Raw.Building.Description
+					if building == "" {
+						building = classroom.BuildingDesc
+					}
+					r = &room{
+						Id:       id,
+						Name:     classroom.ResourceDesc,
+						Building: building,
+						Address:  classroom.AddressDesc,
+						Campus:   classroomCampus(classroom),
+						Seats:    classroom.Raw.Seats,
+					}
+					index[id] = r
+					seen[id] = make(map[string]struct{})
+				}
+
+				if _, ok := seen[id][uid]; ok {
+					continue
+				}
+				seen[id][uid] = struct{}{}
+				r.Lessons = append(r.Lessons, event)
+			}
+		}
+	}
+
+	for _, r := range index {
+		slices.SortFunc(r.Lessons, func(a, b timetable.Event) int {
+			return a.Start.Compare(b.Start.Time)
+		})
+	}
+
+	return index
+}
+
+// getRoomIndex returns the room index built from the timetables that are
+// currently cached. The index is rebuilt when a timetable is refreshed or
+// when it expires from indexCache.
+func getRoomIndex() map[string]*room {
+	if index, found := indexCache.Get(roomIndexKey); found {
+		return index.(map[string]*room)
+	}
+
+	index := buildRoomIndex(warmedTimetables())
+	indexCache.Set(roomIndexKey, index, cache.DefaultExpiration)
+	return index
+}
+
+// isFree reports whether the room has no lessons overlapping [start, end).
+// If it is free, the start of the next lesson is returned too, if any.
+func (r *room) isFree(start, end time.Time) (bool, *time.Time) {
+	for _, lesson := range r.Lessons {
+		if lesson.Start.Before(end) && lesson.End.After(start) {
+			return false, nil
+		}
+		if !lesson.Start.Before(end) {
+			next := lesson.Start.Time
+			return true, &next
+		}
+	}
+	return true, nil
+}
+
+// sortedRooms returns the rooms of the index that are in the given campus (or
+// every room, if campus is empty) and match the query, sorted by campus and name.
+func sortedRooms(index map[string]*room, campus, query string) []*room {
+	queryTokens := normalizeTokens(query)
+
+	rooms := make([]*room, 0)
+	for _, r := range index {
+		if campus != "" && !sameCampus(r.Campus, campus) {
+			continue
+		}
+
+		nameTokens := normalizeTokens(r.Name + " " + r.Building)
+		matches := true
+		for _, q := range queryTokens {
+			if !slices.ContainsFunc(nameTokens, func(n string) bool { return strings.HasPrefix(n, q) }) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			rooms = append(rooms, r)
+		}
+	}
+
+	slices.SortFunc(rooms, func(a, b *room) int {
+		if c := strings.Compare(a.Campus, b.Campus); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	return rooms
+}
+
+// findFreeRooms returns the rooms of the campus with no lessons in [start, end).
+func findFreeRooms(index map[string]*room, campus string, start, end time.Time) []freeRoom {
+	free := make([]freeRoom, 0)
+	for _, r := range sortedRooms(index, campus, "") {
+		ok, until := r.isFree(start, end)
+		if !ok {
+			continue
+		}
+		free = append(free, freeRoom{
+			Id:        r.Id,
+			Name:      r.Name,
+			Building:  r.Building,
+			Address:   r.Address,
+			Campus:    r.Campus,
+			Seats:     r.Seats,
+			Calendar:  "/cal/room/" + r.Id,
+			FreeUntil: until,
+		})
+	}
+	return free
+}
+
+// campuses returns the sorted list of campuses of the indexed rooms.
+func campuses(index map[string]*room) []string {
+	list := make([]string, 0)
+	for _, r := range index {
+		if r.Campus != "" && !slices.Contains(list, r.Campus) {
+			list = append(list, r.Campus)
+		}
+	}
+	slices.Sort(list)
+	return list
+}
+
+// parseTimeWindow parses the start and end query parameters. If start is
+// missing the current time is used, if end is missing the window lasts
+// defaultFreeWindow.
+func parseTimeWindow(startStr, endStr string) (time.Time, time.Time, error) {
+	start := time.Now().In(romeLocation)
+	if startStr != "" {
+		var err error
+		start, err = parseLocalTime(startStr)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid start: %w", err)
+		}
+	}
+
+	end := start.Add(defaultFreeWindow)
+	if endStr != "" {
+		var err error
+		end, err = parseLocalTime(endStr)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid end: %w", err)
+		}
+	}
+
+	if !end.After(start) {
+		return time.Time{}, time.Time{}, fmt.Errorf("end must be after start")
+	}
+
+	return start, end, nil
+}
+
+func createRoomCal(r *room, opts lessonOptions) (*ics.Calendar, error) {
+	cal := ics.NewCalendar()
+	cal.SetMethod(ics.MethodRequest)
+
+	for _, event := range r.Lessons {
+		err := addLessonEvent(cal, event, opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cal.SetName(tr(opts.Lang, "cal.room.name", r.Name))
+	cal.SetDescription(tr(opts.Lang, "cal.room.description", r.Name, r.Address))
+
+	return cal, nil
+}
+
+func roomsPage() func(c *gin.Context) {
+	return func(ctx *gin.Context) {
+		campus := ctx.Query("campus")
+		query := ctx.Query("q")
+		index := getRoomIndex()
+
+		data := gin.H{
+			"campus":   campus,
+			"query":    query,
+			"campuses": campuses(index),
+			"indexed":  len(index),
+			"Lang":     pageLang(ctx),
+		}
+
+		if ctx.Query("free") != "" {
+			start, end, err := parseTimeWindow(ctx.Query("start"), ctx.Query("end"))
+			if err != nil {
+				ctx.String(http.StatusBadRequest, err.Error())
+				return
+			}
+			data["free"] = findFreeRooms(index, campus, start, end)
+			data["start"] = start.Format(localTimeLayout)
+			data["end"] = end.Format(localTimeLayout)
+		} else {
+			rooms := sortedRooms(index, campus, query)
+			data["truncated"] = len(rooms) > maxRoomResults
+			if len(rooms) > maxRoomResults {
+				rooms = rooms[:maxRoomResults]
+			}
+			data["rooms"] = rooms
+		}
+
+		ctx.HTML(http.StatusOK, "rooms", data)
+	}
+}
+
+func getFreeRooms() func(c *gin.Context) {
+	return func(ctx *gin.Context) {
+		campus := ctx.Query("campus")
+		if campus == "" {
+			ctx.String(http.StatusBadRequest, "Missing campus")
+			return
+		}
+
+		start, end, err := parseTimeWindow(ctx.Query("start"), ctx.Query("end"))
+		if err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		ctx.JSON(http.StatusOK, gin.H{
+			"campus": campus,
+			"start":  start,
+			"end":    end,
+			"rooms":  findFreeRooms(getRoomIndex(), campus, start, end),
+		})
+	}
+}
+
+func getRoomCal() func(c *gin.Context) {
+	return func(ctx *gin.Context) {
+		cal, err := roomFeed(ctx)
+		sendCalendar(ctx, cal, err)
+	}
+}
+
+// roomFeed returns the calendar of the room of the ":id" path parameter.
+func roomFeed(q feedParams) (*bytes.Buffer, error) {
+	id := q.Param("id")
+
+	r, found := getRoomIndex()[id]
+	if !found {
+		return nil, &calError{http.StatusNotFound, "Room not found", nil}
+	}
+
+	opts, err := parseLessonOptions(q)
+	if err != nil {
+		return nil, &calError{http.StatusBadRequest, err.Error(), nil}
+	}
+
+	cacheKey := fmt.Sprintf("room-%s-%s", id, opts.key())
+	return cachedCalendar(calcache, cacheKey, func() (*ics.Calendar, error) {
+		return createRoomCal(r, opts)
+	})
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cartabinaria/unibo-go/timetable"
+	"github.com/go-playground/assert/v2"
+)
+
+func Test_findFreeRooms(t *testing.T) {
+	start := time.Date(2024, 10, 1, 9, 0, 0, 0, romeLocation)
+
+	classroom := func(code, name, town string) timetable.Classroom {
+		c := timetable.Classroom{ResourceDesc: name}
+		c.Raw.Building.Code = code
+		c.Raw.Building.Comune = town
+		return c
+	}
+	lesson := func(from, to time.Duration, classrooms ...timetable.Classroom) timetable.Event {
+		return timetable.Event{
+			CodModulo:  "00001",
+			Start:      timetable.CalendarTime{Time: start.Add(from)},
+			End:        timetable.CalendarTime{Time: start.Add(to)},
+			Classrooms: classrooms,
+		}
+	}
+
+	a := classroom("331", "AULA 6.2", "Bologna")
+	b := classroom("331", "AULA 5.7", "Bologna")
+	c := classroom("012", "AULA A", "Forlì")
+	teams := classroom("331", "AULA VIRTUALE TEAMS", "Bologna")
+
+	index := buildRoomIndex([]cachedTimetable{{Timetable: timetable.Timetable{
+		lesson(0, 2*time.Hour, a, b),
+		lesson(3*time.Hour, 4*time.Hour, b, teams),
+		lesson(0, time.Hour, c),
+	}}})
+
+	// The virtual classroom is not a room
+	assert.Equal(t, 3, len(index))
+	assert.Equal(t, 2, len(index["331-aula-5-7"].Lessons))
+
+	free := findFreeRooms(index, "bologna", start.Add(2*time.Hour), start.Add(3*time.Hour))
+	assert.Equal(t, 2, len(free))
+	assert.Equal(t, "AULA 5.7", free[0].Name)
+	assert.Equal(t, start.Add(3*time.Hour), *free[0].FreeUntil)
+	assert.Equal(t, (*time.Time)(nil), free[1].FreeUntil)
+
+	free = findFreeRooms(index, "Bologna", start.Add(time.Hour), start.Add(3*time.Hour))
+	assert.Equal(t, 0, len(free))
+
+	free = findFreeRooms(index, "forli", start.Add(time.Hour), start.Add(2*time.Hour))
+	assert.Equal(t, 1, len(free))
+}
+
+func Test_getRoomIndex(t *testing.T) {
+	defer timetableCache.Flush()
+	defer indexCache.Flush()
+
+	start := time.Date(2024, 10, 1, 9, 0, 0, 0, romeLocation)
+	lesson := func(room string) timetable.Timetable {
+		c := timetable.Classroom{ResourceDesc: room}
+		c.Raw.Building.Code = "331"
+		c.Raw.Building.Comune = "Bologna"
+		return timetable.Timetable{{
+			CodModulo:  "00001",
+			Start:      timetable.CalendarTime{Time: start},
+			End:        timetable.CalendarTime{Time: start.Add(time.Hour)},
+			Classrooms: []timetable.Classroom{c},
+		}}
+	}
+
+	storeTimetable("8009-1-000-000", cachedTimetable{Course: 8009, Year: 1, Timetable: lesson("AULA 6.2")})
+	_, found := getRoomIndex()["331-aula-6-2"]
+	assert.Equal(t, true, found)
+
+	// The lesson moves to another room: the old one is free
+	storeTimetable("8009-1-000-000", cachedTimetable{Course: 8009, Year: 1, Timetable: lesson("AULA 5.7")})
+	index := getRoomIndex()
+	_, found = index["331-aula-6-2"]
+	assert.Equal(t, false, found)
+	_, found = index["331-aula-5-7"]
+	assert.Equal(t, true, found)
+}
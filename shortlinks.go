@@ -0,0 +1,422 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/VaiTon/unibocalendar/unibo_integ"
+)
+
+const (
+	linksBucket = "links"
+
+	// tokenBytes and secretBytes are the random bytes of the link tokens and
+	// of the edit secrets, before encoding
+	tokenBytes  = 6
+	secretBytes = 18
+
+	maxLinkSubjects     = 100
+	maxLinkOptionValues = 50
+	maxLinkOptionLength = 2000
+)
+
+// feedLinks stores the short links. It is nil when the store was not opened,
+// in that case short links are not available.
+var feedLinks *linkStore
+
+var (
+	errLinkNotFound = errors.New("link not found")
+	errWrongSecret  = errors.New("wrong edit secret")
+)
+
+// feedKind is the feed served by a short link.
+type feedKind string
+
+const (
+	feedLessons feedKind = "lessons"
+	feedExams   feedKind = "exams"
+)
+
+// linkOptions are the query parameters of the feeds that can be stored in a
+// short link, besides the curriculum and the subjects.
+var linkOptions = []string{
+	"exams", "period", "start", "end", "attendance", "academic", "registrations",
+	"type", "upcoming", "from", "to", "next",
+	"location", "style", "summary", "description", "alias", "lang",
+}
+
+// feedConfig is the configuration of the feed of a short link.
+type feedConfig struct {
+	Kind       feedKind            `json:"kind"`
+	Course     int                 `json:"course"`
+	Year       int                 `json:"year"`
+	Curriculum string              `json:"curriculum,omitempty"`
+	Subjects   []string            `json:"subjects,omitempty"`
+	Options    map[string][]string `json:"options,omitempty"`
+}
+
+// validate checks the configuration and normalizes it. The values of the
+// options are checked when the feed is served, as for the long URLs.
+func (c *feedConfig) validate(courses *unibo_integ.CoursesMap) error {
+	if c.Kind == "" {
+		c.Kind = feedLessons
+	}
+	if c.Kind != feedLessons && c.Kind != feedExams {
+		return fmt.Errorf("invalid kind %q", c.Kind)
+	}
+
+	course, found := courses.FindById(c.Course)
+	if !found {
+		return fmt.Errorf("course %d not found", c.Course)
+	}
+	if c.Year <= 0 || c.Year > course.DurataAnni {
+		return fmt.Errorf("invalid year %d", c.Year)
+	}
+
+	if len(c.Subjects) > maxLinkSubjects {
+		return fmt.Errorf("too many subjects (max %d)", maxLinkSubjects)
+	}
+	subjects := make([]string, 0, len(c.Subjects))
+	for _, s := range c.Subjects {
+		s = strings.TrimSpace(s)
+		if s == "" || strings.Contains(s, ",") {
+			return fmt.Errorf("invalid subject %q", s)
+		}
+		if !slices.Contains(subjects, s) {
+			subjects = append(subjects, s)
+		}
+	}
+	slices.Sort(subjects)
+	c.Subjects = subjects
+
+	length := 0
+	for name, values := range c.Options {
+		if !slices.Contains(linkOptions, name) {
+			return fmt.Errorf("invalid option %q", name)
+		}
+		if len(values) == 0 || len(values) > maxLinkOptionValues {
+			return fmt.Errorf("invalid values of option %q", name)
+		}
+		for _, v := range values {
+			length += len(v)
+		}
+	}
+	if length > maxLinkOptionLength {
+		return fmt.Errorf("options are too long (max %d characters)", maxLinkOptionLength)
+	}
+
+	return nil
+}
+
+// query returns the query parameters of the feed.
+func (c feedConfig) query() url.Values {
+	q := make(url.Values, len(c.Options)+2)
+	for name, values := range c.Options {
+		q[name] = values
+	}
+	if c.Curriculum != "" {
+		q.Set("curr", c.Curriculum)
+	}
+	if len(c.Subjects) != 0 {
+		q.Set("subjects", strings.Join(c.Subjects, ","))
+	}
+	return q
+}
+
+// feedLink is a short link, as stored in the database.
+type feedLink struct {
+	Token string     `json:"token"`
+	Feed  feedConfig `json:"feed"`
+	// SecretHash is the SHA-256 of the edit secret, that is never stored
+	SecretHash string    `json:"secret_hash"`
+	Created    time.Time `json:"created"`
+	Updated    time.Time `json:"updated"`
+}
+
+func (l feedLink) url() string {
+	return "/s/" + l.Token
+}
+
+// editUrl returns the page where the link can be edited: the course page,
+// with the link as parameter and the secret in the fragment, so that it is
+// never sent to the server with the page or in the Referer header.
+func (l feedLink) editUrl(secret string) string {
+	q := url.Values{"link": {l.Token}}
+	f := url.Values{"secret": {secret}}
+	return fmt.Sprintf("/courses/%d?%s#%s", l.Feed.Course, q.Encode(), f.Encode())
+}
+
+func (l feedLink) checkSecret(secret string) bool {
+	hash := hashSecret(secret)
+	return subtle.ConstantTimeCompare([]byte(hash), []byte(l.SecretHash)) == 1
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// linkStore is the embedded database of the short links.
+type linkStore struct {
+	db *bolt.DB
+}
+
+// create stores a new link for the feed and returns it with its edit secret.
+func (s *linkStore) create(feed feedConfig) (feedLink, string, error) {
+	secret, err := randomString(secretBytes)
+	if err != nil {
+		return feedLink{}, "", err
+	}
+
+	now := time.Now()
+	link := feedLink{Feed: feed, SecretHash: hashSecret(secret), Created: now, Updated: now}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(linksBucket))
+
+		// Tokens are random, a collision is very unlikely but possible
+		for {
+			token, err := randomString(tokenBytes)
+			if err != nil {
+				return err
+			}
+			if b.Get([]byte(token)) == nil {
+				link.Token = token
+				break
+			}
+		}
+
+		return putLink(b, link)
+	})
+	if err != nil {
+		return feedLink{}, "", err
+	}
+
+	return link, secret, nil
+}
+
+func (s *linkStore) get(token string) (feedLink, error) {
+	var link feedLink
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte(linksBucket)).Get([]byte(token))
+		if data == nil {
+			return errLinkNotFound
+		}
+		return json.Unmarshal(data, &link)
+	})
+	return link, err
+}
+
+// update replaces the feed of a link, if the secret is right. The token and
+// so the subscribed URL don't change.
+func (s *linkStore) update(token, secret string, feed feedConfig) (feedLink, error) {
+	var link feedLink
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(linksBucket))
+		data := b.Get([]byte(token))
+		if data == nil {
+			return errLinkNotFound
+		}
+
+		err := json.Unmarshal(data, &link)
+		if err != nil {
+			return err
+		}
+		if !link.checkSecret(secret) {
+			return errWrongSecret
+		}
+
+		link.Feed = feed
+		link.Updated = time.Now()
+		return putLink(b, link)
+	})
+	return link, err
+}
+
+func putLink(b *bolt.Bucket, link feedLink) error {
+	data, err := json.Marshal(link)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(link.Token), data)
+}
+
+// linkResponse is the response of the short links API. EditUrl is only sent
+// to who knows the secret.
+type linkResponse struct {
+	Token   string     `json:"token"`
+	Url     string     `json:"url"`
+	EditUrl string     `json:"edit_url,omitempty"`
+	Feed    feedConfig `json:"feed"`
+	Updated time.Time  `json:"updated"`
+}
+
+func newLinkResponse(link feedLink, secret string) linkResponse {
+	r := linkResponse{Token: link.Token, Url: link.url(), Feed: link.Feed, Updated: link.Updated}
+	if secret != "" {
+		r.EditUrl = link.editUrl(secret)
+	}
+	return r
+}
+
+// bindFeedConfig reads and validates the feed configuration in the body of
+// the request. If it is not valid, an error response is sent and false is returned.
+func bindFeedConfig(ctx *gin.Context, courses *unibo_integ.CoursesMap) (feedConfig, bool) {
+	var feed feedConfig
+	err := json.NewDecoder(ctx.Request.Body).Decode(&feed)
+	if err != nil {
+		ctx.String(http.StatusBadRequest, "Invalid feed configuration")
+		return feedConfig{}, false
+	}
+
+	err = feed.validate(courses)
+	if err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return feedConfig{}, false
+	}
+	return feed, true
+}
+
+// linksAvailable sends an error response and returns false if the links
+// store was not opened.
+func linksAvailable(ctx *gin.Context) bool {
+	if feedLinks == nil {
+		ctx.String(http.StatusServiceUnavailable, "Short links are not available")
+		return false
+	}
+	return true
+}
+
+func createLink(courses *unibo_integ.CoursesMap) func(c *gin.Context) {
+	return func(ctx *gin.Context) {
+		if !linksAvailable(ctx) {
+			return
+		}
+
+		feed, ok := bindFeedConfig(ctx, courses)
+		if !ok {
+			return
+		}
+
+		link, secret, err := feedLinks.create(feed)
+		if err != nil {
+			_ = ctx.Error(err)
+			ctx.String(http.StatusInternalServerError, "Unable to create link")
+			return
+		}
+
+		ctx.JSON(http.StatusCreated, newLinkResponse(link, secret))
+	}
+}
+
+func getLink() func(c *gin.Context) {
+	return func(ctx *gin.Context) {
+		if !linksAvailable(ctx) {
+			return
+		}
+
+		link, err := feedLinks.get(ctx.Param("token"))
+		if errors.Is(err, errLinkNotFound) {
+			ctx.String(http.StatusNotFound, "Link not found")
+			return
+		} else if err != nil {
+			_ = ctx.Error(err)
+			ctx.String(http.StatusInternalServerError, "Unable to get link")
+			return
+		}
+
+		ctx.JSON(http.StatusOK, newLinkResponse(link, ""))
+	}
+}
+
+// updateLink changes the feed of a link. The edit secret is given in the
+// "X-Edit-Secret" header.
+func updateLink(courses *unibo_integ.CoursesMap) func(c *gin.Context) {
+	return func(ctx *gin.Context) {
+		if !linksAvailable(ctx) {
+			return
+		}
+
+		secret := ctx.GetHeader("X-Edit-Secret")
+
+		feed, ok := bindFeedConfig(ctx, courses)
+		if !ok {
+			return
+		}
+
+		link, err := feedLinks.update(ctx.Param("token"), secret, feed)
+		if errors.Is(err, errLinkNotFound) {
+			ctx.String(http.StatusNotFound, "Link not found")
+			return
+		} else if errors.Is(err, errWrongSecret) {
+			ctx.String(http.StatusForbidden, "Wrong edit secret")
+			return
+		} else if err != nil {
+			_ = ctx.Error(err)
+			ctx.String(http.StatusInternalServerError, "Unable to update link")
+			return
+		}
+
+		ctx.JSON(http.StatusOK, newLinkResponse(link, secret))
+	}
+}
+
+// getLinkCal serves the feed of a short link, as if its long URL was requested.
+func getLinkCal(courses *unibo_integ.CoursesMap) func(c *gin.Context) {
+	return func(ctx *gin.Context) {
+		cal, err := linkFeed(ctx, courses)
+		sendCalendar(ctx, cal, err)
+	}
+}
+
+// linkFeed returns the calendar of the short link of the ":token" path
+// parameter.
+func linkFeed(q feedParams, courses *unibo_integ.CoursesMap) (*bytes.Buffer, error) {
+	if feedLinks == nil {
+		return nil, &calError{http.StatusServiceUnavailable, "Short links are not available", nil}
+	}
+
+	link, err := feedLinks.get(q.Param("token"))
+	if errors.Is(err, errLinkNotFound) {
+		return nil, &calError{http.StatusNotFound, "Link not found", nil}
+	} else if err != nil {
+		return nil, &calError{http.StatusInternalServerError, "Unable to get link", err}
+	}
+
+	feed := feedUrlParams{
+		params: map[string]string{
+			"id":   strconv.Itoa(link.Feed.Course),
+			"anno": strconv.Itoa(link.Feed.Year),
+		},
+		query: link.Feed.query(),
+	}
+	if link.Feed.Kind == feedExams {
+		return examsFeed(feed, courses)
+	}
+	return lessonsFeed(feed, courses)
+}
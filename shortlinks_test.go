@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+
+	"github.com/VaiTon/unibocalendar/unibo_integ"
+)
+
+func Test_feedConfig_validate(t *testing.T) {
+	courses := unibo_integ.CoursesMap{8009: {Codice: 8009, DurataAnni: 3}}
+
+	tests := []struct {
+		name    string
+		feed    feedConfig
+		wantErr bool
+	}{
+		{"default kind", feedConfig{Course: 8009, Year: 1}, false},
+		{"exams", feedConfig{Kind: feedExams, Course: 8009, Year: 3}, false},
+		{"invalid kind", feedConfig{Kind: "rooms", Course: 8009, Year: 1}, true},
+		{"unknown course", feedConfig{Course: 1, Year: 1}, true},
+		{"invalid year", feedConfig{Course: 8009, Year: 4}, true},
+		{"invalid subject", feedConfig{Course: 8009, Year: 1, Subjects: []string{"a,b"}}, true},
+		{"options", feedConfig{Course: 8009, Year: 1, Options: map[string][]string{"exams": {"true"}, "alias": {"1:A", "2:B"}}}, false},
+		{"unknown option", feedConfig{Course: 8009, Year: 1, Options: map[string][]string{"curr": {"A"}}}, true},
+		{"empty option", feedConfig{Course: 8009, Year: 1, Options: map[string][]string{"exams": {}}}, true},
+		{"long option", feedConfig{Course: 8009, Year: 1, Options: map[string][]string{"summary": {strings.Repeat("x", maxLinkOptionLength+1)}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.feed.validate(&courses)
+			assert.Equal(t, tt.wantErr, err != nil)
+		})
+	}
+}
+
+func Test_feedConfig_query(t *testing.T) {
+	courses := unibo_integ.CoursesMap{8009: {Codice: 8009, DurataAnni: 3}}
+	feed := feedConfig{
+		Course:     8009,
+		Year:       2,
+		Curriculum: "A58-000",
+		Subjects:   []string{" 2", "1", "2"},
+		Options:    map[string][]string{"exams": {"true"}},
+	}
+
+	assert.Equal(t, nil, feed.validate(&courses))
+	assert.Equal(t, feedLessons, feed.Kind)
+	assert.Equal(t, "curr=A58-000&exams=true&subjects=1%2C2", feed.query().Encode())
+}
+
+func Test_linkStore(t *testing.T) {
+	store := &linkStore{db: testDatabase(t)}
+
+	link, secret, err := store.create(feedConfig{Kind: feedLessons, Course: 8009, Year: 1})
+	assert.Equal(t, nil, err)
+	assert.NotEqual(t, "", link.Token)
+	assert.NotEqual(t, secret, link.SecretHash)
+
+	got, err := store.get(link.Token)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 8009, got.Feed.Course)
+
+	_, err = store.get("missing")
+	assert.Equal(t, errLinkNotFound, err)
+
+	_, err = store.update(link.Token, "wrong", feedConfig{Kind: feedLessons, Course: 8009, Year: 2})
+	assert.Equal(t, errWrongSecret, err)
+
+	updated, err := store.update(link.Token, secret, feedConfig{Kind: feedLessons, Course: 8009, Year: 2})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, link.Token, updated.Token)
+	assert.Equal(t, link.Created.Unix(), updated.Created.Unix())
+
+	got, err = store.get(link.Token)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, got.Feed.Year)
+}
+
+func Test_linksApi(t *testing.T) {
+	store := &linkStore{db: testDatabase(t)}
+
+	feedLinks = store
+	defer func() { feedLinks = nil }()
+
+	r := setupRouter(unibo_integ.CoursesMap{8009: {Codice: 8009, DurataAnni: 3}})
+	request := func(method, url, body string, header http.Header) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request(http.MethodPost, "/api/links", `{"course": 8009, "year": 4}`, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = request(http.MethodPost, "/api/links", `{"course": 8009, "year": 1, "subjects": ["2", "1"]}`, nil)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var created linkResponse
+	assert.Equal(t, nil, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.Equal(t, "/s/"+created.Token, created.Url)
+	assert.Equal(t, []string{"1", "2"}, created.Feed.Subjects)
+	assert.Equal(t, true, strings.HasPrefix(created.EditUrl, "/courses/8009?link="+created.Token+"#secret="))
+
+	secret := created.EditUrl[strings.Index(created.EditUrl, "#secret=")+len("#secret="):]
+	body := `{"course": 8009, "year": 2, "subjects": ["3"]}`
+
+	w = request(http.MethodPut, "/api/links/"+created.Token, body, http.Header{"X-Edit-Secret": {"wrong"}})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// The secret is accepted only in the header
+	w = request(http.MethodPut, "/api/links/"+created.Token+"?secret="+secret, body, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = request(http.MethodPut, "/api/links/"+created.Token, body, http.Header{"X-Edit-Secret": {secret}})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = request(http.MethodGet, "/api/links/"+created.Token, "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var got linkResponse
+	assert.Equal(t, nil, json.Unmarshal(w.Body.Bytes(), &got))
+	assert.Equal(t, 2, got.Feed.Year)
+	assert.Equal(t, []string{"3"}, got.Feed.Subjects)
+	assert.Equal(t, "", got.EditUrl)
+
+	w = request(http.MethodGet, "/s/missing", "", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
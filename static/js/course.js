@@ -2,13 +2,48 @@ document.addEventListener("DOMContentLoaded", function () {
   const elements = document.getElementsByClassName("cal");
   const url = new URL(document.baseURI);
 
-  const openPrefix = "https://simonrob.github.io/online-ics-feed-viewer/#";
   const googlePrefix = "https://www.google.com/calendar/render?cid=";
   const applePrefix = "";
 
+  // Calendars are in the language of the page. The language is always in the
+  // URL, since the feeds don't depend on the browser of who subscribes.
+  const lang = document.documentElement.lang;
+  function withLang(path) {
+    if (/[?&]lang=/.test(path)) {
+      return path;
+    }
+    return path + (path.includes("?") ? "&" : "?") + "lang=" + lang;
+  }
+
+  // Path and query of the feed of a webcal link
+  function feedPath(webcalLink) {
+    const feed = new URL(webcalLink.replace("webcal://", "https://"));
+    return feed.pathname + feed.search;
+  }
+
+  // The preview page shows the events of the feed
+  function previewUrl(webcalLink) {
+    return "/preview?" + new URLSearchParams({ url: feedPath(webcalLink) });
+  }
+
+  // QR codes are generated by the server from the path of the feed
+  function qrUrl(webcalLink, format) {
+    return "/qr?" + new URLSearchParams({ format, url: feedPath(webcalLink) });
+  }
+
+  function updateQr(el, webcalLink) {
+    if (el.classList.contains("qr")) {
+      el.src = qrUrl(webcalLink, "svg");
+    } else if (el.classList.contains("qr-png")) {
+      el.href = qrUrl(webcalLink, "png");
+    } else if (el.classList.contains("qr-svg")) {
+      el.href = qrUrl(webcalLink, "svg");
+    }
+  }
+
   for (const el of elements) {
     const pre = el.getElementsByTagName("pre")[0];
-    const calPath = pre.textContent;
+    const calPath = withLang(pre.textContent);
 
     const webcalLink = `webcal://${url.host}${calPath}`;
 
@@ -28,20 +63,17 @@ document.addEventListener("DOMContentLoaded", function () {
     });
 
     const aOpen = el.getElementsByClassName("open")[0];
-    aOpen.href =
-      openPrefix +
-      new URLSearchParams({
-        feed: `${url.origin}${calPath}`,
-        cors: false,
-        title: "Lezioni",
-        hideinput: true,
-      });
+    aOpen.href = previewUrl(webcalLink);
 
     const addToGoogleBtn = el.getElementsByClassName("google")[0];
     addToGoogleBtn.href = googlePrefix + encodeURIComponent(webcalLink);
 
     const addToAppleBtn = el.getElementsByClassName("apple")[0];
     addToAppleBtn.href = webcalLink;
+
+    for (const qr of el.querySelectorAll(".qr, .qr-png, .qr-svg")) {
+      updateQr(qr, webcalLink);
+    }
   }
 
   // Utility functions to work with URL params;
@@ -71,6 +103,55 @@ document.addEventListener("DOMContentLoaded", function () {
     return url;
   }
 
+  // Sets the period parameter, keeping the subjects parameter as the last one
+  function setPeriod(url, period) {
+    url = url.replace(/([?&])period=[^&]*(&|$)/, (m, pre, post) => (post ? pre : ""));
+    if (!period) {
+      return url;
+    }
+    if (url.includes("?")) {
+      return url.replace("?", `?period=${period}&`);
+    }
+    return `${url}?period=${period}`;
+  }
+
+  // Updates the link and the buttons of a block, applying transform to its URL
+  function updateLinks(mode, a, c, transform) {
+    let class_name = `${mode}${a}_${c}`;
+    let els = document.getElementsByClassName(class_name);
+    let res = transform(document.getElementById(class_name).textContent);
+
+    for (const el of els) {
+      if (el.nodeName == "IMG") {
+        updateQr(el, res);
+      } else if (el.nodeName != "A") {
+        el.textContent = res;
+      } else {
+        if (el.classList.contains("open")) {
+          el.href = previewUrl(res);
+        } else if (el.classList.contains("google")) {
+          el.href = googlePrefix + encodeURIComponent(res);
+        } else if (el.classList.contains("apple")) {
+          el.href = res;
+        } else {
+          updateQr(el, res);
+        }
+      }
+    }
+  }
+
+  // The period applies only to the lessons, exams are not split by period
+  for (const select of document.getElementsByClassName("period-select")) {
+    select.value = "";
+    select.addEventListener("change", () => {
+      let a = select.getAttribute("data-anno");
+      let c = select.getAttribute("data-curriculum");
+      ["l", "c"].forEach((mode) => {
+        updateLinks(mode, a, c, (plain_string) => setPeriod(plain_string, select.value));
+      });
+    });
+  }
+
   // Use only the new filter-checkboxes with data-* attributes
   const checkboxes = document.getElementsByClassName
 ("filter-checkbox");
@@ -83,39 +164,11 @@ document.addEventListener("DOMContentLoaded", function () {
     let innerText = ck.getAttribute("data-innertext");
 
     ck.addEventListener("click", (event) => {
-      // Update both Lezioni and Esami blocks for this anno/curriculum/option
-      ["l", "e"].forEach((mode) => {
-        let class_name = `${mode}${a}_${c}`;
-        let els = document.getElementsByClassName(class_name);
-        let plain_string = document.getElementById(class_name).textContent;
-
-        for (const el of els) {
-          let res;
-          if (ck.checked) {
-            res = addSubject(plain_string, o);
-          } else {
-            res = removeSubject(plain_string, o);
-          }
-
-          if (el.nodeName != "A") {
-            el.textContent = res;
-          } else {
-            if (el.classList.contains("open")) {
-              el.href =
-                openPrefix +
-                new URLSearchParams({
-                  feed: res.replace("webcal://", "https://"),
-                  cors: false,
-                  title: mode === "l" ? "Lezioni" : "Esami",
-                  hideinput: true,
-                });
-            } else if (el.classList.contains("google")) {
-              el.href = googlePrefix + encodeURIComponent(res);
-            } else if (el.classList.contains("apple")) {
-              el.href = res;
-            }
-          }
-        }
+      // Update the Lezioni, Esami and combined blocks for this anno/curriculum/option
+      ["l", "e", "c"].forEach((mode) => {
+        updateLinks(mode, a, c, (plain_string) =>
+          ck.checked ? addSubject(plain_string, o) : removeSubject(plain_string, o),
+        );
       });
 
       // Update the badges only once per block (above Lezioni)
@@ -140,4 +193,262 @@ document.addEventListener("DOMContentLoaded", function () {
       }
     });
   }
+
+  // Personal timetable: selections from any course are kept in localStorage
+  // and merged by the /cal/custom endpoint.
+  const personalKey = "personalSelections";
+  const personal = document.getElementById("personal");
+  const personalList = document.getElementById("personal-selections");
+  const personalUrl = document.getElementById("personal-url");
+
+  function loadSelections() {
+    try {
+      return JSON.parse(localStorage.getItem(personalKey)) || [];
+    } catch {
+      return [];
+    }
+  }
+
+  function saveSelections(selections) {
+    localStorage.setItem(personalKey, JSON.stringify(selections));
+    renderPersonal();
+  }
+
+  function renderPersonal() {
+    const selections = loadSelections();
+    personal.classList.toggle("hidden", selections.length === 0);
+
+    personalList.innerHTML = "";
+    for (const s of selections) {
+      const li = document.createElement("li");
+      li.className = "flex items-center gap-2 text-sm";
+
+      const remove = document.createElement("button");
+      remove.className = "btn btn-xs btn-ghost";
+      remove.title = messages.remove;
+      remove.textContent = "✕";
+      remove.addEventListener("click", () => {
+        saveSelections(loadSelections().filter((o) => o.key !== s.key));
+      });
+
+      const label = document.createElement("span");
+      label.textContent = s.subjects.length
+        ? `${s.label} (${messages.subjects_count.replace("%d", s.subjects.length)})`
+        : `${s.label} (${messages.all_subjects})`;
+
+      li.append(remove, label);
+      personalList.append(li);
+    }
+
+    const params = new URLSearchParams();
+    for (const s of selections) {
+      params.append("sel", `${s.key}:${s.subjects.join(",")}`);
+    }
+    renderConflicts(selections.length ? params.toString() : "");
+
+    if (personalMark.checked) {
+      params.append("conflicts", "mark");
+    }
+    params.append("lang", lang);
+    const webcalLink = `webcal://${url.host}/cal/custom?${params}`;
+
+    personalUrl.textContent = webcalLink;
+    document.getElementById("personal-google").href =
+      googlePrefix + encodeURIComponent(webcalLink);
+    document.getElementById("personal-apple").href = webcalLink;
+  }
+
+  const personalMark = document.getElementById("personal-mark");
+  personalMark.addEventListener("change", () => renderPersonal());
+
+  const conflictsBox = document.getElementById("personal-conflicts");
+  const conflictsList = document.getElementById("personal-conflicts-list");
+  const dateFormat = new Intl.DateTimeFormat(messages.locale, {
+    dateStyle: "short",
+    timeStyle: "short",
+  });
+
+  // Shows the overlapping lessons of the selected subjects
+  async function renderConflicts(query) {
+    conflictsBox.classList.add("hidden");
+    conflictsList.innerHTML = "";
+    if (!query) {
+      return;
+    }
+
+    let res;
+    try {
+      res = await fetch(`/api/conflicts?${query}`);
+    } catch {
+      return;
+    }
+    if (!res.ok) {
+      return;
+    }
+
+    const data = await res.json();
+    if (data.count === 0) {
+      return;
+    }
+
+    document.getElementById("personal-conflicts-title").textContent =
+      messages.conflicts_found.replace("%d", data.count);
+    for (const c of data.conflicts) {
+      const li = document.createElement("li");
+      const rooms = [...c.first.rooms, ...c.second.rooms].join(", ");
+      li.textContent =
+        `${dateFormat.format(new Date(c.start))}: ${c.first.title} / ${c.second.title}` +
+        ` (${c.minutes} min${rooms ? `, ${rooms}` : ""})`;
+      conflictsList.append(li);
+    }
+    conflictsBox.classList.remove("hidden");
+  }
+
+  document.getElementById("personal-copy").addEventListener("click", () => {
+    navigator.clipboard.writeText(personalUrl.textContent);
+  });
+
+  for (const btn of document.getElementsByClassName("add-personal")) {
+    btn.addEventListener("click", () => {
+      const course = btn.getAttribute("data-course");
+      const a = btn.getAttribute("data-anno");
+      const c = btn.getAttribute("data-curriculum");
+
+      const subjects = [];
+      for (const ck of checkboxes) {
+        if (
+          ck.getAttribute("data-anno") === a &&
+          ck.getAttribute("data-curriculum") === c &&
+          ck.checked
+        ) {
+          subjects.push(ck.getAttribute("data-option"));
+        }
+      }
+
+      // A course year and curriculum is selected at most once
+      const key = `${course}:${a}:${c}`;
+      const selections = loadSelections().filter((o) => o.key !== key);
+      selections.push({ key, subjects, label: btn.getAttribute("data-label") });
+      saveSelections(selections);
+    });
+  }
+
+  renderPersonal();
+
+  // Short links: the selection of a block is stored by the server, so it can
+  // be changed later with the edit link without changing the subscribed URL.
+  // The edit secret is in the fragment, so it never reaches the server
+  // except in the X-Edit-Secret header.
+  const pageParams = new URLSearchParams(window.location.search);
+  const fragmentParams = new URLSearchParams(window.location.hash.slice(1));
+  let editing = null;
+  if (pageParams.get("link") && fragmentParams.get("secret")) {
+    editing = { token: pageParams.get("link"), secret: fragmentParams.get("secret") };
+  }
+
+  function blockElements(className, a, c) {
+    return [...document.getElementsByClassName(className)].filter(
+      (el) => el.getAttribute("data-anno") === a && el.getAttribute("data-curriculum") === c,
+    );
+  }
+
+  function showShortLink(a, c, title, link) {
+    const result = blockElements("short-link-result", a, c)[0];
+    result.getElementsByClassName("short-link-title")[0].textContent = title;
+    result.classList.remove("hidden");
+    if (!link) {
+      return;
+    }
+
+    result.getElementsByClassName("short-link-url")[0].textContent =
+      `webcal://${url.host}${link.url}`;
+    const edit = result.getElementsByClassName("short-link-edit")[0];
+    if (link.edit_url) {
+      const anchor = edit.getElementsByTagName("a")[0];
+      anchor.href = link.edit_url;
+      anchor.textContent = `${url.origin}${link.edit_url}`;
+      edit.classList.remove("hidden");
+    }
+  }
+
+  for (const btn of document.getElementsByClassName("short-link")) {
+    btn.addEventListener("click", async () => {
+      const a = btn.getAttribute("data-anno");
+      const c = btn.getAttribute("data-curriculum");
+
+      const feed = {
+        kind: "lessons",
+        course: Number(btn.getAttribute("data-course")),
+        year: Number(a),
+        curriculum: c,
+        subjects: blockElements("filter-checkbox", a, c)
+          .filter((ck) => ck.checked)
+          .map((ck) => ck.getAttribute("data-option")),
+        options: {},
+      };
+      const period = blockElements("period-select", a, c)[0];
+      if (period && period.value) {
+        feed.options.period = [period.value];
+      }
+      feed.options.lang = [lang];
+
+      const update = editing && editing.block === `${a}_${c}`;
+      let res;
+      try {
+        res = await fetch(update ? `/api/links/${editing.token}` : "/api/links", {
+          method: update ? "PUT" : "POST",
+          headers: update
+            ? { "Content-Type": "application/json", "X-Edit-Secret": editing.secret }
+            : { "Content-Type": "application/json" },
+          body: JSON.stringify(feed),
+        });
+      } catch {
+        showShortLink(a, c, messages.short_link_error);
+        return;
+      }
+      if (!res.ok) {
+        showShortLink(a, c, messages.short_link_error);
+        return;
+      }
+
+      const link = await res.json();
+      showShortLink(a, c, update ? messages.short_link_updated : messages.short_link_created, link);
+    });
+  }
+
+  // With an edit link, the selection of the short link is restored in its block
+  async function loadEditing() {
+    let res;
+    try {
+      res = await fetch(`/api/links/${editing.token}`);
+    } catch {
+      return;
+    }
+    if (!res.ok) {
+      return;
+    }
+
+    const link = await res.json();
+    const a = String(link.feed.year);
+    const c = link.feed.curriculum || "";
+    editing.block = `${a}_${c}`;
+
+    for (const ck of blockElements("filter-checkbox", a, c)) {
+      if ((link.feed.subjects || []).includes(ck.getAttribute("data-option"))) {
+        ck.click();
+      }
+    }
+    const period = blockElements("period-select", a, c)[0];
+    if (period && link.feed.options && link.feed.options.period) {
+      period.value = link.feed.options.period[0];
+      period.dispatchEvent(new Event("change"));
+    }
+
+    showShortLink(a, c, messages.short_link_editing, link);
+    blockElements("short-link-result", a, c)[0].scrollIntoView({ block: "center" });
+  }
+
+  if (editing) {
+    loadEditing();
+  }
 });
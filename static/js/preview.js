@@ -0,0 +1,312 @@
+document.addEventListener("DOMContentLoaded", function () {
+  const preview = document.getElementById("preview");
+  const container = document.getElementById("preview-events");
+  const weekNav = document.getElementById("week-nav");
+  const weekRange = document.getElementById("week-range");
+  const feed = preview.getAttribute("data-feed");
+
+  const dayFormat = new Intl.DateTimeFormat(messages.locale, {
+    weekday: "long",
+    day: "numeric",
+    month: "long",
+  });
+  const shortDayFormat = new Intl.DateTimeFormat(messages.locale, {
+    weekday: "short",
+    day: "2-digit",
+    month: "2-digit",
+  });
+  const timeFormat = new Intl.DateTimeFormat(messages.locale, { timeStyle: "short" });
+  const dateFormat = new Intl.DateTimeFormat(messages.locale, { dateStyle: "short" });
+
+  // Hours always shown by the week view, extended if an event is outside them
+  const firstHour = 8;
+  const lastHour = 19;
+
+  let events = [];
+  let view = "agenda";
+  let week = startOfWeek(new Date());
+
+  function startOfDay(d) {
+    return new Date(d.getFullYear(), d.getMonth(), d.getDate());
+  }
+
+  function addDays(d, days) {
+    return new Date(d.getFullYear(), d.getMonth(), d.getDate() + days);
+  }
+
+  function startOfWeek(d) {
+    return addDays(startOfDay(d), -((d.getDay() + 6) % 7));
+  }
+
+  function timeRange(e) {
+    if (e.all_day) {
+      return messages.all_day;
+    }
+    return `${timeFormat.format(e.start)} - ${timeFormat.format(e.end)}`;
+  }
+
+  function showMessage(text) {
+    container.innerHTML = "";
+    const p = document.createElement("p");
+    p.className = "text-sm";
+    p.textContent = text;
+    container.append(p);
+  }
+
+  // Agenda: the upcoming events grouped by day
+  function renderAgenda() {
+    const today = startOfDay(new Date());
+    const upcoming = events.filter((e) => e.end > today);
+    if (upcoming.length === 0) {
+      showMessage(messages.preview_empty);
+      return;
+    }
+
+    container.innerHTML = "";
+    let day = null;
+    let list = null;
+    for (const e of upcoming) {
+      const eventDay = startOfDay(e.start < today ? today : e.start);
+      if (!day || eventDay.getTime() !== day.getTime()) {
+        day = eventDay;
+        const h = document.createElement("h2");
+        h.className = "text-secondary font-semibold mt-4 mb-2 first-letter:uppercase";
+        h.textContent = dayFormat.format(day);
+        list = document.createElement("ul");
+        list.className = "flex flex-col gap-2";
+        container.append(h, list);
+      }
+
+      const li = document.createElement("li");
+      li.className = "card bg-base-300 rounded-xl p-3 text-sm";
+      li.title = e.description || "";
+
+      const time = document.createElement("div");
+      time.className = "text-base-content/70";
+      time.textContent = timeRange(e);
+
+      const summary = document.createElement("div");
+      summary.className = "font-semibold";
+      summary.textContent = e.summary;
+
+      li.append(time, summary);
+      if (e.location) {
+        const location = document.createElement("div");
+        location.textContent = e.location;
+        li.append(location);
+      }
+      list.append(li);
+    }
+  }
+
+  // Places overlapping events side by side, as the printable week grid does
+  function assignLanes(dayEvents) {
+    for (let i = 0; i < dayEvents.length; ) {
+      let end = dayEvents[i].end;
+      let j = i + 1;
+      while (j < dayEvents.length && dayEvents[j].start < end) {
+        if (dayEvents[j].end > end) {
+          end = dayEvents[j].end;
+        }
+        j++;
+      }
+
+      const laneEnds = [];
+      for (let k = i; k < j; k++) {
+        let lane = laneEnds.findIndex((laneEnd) => laneEnd <= dayEvents[k].start);
+        if (lane === -1) {
+          lane = laneEnds.length;
+          laneEnds.push(null);
+        }
+        laneEnds[lane] = dayEvents[k].end;
+        dayEvents[k].lane = lane;
+      }
+      for (let k = i; k < j; k++) {
+        dayEvents[k].lanes = laneEnds.length;
+      }
+      i = j;
+    }
+  }
+
+  // Week: one column for every day, Monday to Friday and the weekend if it
+  // has events
+  function renderWeek() {
+    const end = addDays(week, 7);
+    const days = [...Array(7).keys()].map((i) => ({ date: addDays(week, i), allDay: [], timed: [] }));
+
+    let from = firstHour;
+    let to = lastHour;
+    for (const e of events) {
+      if (e.end <= week || e.start >= end) {
+        continue;
+      }
+      if (e.all_day) {
+        for (const d of days) {
+          if (e.start < addDays(d.date, 1) && e.end > d.date) {
+            d.allDay.push(e);
+          }
+        }
+        continue;
+      }
+
+      const d = days[Math.floor((startOfDay(e.start) - week) / 86400000 + 0.5)];
+      if (!d) {
+        continue;
+      }
+      d.timed.push({ ...e });
+      from = Math.min(from, e.start.getHours());
+      const sameDay = startOfDay(e.end).getTime() === d.date.getTime();
+      to = Math.max(to, sameDay ? e.end.getHours() + (e.end.getMinutes() ? 1 : 0) : 24);
+    }
+
+    let shown = 5;
+    if (days[6].timed.length || days[6].allDay.length) {
+      shown = 7;
+    } else if (days[5].timed.length || days[5].allDay.length) {
+      shown = 6;
+    }
+
+    weekRange.textContent = `${dateFormat.format(week)} - ${dateFormat.format(days[shown - 1].date)}`;
+    container.innerHTML = "";
+
+    const scroll = document.createElement("div");
+    scroll.className = "overflow-x-auto w-full";
+    const grid = document.createElement("div");
+    grid.className = "flex min-w-[48rem] text-xs";
+    scroll.append(grid);
+
+    const hours = document.createElement("div");
+    hours.className = "w-12 shrink-0";
+    const corner = document.createElement("div");
+    corner.className = "h-14";
+    hours.append(corner);
+    for (let h = from; h < to; h++) {
+      const row = document.createElement("div");
+      row.className = "h-16 border-t border-base-content/10 pr-1 text-right";
+      row.textContent = `${String(h).padStart(2, "0")}:00`;
+      hours.append(row);
+    }
+    grid.append(hours);
+
+    const minutes = (to - from) * 60;
+    for (const d of days.slice(0, shown)) {
+      const column = document.createElement("div");
+      column.className = "flex-1 min-w-0 border-l border-base-content/10";
+
+      const header = document.createElement("div");
+      header.className = "h-14 text-center overflow-hidden";
+      const name = document.createElement("div");
+      name.className = "font-semibold first-letter:uppercase";
+      name.textContent = shortDayFormat.format(d.date);
+      header.append(name);
+      for (const e of d.allDay) {
+        const badge = document.createElement("div");
+        badge.className = "badge badge-outline badge-xs max-w-full truncate";
+        badge.title = e.summary;
+        badge.textContent = e.summary;
+        header.append(badge);
+      }
+      column.append(header);
+
+      const body = document.createElement("div");
+      body.className = "relative";
+      for (let h = from; h < to; h++) {
+        const row = document.createElement("div");
+        row.className = "h-16 border-t border-base-content/10";
+        body.append(row);
+      }
+
+      d.timed.sort((a, b) => a.start - b.start || a.end - b.end);
+      assignLanes(d.timed);
+      const dayStart = new Date(d.date.getFullYear(), d.date.getMonth(), d.date.getDate(), from);
+      for (const e of d.timed) {
+        const top = (e.start - dayStart) / 60000;
+        const duration = Math.min((e.end - e.start) / 60000, minutes - top);
+
+        const box = document.createElement("div");
+        box.className = "absolute overflow-hidden rounded border p-1 bg-secondary/15 border-secondary";
+        box.style.top = `${(top / minutes) * 100}%`;
+        box.style.height = `${(duration / minutes) * 100}%`;
+        box.style.left = `${(e.lane / e.lanes) * 100}%`;
+        box.style.width = `${100 / e.lanes}%`;
+        box.title = e.description || e.summary;
+
+        const time = document.createElement("div");
+        time.textContent = timeRange(e);
+        const summary = document.createElement("div");
+        summary.className = "font-semibold";
+        summary.textContent = e.summary;
+        box.append(time, summary);
+        if (e.location) {
+          const location = document.createElement("div");
+          location.textContent = e.location;
+          box.append(location);
+        }
+        body.append(box);
+      }
+      column.append(body);
+      grid.append(column);
+    }
+
+    container.append(scroll);
+  }
+
+  function render() {
+    weekNav.classList.toggle("hidden", view !== "week");
+    weekNav.classList.toggle("flex", view === "week");
+    if (view === "week") {
+      renderWeek();
+    } else {
+      renderAgenda();
+    }
+  }
+
+  for (const tab of document.querySelectorAll("[data-view]")) {
+    tab.addEventListener("click", () => {
+      view = tab.getAttribute("data-view");
+      for (const other of document.querySelectorAll("[data-view]")) {
+        other.classList.toggle("tab-active", other === tab);
+      }
+      render();
+    });
+  }
+
+  for (const btn of document.querySelectorAll("[data-week]")) {
+    btn.addEventListener("click", () => {
+      const offset = Number(btn.getAttribute("data-week"));
+      week = offset ? addDays(week, offset * 7) : startOfWeek(new Date());
+      render();
+    });
+  }
+
+  async function load() {
+    let res;
+    try {
+      res = await fetch("/api/events?" + new URLSearchParams({ url: feed }));
+    } catch {
+      showMessage(messages.preview_error);
+      return;
+    }
+    if (!res.ok) {
+      showMessage(messages.preview_error);
+      return;
+    }
+
+    const data = await res.json();
+    if (data.name) {
+      document.getElementById("preview-name").textContent = data.name;
+    }
+    events = data.events.map((e) => ({ ...e, start: new Date(e.start), end: new Date(e.end) }));
+
+    // All-day events are dates, they start at the local midnight
+    for (const e of events) {
+      if (e.all_day) {
+        e.start = new Date(e.start.getUTCFullYear(), e.start.getUTCMonth(), e.start.getUTCDate());
+        e.end = new Date(e.end.getUTCFullYear(), e.end.getUTCMonth(), e.end.getUTCDate());
+      }
+    }
+    render();
+  }
+
+  load();
+});
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/cartabinaria/unibo-go/exams"
+	"github.com/cartabinaria/unibo-go/timetable"
+	"github.com/gin-gonic/gin"
+
+	"github.com/VaiTon/unibocalendar/unibo_integ"
+)
+
+// baseCodeSegment matches a subject code as used by AlmaEsami: digits,
+// optionally preceded by a letter for the newer subjects (e.g. "04642", "B2079").
+var baseCodeSegment = regexp.MustCompile(`^[A-Z]?\d{3,}$`)
+
+// moduleTitleSuffix matches the part of a timetable title that identifies a
+// module or a group of an integrated course, e.g. " / (A-K) / (1) Modulo 1".
+var moduleTitleSuffix = regexp.MustCompile(`(?i)\s*(/|\(\d+\)\s*modulo|- modulo).*$`)
+
+// normalizeSubjectCode returns the code AlmaEsami uses for a subject code
+// found in a timetable. The rules are:
+//   - module suffixes are removed: "04642_1" becomes "04642";
+//   - alphabetic prefixes are removed: "SPOT_79006" becomes "79006";
+//   - codes that don't contain a valid segment are returned uppercase and trimmed.
+func normalizeSubjectCode(code string) string {
+	code = strings.ToUpper(strings.TrimSpace(code))
+
+	for _, segment := range strings.Split(code, "_") {
+		if baseCodeSegment.MatchString(segment) {
+			return segment
+		}
+	}
+
+	return code
+}
+
+// integratedCourseName returns the normalized name of the integrated course a
+// module belongs to, taken from its timetable title. For example
+// "FONDAMENTI DI INFORMATICA T-1 / (A-K) / (1) Modulo 1" becomes
+// "fondamenti di informatica t 1".
+func integratedCourseName(title string) string {
+	title = moduleTitleSuffix.ReplaceAllString(title, "")
+	return strings.Join(normalizeTokens(title), " ")
+}
+
+// examMatcher finds the exams of the subjects of a timetable.
+type examMatcher struct {
+	byCode map[string][]exams.Exam
+	byName map[string][]exams.Exam
+}
+
+func newExamMatcher(all []exams.Exam) examMatcher {
+	m := examMatcher{
+		byCode: make(map[string][]exams.Exam),
+		byName: make(map[string][]exams.Exam),
+	}
+
+	for _, exam := range all {
+		code := normalizeSubjectCode(exam.SubjectCode)
+		m.byCode[code] = append(m.byCode[code], exam)
+
+		name := strings.Join(normalizeTokens(exam.SubjectName), " ")
+		m.byName[name] = append(m.byName[name], exam)
+	}
+
+	return m
+}
+
+// match returns the exams of the subject. The normalized code is tried first.
+// If no exam has that code, the subject may be a module whose exams are
+// registered on the parent integrated course, so the exams are searched by the
+// name of the integrated course.
+func (m examMatcher) match(subject timetable.SimpleSubject) []exams.Exam {
+	if e, ok := m.byCode[normalizeSubjectCode(subject.Code)]; ok {
+		return e
+	}
+
+	return m.byName[integratedCourseName(subject.Name)]
+}
+
+// matchAll returns the exams of every subject, once: modules of the same
+// integrated course share its exams.
+func (m examMatcher) matchAll(subjects []timetable.SimpleSubject) []exams.Exam {
+	all := make([]exams.Exam, 0)
+	for _, s := range subjects {
+		for _, exam := range m.match(s) {
+			if !slices.Contains(all, exam) {
+				all = append(all, exam)
+			}
+		}
+	}
+	return all
+}
+
+// unmatchedSubject is a subject of a timetable without any exam.
+type unmatchedSubject struct {
+	Code       string `json:"code"`
+	Name       string `json:"name"`
+	Normalized string `json:"normalized"`
+	Parent     string `json:"parent"`
+}
+
+// getUnmatchedExams lists the subjects of a course year with no matching
+// exams, to find the codes the normalization rules don't handle yet.
+func getUnmatchedExams(courses *unibo_integ.CoursesMap) func(c *gin.Context) {
+	return func(ctx *gin.Context) {
+		req, err := parseCalRequest(ctx, courses)
+		if err != nil {
+			sendCalError(ctx, err)
+			return
+		}
+
+		_, unmatched, err := matchCourseExams(req.Course, req.Year, req.Curriculum, req.HasCurriculum, nil)
+		if err != nil {
+			if errors.Is(err, errInvalidCurriculum) {
+				ctx.String(http.StatusBadRequest, "Invalid curriculum")
+				return
+			}
+			_ = ctx.Error(err)
+			ctx.String(http.StatusInternalServerError, "Unable to get exams")
+			return
+		}
+
+		subjects := make([]unmatchedSubject, 0, len(unmatched))
+		for _, s := range unmatched {
+			subjects = append(subjects, unmatchedSubject{
+				Code:       s.Code,
+				Name:       s.Name,
+				Normalized: normalizeSubjectCode(s.Code),
+				Parent:     integratedCourseName(s.Name),
+			})
+		}
+
+		ctx.JSON(http.StatusOK, gin.H{
+			"count":    len(subjects),
+			"subjects": subjects,
+		})
+	}
+}
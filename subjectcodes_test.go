@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/cartabinaria/unibo-go/exams"
+	"github.com/cartabinaria/unibo-go/timetable"
+	"github.com/go-playground/assert/v2"
+)
+
+func Test_normalizeSubjectCode(t *testing.T) {
+	tests := []struct {
+		code string
+		want string
+	}{
+		{"00819", "00819"},
+		{"04642_1", "04642"},
+		{"28004_2", "28004"},
+		{"SPOT_79006", "79006"},
+		{"SPOT_79006_1", "79006"},
+		{"B2079", "B2079"},
+		{"B0385_1", "B0385"},
+		{" 72589 ", "72589"},
+		{"spot_79006", "79006"},
+		{"SPOT", "SPOT"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			assert.Equal(t, tt.want, normalizeSubjectCode(tt.code))
+		})
+	}
+}
+
+func Test_integratedCourseName(t *testing.T) {
+	tests := []struct {
+		title string
+		want  string
+	}{
+		{"ALGEBRA E GEOMETRIA", "algebra e geometria"},
+		{"FONDAMENTI DI INFORMATICA T-1 / (A-K) / (1) Modulo 1", "fondamenti di informatica t 1"},
+		{"CALCOLO NUMERICO (2) Modulo 2", "calcolo numerico"},
+		{"FISICA GENERALE - Modulo 1", "fisica generale"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			assert.Equal(t, tt.want, integratedCourseName(tt.title))
+		})
+	}
+}
+
+func Test_examMatcher_match(t *testing.T) {
+	all := []exams.Exam{
+		{SubjectCode: "04642", SubjectName: "ALGEBRA E GEOMETRIA"},
+		{SubjectCode: "79006", SubjectName: "LABORATORIO DI PROGRAMMAZIONE"},
+		{SubjectCode: "70219", SubjectName: "FONDAMENTI DI INFORMATICA T-1"},
+	}
+	m := newExamMatcher(all)
+
+	tests := []struct {
+		name    string
+		subject timetable.SimpleSubject
+		want    []exams.Exam
+	}{
+		{"module suffix", timetable.SimpleSubject{Code: "04642_1", Name: "ALGEBRA E GEOMETRIA"}, all[0:1]},
+		{"prefix", timetable.SimpleSubject{Code: "SPOT_79006", Name: "LAB"}, all[1:2]},
+		{
+			"integrated course",
+			timetable.SimpleSubject{Code: "70220_1", Name: "FONDAMENTI DI INFORMATICA T-1 / (1) Modulo 1"},
+			all[2:3],
+		},
+		{"no exams", timetable.SimpleSubject{Code: "12345", Name: "TIROCINIO"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, m.match(tt.subject))
+		})
+	}
+
+	// The modules of an integrated course share its exams
+	subjects := []timetable.SimpleSubject{
+		{Code: "70220_1", Name: "FONDAMENTI DI INFORMATICA T-1 / (1) Modulo 1"},
+		{Code: "70220_2", Name: "FONDAMENTI DI INFORMATICA T-1 / (2) Modulo 2"},
+		{Code: "04642", Name: "ALGEBRA E GEOMETRIA"},
+		{Code: "12345", Name: "TIROCINIO"},
+	}
+	assert.Equal(t, []exams.Exam{all[2], all[0]}, m.matchAll(subjects))
+}
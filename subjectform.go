@@ -0,0 +1,303 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/cartabinaria/unibo-go/curriculum"
+	"github.com/cartabinaria/unibo-go/timetable"
+	"github.com/gin-gonic/gin"
+
+	"github.com/VaiTon/unibocalendar/unibo_integ"
+)
+
+var errInvalidSelection = errors.New("invalid selection")
+
+// baseUrl is the public address of the server, given with the BASE_URL
+// environment variable (e.g. "https://example.com"). The links to the feeds
+// use its host, or the Host header of the request if it's not set.
+var baseUrl *url.URL
+
+// hostPattern matches a host name or an IPv6 address, with an optional port
+var hostPattern = regexp.MustCompile(`^(?:[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*|\[[0-9A-Fa-f:.]+\])(?::[0-9]{1,5})?$`)
+
+// parseBaseUrl parses the public address of the server. An empty string
+// means that it is not configured.
+func parseBaseUrl(s string) (*url.URL, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || !hostPattern.MatchString(u.Host) {
+		return nil, fmt.Errorf("invalid base url %q", s)
+	}
+	return u, nil
+}
+
+// publicHost returns the host of the links to the feeds: the one of baseUrl,
+// or the Host header of the request if it is a valid host.
+func publicHost(ctx *gin.Context) (string, bool) {
+	if baseUrl != nil {
+		return baseUrl.Host, true
+	}
+	return ctx.Request.Host, hostPattern.MatchString(ctx.Request.Host)
+}
+
+// subjectSelection is a selection of subjects of a course year, made with the
+// form of the course page and validated against the subjects of the timetable.
+type subjectSelection struct {
+	Course     *unibo_integ.Course
+	Year       int
+	Curriculum curriculum.Curriculum
+	// Subjects are the selected subjects sorted by code, empty for every subject
+	Subjects []timetable.SimpleSubject
+	// Period is the key of the selected period, empty for every period
+	Period string
+	Lang   string
+}
+
+// validateSubjectSelection checks that the curriculum and the subject codes
+// exist in the given year of m. If curr is empty, the year must have a single
+// curriculum.
+func validateSubjectSelection(m subjectMap, year int, curr string, codes []string) (curriculum.Curriculum, []timetable.SimpleSubject, error) {
+	yearSubjects, ok := m[year]
+	if !ok {
+		return curriculum.Curriculum{}, nil, fmt.Errorf("%w: invalid year", errInvalidSelection)
+	}
+
+	var c curriculum.Curriculum
+	found := false
+	for yc := range yearSubjects {
+		if yc.Value == curr || (curr == "" && len(yearSubjects) == 1) {
+			c, found = yc, true
+			break
+		}
+	}
+	if !found {
+		return curriculum.Curriculum{}, nil, fmt.Errorf("%w: invalid curriculum", errInvalidSelection)
+	}
+
+	subjects := make([]timetable.SimpleSubject, 0, len(codes))
+	for _, code := range codes {
+		i := slices.IndexFunc(yearSubjects[c], func(s timetable.SimpleSubject) bool { return s.Code == code })
+		if i == -1 {
+			return curriculum.Curriculum{}, nil, fmt.Errorf("%w: unknown subject %q", errInvalidSelection, code)
+		}
+		if !slices.ContainsFunc(subjects, func(s timetable.SimpleSubject) bool { return s.Code == code }) {
+			subjects = append(subjects, yearSubjects[c][i])
+		}
+	}
+	slices.SortFunc(subjects, func(a, b timetable.SimpleSubject) int {
+		return strings.Compare(a.Code, b.Code)
+	})
+
+	return c, subjects, nil
+}
+
+// encodeFeedQuery encodes the query of a feed URL. Keys are sorted and commas
+// are not escaped, so that the subjects stay readable.
+func encodeFeedQuery(q url.Values) string {
+	if len(q) == 0 {
+		return ""
+	}
+	return "?" + strings.ReplaceAll(q.Encode(), "%2C", ",")
+}
+
+// codes returns the codes of the selected subjects.
+func (s subjectSelection) codes() []string {
+	codes := make([]string, 0, len(s.Subjects))
+	for _, subject := range s.Subjects {
+		codes = append(codes, subject.Code)
+	}
+	return codes
+}
+
+func (s subjectSelection) query() url.Values {
+	q := make(url.Values)
+	if s.Curriculum.Value != "" {
+		q.Set("curr", s.Curriculum.Value)
+	}
+	if len(s.Subjects) != 0 {
+		q.Set("subjects", strings.Join(s.codes(), ","))
+	}
+	q.Set("lang", s.Lang)
+	return q
+}
+
+// lessonsUrl returns the canonical URL of the lessons feed of the selection.
+// The period applies only to the lessons.
+func (s subjectSelection) lessonsUrl(withExams bool) string {
+	q := s.query()
+	if s.Period != "" {
+		q.Set("period", s.Period)
+	}
+	if withExams {
+		q.Set("exams", "true")
+	}
+	return fmt.Sprintf("/cal/%d/%d%s", s.Course.Codice, s.Year, encodeFeedQuery(q))
+}
+
+// examsUrl returns the canonical URL of the exams feed of the selection.
+func (s subjectSelection) examsUrl() string {
+	return fmt.Sprintf("/exams/%d/%d%s", s.Course.Codice, s.Year, encodeFeedQuery(s.query()))
+}
+
+// selectionFeed is a feed of a selection, as shown by the feed page.
+type selectionFeed struct {
+	Name   string `json:"name"`
+	Url    string `json:"url"`
+	Webcal string `json:"webcal"`
+	Google string `json:"google"`
+	Qr     string `json:"qr"`
+}
+
+func newSelectionFeed(name, host, path string) selectionFeed {
+	webcal := "webcal://" + host + path
+	return selectionFeed{
+		Name:   name,
+		Url:    path,
+		Webcal: webcal,
+		Google: "https://www.google.com/calendar/render?cid=" + url.QueryEscape(webcal),
+		Qr:     "/qr?" + url.Values{"format": {"svg"}, "url": {path}}.Encode(),
+	}
+}
+
+// feeds returns the lessons, exams and combined feeds of the selection.
+func (s subjectSelection) feeds(host string) []selectionFeed {
+	return []selectionFeed{
+		newSelectionFeed(tr(s.Lang, "course.lessons"), host, s.lessonsUrl(false)),
+		newSelectionFeed(tr(s.Lang, "course.exams"), host, s.examsUrl()),
+		newSelectionFeed(tr(s.Lang, "course.lessons_exams"), host, s.lessonsUrl(true)),
+	}
+}
+
+// selectionCodes returns the subject codes of the "subjects" query
+// parameter, that can be repeated (as sent by the form) or comma separated.
+func selectionCodes(values []string) []string {
+	codes := make([]string, 0, len(values))
+	for _, v := range values {
+		for _, code := range strings.Split(v, ",") {
+			code = strings.TrimSpace(code)
+			if code != "" {
+				codes = append(codes, code)
+			}
+		}
+	}
+	return codes
+}
+
+// parseSubjectSelection parses the ":id" path parameter and the "anno",
+// "curr" and "subjects" query parameters, validating them against the
+// subjects of the timetable.
+//
+// If they are not valid, an error response is sent and false is returned.
+func parseSubjectSelection(ctx *gin.Context, courses unibo_integ.CoursesMap) (subjectSelection, bool) {
+	courseId, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.String(http.StatusBadRequest, "Invalid course id")
+		return subjectSelection{}, false
+	}
+
+	course, found := courses.FindById(courseId)
+	if !found {
+		ctx.String(http.StatusNotFound, "Course not found")
+		return subjectSelection{}, false
+	}
+
+	year, err := strconv.Atoi(ctx.Query("anno"))
+	if err != nil || year <= 0 || year > course.DurataAnni {
+		ctx.String(http.StatusBadRequest, "Invalid year")
+		return subjectSelection{}, false
+	}
+
+	curricula, err := course.GetAllCurricula()
+	if err != nil {
+		_ = ctx.Error(err)
+		ctx.String(http.StatusInternalServerError, "Unable to retrieve curricula")
+		return subjectSelection{}, false
+	}
+
+	m, err := getSubjectsMapFromCourseAndCurricula(course, curricula)
+	if err != nil {
+		_ = ctx.Error(err)
+		ctx.String(http.StatusInternalServerError, "Unable to retrieve subjects")
+		return subjectSelection{}, false
+	}
+
+	sel := subjectSelection{Course: course, Year: year, Lang: pageLang(ctx)}
+	sel.Curriculum, sel.Subjects, err = validateSubjectSelection(
+		m, year, ctx.Query("curr"), selectionCodes(ctx.QueryArray("subjects")),
+	)
+	if err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return subjectSelection{}, false
+	}
+
+	return sel, true
+}
+
+// subjectFormPage handles the subject selection form of the course page. It
+// validates the selection with parseSubjectSelection and the "period"
+// parameter and shows the canonical URLs of the feeds, or returns them as
+// JSON if requested with the Accept header.
+func subjectFormPage(courses unibo_integ.CoursesMap) func(c *gin.Context) {
+	return func(ctx *gin.Context) {
+		sel, ok := parseSubjectSelection(ctx, courses)
+		if !ok {
+			return
+		}
+		course, year := sel.Course, sel.Year
+
+		periodLabel := ""
+		if p := ctx.Query("period"); p != "" {
+			periods, err := getCoursePeriods(course, year, sel.Curriculum)
+			if err != nil {
+				_ = ctx.Error(err)
+				ctx.String(http.StatusInternalServerError, "Unable to retrieve periods")
+				return
+			}
+
+			period, ok := findPeriod(periods, p)
+			if !ok {
+				ctx.String(http.StatusBadRequest, "Invalid period")
+				return
+			}
+			sel.Period, periodLabel = period.Key(), period.Label
+		}
+
+		host, ok := publicHost(ctx)
+		if !ok {
+			ctx.String(http.StatusBadRequest, "Invalid host")
+			return
+		}
+		feeds := sel.feeds(host)
+
+		if ctx.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) == gin.MIMEJSON {
+			ctx.JSON(http.StatusOK, gin.H{
+				"course":     course.Codice,
+				"year":       year,
+				"curriculum": sel.Curriculum.Value,
+				"subjects":   sel.Subjects,
+				"period":     sel.Period,
+				"feeds":      feeds,
+			})
+			return
+		}
+
+		ctx.HTML(http.StatusOK, "feed", gin.H{
+			"Course":      course,
+			"Selection":   sel,
+			"PeriodLabel": periodLabel,
+			"Feeds":       feeds,
+			"Host":        host,
+			"Lang":        sel.Lang,
+		})
+	}
+}
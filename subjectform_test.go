@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cartabinaria/unibo-go/curriculum"
+	"github.com/cartabinaria/unibo-go/timetable"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/assert/v2"
+
+	"github.com/VaiTon/unibocalendar/unibo_integ"
+)
+
+func Test_validateSubjectSelection(t *testing.T) {
+	common := curriculum.Curriculum{Value: "000-000", Label: "Generale"}
+	other := curriculum.Curriculum{Value: "A58-000", Label: "Informatica"}
+	algebra := timetable.SimpleSubject{Name: "ALGEBRA", Code: "04642"}
+	analisi := timetable.SimpleSubject{Name: "ANALISI", Code: "00013"}
+
+	m := subjectMap{
+		1: {common: {algebra, analisi}},
+		2: {common: {algebra}, other: {analisi}},
+	}
+
+	tests := []struct {
+		name     string
+		year     int
+		curr     string
+		codes    []string
+		wantCurr curriculum.Curriculum
+		want     []timetable.SimpleSubject
+		wantErr  bool
+	}{
+		{"every subject", 1, "000-000", nil, common, []timetable.SimpleSubject{}, false},
+		{"sorted and unique", 1, "000-000", []string{"04642", "00013", "04642"}, common, []timetable.SimpleSubject{analisi, algebra}, false},
+		{"single curriculum", 1, "", []string{"04642"}, common, []timetable.SimpleSubject{algebra}, false},
+		{"curriculum required", 2, "", nil, curriculum.Curriculum{}, nil, true},
+		{"other curriculum", 2, "A58-000", []string{"00013"}, other, []timetable.SimpleSubject{analisi}, false},
+		{"subject of another curriculum", 2, "A58-000", []string{"04642"}, curriculum.Curriculum{}, nil, true},
+		{"unknown curriculum", 1, "B00-000", nil, curriculum.Curriculum{}, nil, true},
+		{"unknown subject", 1, "000-000", []string{"99999"}, curriculum.Curriculum{}, nil, true},
+		{"unknown year", 3, "000-000", nil, curriculum.Curriculum{}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, subjects, err := validateSubjectSelection(m, tt.year, tt.curr, tt.codes)
+			assert.Equal(t, tt.wantErr, err != nil)
+			assert.Equal(t, tt.wantCurr, c)
+			assert.Equal(t, tt.want, subjects)
+		})
+	}
+}
+
+func Test_selectionCodes(t *testing.T) {
+	assert.Equal(t, []string{"1", "2", "3"}, selectionCodes([]string{"1", " 2,3 ", ","}))
+	assert.Equal(t, []string{}, selectionCodes(nil))
+}
+
+func Test_subjectSelection_urls(t *testing.T) {
+	sel := subjectSelection{
+		Course:     &unibo_integ.Course{Codice: 8009},
+		Year:       2,
+		Curriculum: curriculum.Curriculum{Value: "000-000"},
+		Subjects:   []timetable.SimpleSubject{{Code: "00013"}, {Code: "04642"}},
+		Period:     "2024-09-16_2024-12-20",
+		Lang:       langIt,
+	}
+
+	assert.Equal(t, "/cal/8009/2?curr=000-000&lang=it&period=2024-09-16_2024-12-20&subjects=00013,04642", sel.lessonsUrl(false))
+	assert.Equal(t, "/cal/8009/2?curr=000-000&exams=true&lang=it&period=2024-09-16_2024-12-20&subjects=00013,04642", sel.lessonsUrl(true))
+	assert.Equal(t, "/exams/8009/2?curr=000-000&lang=it&subjects=00013,04642", sel.examsUrl())
+
+	sel = subjectSelection{Course: &unibo_integ.Course{Codice: 8009}, Year: 1, Lang: langEn}
+	assert.Equal(t, "/exams/8009/1?lang=en", sel.examsUrl())
+
+	feeds := sel.feeds("example.com")
+	assert.Equal(t, "webcal://example.com/cal/8009/1?lang=en", feeds[0].Webcal)
+	assert.Equal(t, "https://www.google.com/calendar/render?cid=webcal%3A%2F%2Fexample.com%2Fcal%2F8009%2F1%3Flang%3Den", feeds[0].Google)
+	assert.Equal(t, "/qr?format=svg&url=%2Fcal%2F8009%2F1%3Flang%3Den", feeds[0].Qr)
+}
+
+func Test_subjectFormPage_invalidYear(t *testing.T) {
+	r := setupRouter(unibo_integ.CoursesMap{8009: {Codice: 8009, DurataAnni: 3}})
+
+	for _, u := range []string{"/courses/8009/feed", "/courses/8009/feed?anno=4", "/courses/1/feed?anno=1"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, u, nil))
+		assert.NotEqual(t, http.StatusOK, w.Code)
+	}
+}
+
+func Test_publicHost(t *testing.T) {
+	tests := []struct {
+		host string
+		ok   bool
+	}{
+		{"example.com", true},
+		{"localhost:8080", true},
+		{"[::1]:8080", true},
+		{"evil.example/path", false},
+		{"evil.example\"><script>", false},
+		{"user@evil.example", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+			ctx.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+			ctx.Request.Host = tt.host
+
+			host, ok := publicHost(ctx)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.host, host)
+		})
+	}
+
+	// The configured address takes the place of the Host header
+	var err error
+	baseUrl, err = parseBaseUrl("https://calendar.example.com")
+	assert.Equal(t, nil, err)
+	defer func() { baseUrl = nil }()
+
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	ctx.Request.Host = "evil.example"
+	host, ok := publicHost(ctx)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "calendar.example.com", host)
+
+	_, err = parseBaseUrl("calendar.example.com")
+	assert.NotEqual(t, nil, err)
+}
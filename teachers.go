@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+
+	ics "github.com/arran4/golang-ical"
+	"github.com/cartabinaria/unibo-go/timetable"
+	"github.com/gin-gonic/gin"
+	"github.com/patrickmn/go-cache"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	teacherIndexKey   = "teachers"
+	maxTeacherResults = 50
+)
+
+// teacher is a teacher found in the timetables of the courses, with every
+// lesson they teach.
+type teacher struct {
+	Name    string
+	Slug    string
+	Courses []int
+	Lessons timetable.Timetable
+}
+
+// teacherSplitter splits the teacher field of an event when more than one
+// teacher is listed (e.g. "Mario Rossi, Anna Bianchi" or "Mario Rossi e Anna Bianchi").
+var teacherSplitter = regexp.MustCompile(`\s*[,;/&]\s*|\s+e\s+`)
+
+func splitTeachers(s string) []string {
+	names := make([]string, 0, 1)
+	for _, name := range teacherSplitter.Split(s, -1) {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func removeAccents(s string) string {
+	// The transformer is stateful, so it can't be shared between goroutines
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	r, _, err := transform.String(t, s)
+	if err != nil {
+		return s
+	}
+	return r
+}
+
+// normalizeTokens returns the lowercase words of s, without accents and
+// apostrophes, so that "D'Angelo Nicolò" becomes ["dangelo", "nicolo"].
+func normalizeTokens(s string) []string {
+	s = strings.ToLower(removeAccents(s))
+	s = strings.NewReplacer("'", "", "’", "").Replace(s)
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// teacherSlug returns the identifier of a teacher. The name tokens are sorted,
+// so "Mario Rossi" and "ROSSI Mario" share the same slug.
+func teacherSlug(name string) string {
+	tokens := normalizeTokens(name)
+	slices.Sort(tokens)
+	return strings.Join(tokens, "-")
+}
+
+// buildTeacherIndex groups the lessons of the given timetables by teacher.
+// Lessons shared between courses are included only once.
+func buildTeacherIndex(timetables []cachedTimetable) map[string]*teacher {
+	index := make(map[string]*teacher)
+	seen := make(map[string]map[string]struct{})
+
+	for _, t := range timetables {
+		for _, event := range t.Timetable {
+			uid, err := lessonUid(event)
+			if err != nil {
+				continue
+			}
+
+			for _, name := range splitTeachers(event.Teacher) {
+				slug := teacherSlug(name)
+				if slug == "" {
+					continue
+				}
+
+				te, ok := index[slug]
+				if !ok {
+					te = &teacher{Name: name, Slug: slug}
+					index[slug] = te
+					seen[slug] = make(map[string]struct{})
+				}
+
+				if !slices.Contains(te.Courses, t.Course) {
+					te.Courses = append(te.Courses, t.Course)
+				}
+
+				if _, ok := seen[slug][uid]; ok {
+					continue
+				}
+				seen[slug][uid] = struct{}{}
+				te.Lessons = append(te.Lessons, event)
+			}
+		}
+	}
+
+	for _, te := range index {
+		slices.Sort(te.Courses)
+		slices.SortFunc(te.Lessons, func(a, b timetable.Event) int {
+			return a.Start.Compare(b.Start.Time)
+		})
+	}
+
+	return index
+}
+
+// getTeacherIndex returns the teacher index built from the timetables that are
+// currently cached. The index is rebuilt when a timetable is refreshed or
+// when it expires from indexCache.
+func getTeacherIndex() map[string]*teacher {
+	if index, found := indexCache.Get(teacherIndexKey); found {
+		return index.(map[string]*teacher)
+	}
+
+	index := buildTeacherIndex(warmedTimetables())
+	indexCache.Set(teacherIndexKey, index, cache.DefaultExpiration)
+	return index
+}
+
+// searchTeachers returns the teachers whose name contains every word of the
+// query as a prefix of one of its words, sorted by name.
+func searchTeachers(index map[string]*teacher, query string) []*teacher {
+	queryTokens := normalizeTokens(query)
+	if len(queryTokens) == 0 {
+		return nil
+	}
+
+	results := make([]*teacher, 0)
+	for _, te := range index {
+		nameTokens := strings.Split(te.Slug, "-")
+		matches := true
+		for _, q := range queryTokens {
+			if !slices.ContainsFunc(nameTokens, func(n string) bool { return strings.HasPrefix(n, q) }) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			results = append(results, te)
+		}
+	}
+
+	slices.SortFunc(results, func(a, b *teacher) int {
+		return strings.Compare(a.Slug, b.Slug)
+	})
+	return results
+}
+
+func createTeacherCal(te *teacher, opts lessonOptions) (*ics.Calendar, error) {
+	cal := ics.NewCalendar()
+	cal.SetMethod(ics.MethodRequest)
+
+	for _, event := range te.Lessons {
+		err := addLessonEvent(cal, event, opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cal.SetName(tr(opts.Lang, "cal.teacher.name", te.Name))
+	cal.SetDescription(tr(opts.Lang, "cal.teacher.description", te.Name))
+
+	return cal, nil
+}
+
+func teachersPage() func(c *gin.Context) {
+	return func(ctx *gin.Context) {
+		query := ctx.Query("q")
+		index := getTeacherIndex()
+
+		results := searchTeachers(index, query)
+		truncated := len(results) > maxTeacherResults
+		if truncated {
+			results = results[:maxTeacherResults]
+		}
+
+		ctx.HTML(http.StatusOK, "teachers", gin.H{
+			"query":     query,
+			"teachers":  results,
+			"truncated": truncated,
+			"indexed":   len(index),
+			"Lang":      pageLang(ctx),
+		})
+	}
+}
+
+func getTeacherCal() func(c *gin.Context) {
+	return func(ctx *gin.Context) {
+		cal, err := teacherFeed(ctx)
+		sendCalendar(ctx, cal, err)
+	}
+}
+
+// teacherFeed returns the calendar of the teacher of the ":slug" path
+// parameter.
+func teacherFeed(q feedParams) (*bytes.Buffer, error) {
+	slug := q.Param("slug")
+
+	te, found := getTeacherIndex()[slug]
+	if !found {
+		return nil, &calError{http.StatusNotFound, "Teacher not found", nil}
+	}
+
+	opts, err := parseLessonOptions(q)
+	if err != nil {
+		return nil, &calError{http.StatusBadRequest, err.Error(), nil}
+	}
+
+	cacheKey := fmt.Sprintf("teacher-%s-%s", slug, opts.key())
+	return cachedCalendar(calcache, cacheKey, func() (*ics.Calendar, error) {
+		return createTeacherCal(te, opts)
+	})
+}
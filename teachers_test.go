@@ -0,0 +1,109 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cartabinaria/unibo-go/timetable"
+	"github.com/go-playground/assert/v2"
+)
+
+func Test_teacherSlug(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Mario Rossi", "mario-rossi"},
+		{"ROSSI Mario", "mario-rossi"},
+		{"Nicolò D'Angelo", "dangelo-nicolo"},
+		{"  Nicolo   DANGELO ", "dangelo-nicolo"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, teacherSlug(tt.name))
+		})
+	}
+}
+
+func Test_splitTeachers(t *testing.T) {
+	tests := []struct {
+		field string
+		want  []string
+	}{
+		{"Mario Rossi", []string{"Mario Rossi"}},
+		{"Mario Rossi, Anna Bianchi", []string{"Mario Rossi", "Anna Bianchi"}},
+		{"Mario Rossi; Anna Bianchi / Luca Verdi", []string{"Mario Rossi", "Anna Bianchi", "Luca Verdi"}},
+		{"Mario Rossi e Anna Bianchi", []string{"Mario Rossi", "Anna Bianchi"}},
+		{"", []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.field, func(t *testing.T) {
+			assert.Equal(t, tt.want, splitTeachers(tt.field))
+		})
+	}
+}
+
+func Test_buildTeacherIndex(t *testing.T) {
+	start := time.Date(2024, 10, 1, 9, 0, 0, 0, time.UTC)
+	shared := timetable.Event{
+		CodModulo: "00001",
+		Teacher:   "Mario Rossi, Anna Bianchi",
+		Start:     timetable.CalendarTime{Time: start},
+		End:       timetable.CalendarTime{Time: start.Add(2 * time.Hour)},
+	}
+	other := timetable.Event{
+		CodModulo: "00002",
+		Teacher:   "ROSSI Mario",
+		Start:     timetable.CalendarTime{Time: start.Add(-24 * time.Hour)},
+		End:       timetable.CalendarTime{Time: start.Add(-22 * time.Hour)},
+	}
+
+	index := buildTeacherIndex([]cachedTimetable{
+		{Course: 2, Year: 1, Timetable: timetable.Timetable{shared, other}},
+		{Course: 1, Year: 2, Timetable: timetable.Timetable{shared}},
+	})
+
+	assert.Equal(t, 2, len(index))
+
+	rossi := index["mario-rossi"]
+	assert.Equal(t, []int{1, 2}, rossi.Courses)
+	assert.Equal(t, 2, len(rossi.Lessons))
+	assert.Equal(t, "00002", rossi.Lessons[0].CodModulo)
+
+	assert.Equal(t, 1, len(index["anna-bianchi"].Lessons))
+
+	results := searchTeachers(index, "ross")
+	assert.Equal(t, 1, len(results))
+	assert.Equal(t, "mario-rossi", results[0].Slug)
+}
+
+func Test_getTeacherIndex(t *testing.T) {
+	defer timetableCache.Flush()
+	defer indexCache.Flush()
+
+	start := time.Date(2024, 10, 1, 9, 0, 0, 0, romeLocation)
+	lesson := func(teacher string) timetable.Timetable {
+		return timetable.Timetable{{
+			CodModulo: "00001",
+			Teacher:   teacher,
+			Start:     timetable.CalendarTime{Time: start},
+			End:       timetable.CalendarTime{Time: start.Add(time.Hour)},
+		}}
+	}
+
+	storeTimetable("8009-1-000-000", cachedTimetable{Course: 8009, Year: 1, Timetable: lesson("Mario Rossi")})
+	rossi, found := getTeacherIndex()["mario-rossi"]
+	assert.Equal(t, true, found)
+	assert.Equal(t, []int{8009}, rossi.Courses)
+
+	// The lesson is given by another teacher: the index is rebuilt
+	storeTimetable("8009-1-000-000", cachedTimetable{Course: 8009, Year: 1, Timetable: lesson("Anna Bianchi")})
+	index := getTeacherIndex()
+	_, found = index["mario-rossi"]
+	assert.Equal(t, false, found)
+	_, found = index["anna-bianchi"]
+	assert.Equal(t, true, found)
+}
@@ -19,10 +19,73 @@ var (
 	calcache                    = cache.New(time.Minute*10, time.Minute*30)
 	subjectsCacheExpirationTime = time.Hour * 4
 	subjectsCache               = cache.New(subjectsCacheExpirationTime, time.Hour*6)
+	// examscache holds the exams feeds. Exams change less often than
+	// lessons and scraping them is slower, so they are kept longer.
+	examscache = cache.New(time.Hour, time.Hour*2)
+	// timetableCache holds the full timetables fetched while filling the
+	// subjects cache. It is used to build the indexes that span every course,
+	// so the timetables never expire: they are replaced by the next refresh.
+	timetableCache = cache.New(cache.NoExpiration, 0)
+	// indexCache holds the indexes built from timetableCache
+	indexCache = cache.New(time.Minute*10, time.Minute*30)
 )
 
+// localTimeLayout is the layout of the times given in query parameters, the
+// same used by the datetime-local HTML input.
+const localTimeLayout = "2006-01-02T15:04"
+
+// romeLocation is the timezone of the timetables
+var romeLocation = loadRomeLocation()
+
+func loadRomeLocation() *time.Location {
+	loc, err := time.LoadLocation("Europe/Rome")
+	if err != nil {
+		log.Warn().Err(err).Msg("unable to load Europe/Rome timezone, using local time")
+		return time.Local
+	}
+	return loc
+}
+
+// parseLocalTime parses a time in localTimeLayout in the timezone of the
+// timetables. RFC 3339 times are accepted too.
+func parseLocalTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation(localTimeLayout, s, romeLocation)
+}
+
 type subjectMap = map[int]map[curriculum.Curriculum][]timetable.SimpleSubject
 
+// cachedTimetable is the value stored in timetableCache.
+type cachedTimetable struct {
+	Course     int
+	Year       int
+	Curriculum curriculum.Curriculum
+	Timetable  timetable.Timetable
+	// Fetched is when the timetable was fetched, it is fetched again after
+	// subjectsCacheExpirationTime
+	Fetched time.Time
+}
+
+// storeTimetable replaces the timetable in timetableCache. The indexes are
+// dropped, so that they are rebuilt with it: a free room must not be found
+// in an outdated timetable.
+func storeTimetable(key string, t cachedTimetable) {
+	timetableCache.Set(key, t, cache.DefaultExpiration)
+	indexCache.Flush()
+}
+
+// warmedTimetables returns every timetable currently stored in the cache.
+func warmedTimetables() []cachedTimetable {
+	items := timetableCache.Items()
+	timetables := make([]cachedTimetable, 0, len(items))
+	for _, item := range items {
+		timetables = append(timetables, item.Object.(cachedTimetable))
+	}
+	return timetables
+}
+
 // The return type is a map that for every year of the course map a curriculum
 // to a slice of subjects
 func getSubjectsMapFromCourseAndCurricula(course *unibo_integ.Course, curricula map[int]curriculum.Curricula) (subjectMap, error) {
@@ -49,7 +112,7 @@ func getSubjectsMapFromCourseAndCurricula(course *unibo_integ.Course, curricula
 				continue
 			}
 
-			courseTimetable, err := course.GetTimetable(y, c, nil)
+			courseTimetable, err := getCachedTimetable(course, y, c)
 			if err != nil {
 				// Can't do much. We return nil so the caller can retry
 				return nil, fmt.Errorf("unable to retrieve timetable for subjects: %w", err)
@@ -69,6 +132,32 @@ func getSubjectsMapFromCourseAndCurricula(course *unibo_integ.Course, curricula
 	return m, nil
 }
 
+// getCachedTimetable returns the full timetable of the given course year and
+// curriculum, fetching it only if it's not in timetableCache or is older than
+// subjectsCacheExpirationTime.
+func getCachedTimetable(course *unibo_integ.Course, year int, c curriculum.Curriculum) (timetable.Timetable, error) {
+	key := fmt.Sprintf("%d-%d-%s", course.Codice, year, c.Value)
+	if t, found := timetableCache.Get(key); found && time.Since(t.(cachedTimetable).Fetched) < subjectsCacheExpirationTime {
+		return t.(cachedTimetable).Timetable, nil
+	}
+
+	courseTimetable, err := course.GetTimetable(year, c, nil)
+	if err != nil {
+		return nil, err
+	}
+	recordTimetable(course.Codice, year, c.Value, courseTimetable)
+
+	storeTimetable(key, cachedTimetable{
+		Course:     course.Codice,
+		Year:       year,
+		Curriculum: c,
+		Timetable:  courseTimetable,
+		Fetched:    time.Now(),
+	})
+
+	return courseTimetable, nil
+}
+
 func filterTimetableBySubjects(t timetable.Timetable, codes []string) timetable.Timetable {
 	filtered := make([]timetable.Event, 0, len(t))
 	for _, event := range t {
@@ -80,27 +169,33 @@ func filterTimetableBySubjects(t timetable.Timetable, codes []string) timetable.
 }
 
 // This functions calls getSubjectsMapFromCourseAndCurricula for every course,
-// so the cache is always full and users do not see a slow site
+// again every subjectsCacheExpirationTime, so the cache is always full and
+// users do not see a slow site. Every refresh of a timetable or of the exams
+// is compared with the previous one, and the changes are sent to the webhooks
+// and kept for the feeds.
 func fillSubjectsCache(courses unibo_integ.CoursesMap) {
 	// This is to make sure everything is started
 	time.Sleep(time.Second * 5)
 
-	for _, course := range courses {
-		log.Debug().Int("course-code", course.Codice).Str("course-name", course.Descrizione).Msg("queried subjects")
+	for {
+		for _, course := range courses {
+			log.Debug().Int("course-code", course.Codice).Str("course-name", course.Descrizione).Msg("queried subjects")
 
-		curricula, err := course.GetAllCurricula()
-		if err != nil {
-			log.Err(err).Int("course-code", course.Codice).Str("course-name", course.Descrizione).Msg("Can't get curricula in workerfor course")
-			continue
-		}
-		_, err = getSubjectsMapFromCourseAndCurricula(&course, curricula)
-		if err != nil {
-			log.Err(err).Msg("Can't subjects in worker")
-			continue
+			curricula, err := course.GetAllCurricula()
+			if err != nil {
+				log.Err(err).Int("course-code", course.Codice).Str("course-name", course.Descrizione).Msg("Can't get curricula in workerfor course")
+				continue
+			}
+			_, err = getSubjectsMapFromCourseAndCurricula(&course, curricula)
+			if err != nil {
+				log.Err(err).Msg("Can't subjects in worker")
+				continue
+			}
+			refreshExams(course, curricula)
+
+			time.Sleep(time.Second * 30)
 		}
 
-		time.Sleep(time.Second * 30)
+		time.Sleep(subjectsCacheExpirationTime)
 	}
-
-	time.Sleep(subjectsCacheExpirationTime)
 }
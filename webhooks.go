@@ -0,0 +1,509 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/VaiTon/unibocalendar/unibo_integ"
+)
+
+const (
+	webhooksBucket = "webhooks"
+
+	webhookIdBytes      = 9
+	minWebhookSecret    = 16
+	maxWebhookSecret    = 256
+	maxWebhookUrlLength = 2000
+	// maxCourseWebhooks is the number of webhooks that can watch a course
+	maxCourseWebhooks = 50
+
+	// webhookEvent is the event of the deliveries
+	webhookEvent = "timetable.changed"
+)
+
+// webhooks stores the webhook subscriptions. It is nil when the store was
+// not opened, in that case webhooks are not available.
+var webhooks *webhookStore
+
+var (
+	errWebhookNotFound = errors.New("webhook not found")
+	errTooManyWebhooks = errors.New("too many webhooks for the course")
+	errPrivateAddress  = errors.New("webhooks can't be delivered to private addresses")
+)
+
+// webhookRetryDelays are the waits before delivering again a payload that
+// was refused. After the last one the payload is dropped.
+var webhookRetryDelays = []time.Duration{time.Minute, time.Minute * 10}
+
+// webhooksAllowPrivate allows the deliveries to private and loopback
+// addresses, e.g. to test the webhooks with a local receiver. It is set with
+// the WEBHOOKS_ALLOW_PRIVATE environment variable.
+var webhooksAllowPrivate = false
+
+// webhookClient delivers the payloads. It doesn't follow redirects, doesn't
+// use proxies and doesn't connect to private addresses, so that webhooks
+// can't be used to reach the internal network.
+var webhookClient = &http.Client{
+	Timeout: time.Second * 10,
+	Transport: &http.Transport{
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout: time.Second * 5,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				ip := net.ParseIP(host)
+				if ip == nil {
+					return errPrivateAddress
+				}
+				if webhooksAllowPrivate && (ip.IsLoopback() || ip.IsPrivate()) {
+					return nil
+				}
+				if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+					ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+					return errPrivateAddress
+				}
+				return nil
+			},
+		}).DialContext,
+	},
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// webhookFilter selects the changes sent to a webhook. Empty fields match
+// everything.
+type webhookFilter struct {
+	Course     int          `json:"course"`
+	Year       int          `json:"year,omitempty"`
+	Curriculum string       `json:"curriculum,omitempty"`
+	Subjects   []string     `json:"subjects,omitempty"`
+	Kinds      []changeKind `json:"kinds,omitempty"`
+}
+
+// validate checks the filter and normalizes it.
+func (f *webhookFilter) validate(courses *unibo_integ.CoursesMap) error {
+	course, found := courses.FindById(f.Course)
+	if !found {
+		return fmt.Errorf("course %d not found", f.Course)
+	}
+	if f.Year < 0 || f.Year > course.DurataAnni {
+		return fmt.Errorf("invalid year %d", f.Year)
+	}
+
+	if len(f.Subjects) > maxLinkSubjects {
+		return fmt.Errorf("too many subjects (max %d)", maxLinkSubjects)
+	}
+	subjects := make([]string, 0, len(f.Subjects))
+	for _, s := range f.Subjects {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			return fmt.Errorf("invalid subject %q", s)
+		}
+		if !slices.Contains(subjects, s) {
+			subjects = append(subjects, s)
+		}
+	}
+	slices.Sort(subjects)
+	f.Subjects = subjects
+
+	for _, k := range f.Kinds {
+		if !slices.Contains(changeKinds, k) {
+			return fmt.Errorf("invalid kind %q", k)
+		}
+	}
+
+	return nil
+}
+
+// watches reports whether the filter matches the timetable of the course
+// year and curriculum.
+func (f webhookFilter) watches(course, year int, curr string) bool {
+	return f.Course == course && (f.Year == 0 || f.Year == year) && (f.Curriculum == "" || f.Curriculum == curr)
+}
+
+func (f webhookFilter) matches(change timetableChange) bool {
+	return (len(f.Subjects) == 0 || slices.Contains(f.Subjects, change.SubjectCode)) &&
+		(len(f.Kinds) == 0 || slices.Contains(f.Kinds, change.Kind))
+}
+
+// webhook is a webhook subscription, as stored in the database.
+type webhook struct {
+	Id     string        `json:"id"`
+	Url    string        `json:"url"`
+	Filter webhookFilter `json:"filter"`
+	// Secret signs the payloads. It is stored as is, since it is needed to
+	// sign them, and it is asked to manage the webhook.
+	Secret  string    `json:"secret"`
+	Created time.Time `json:"created"`
+}
+
+func (h webhook) checkSecret(secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(secret), []byte(h.Secret)) == 1
+}
+
+// webhookStore is the embedded database of the webhooks.
+type webhookStore struct {
+	db *bolt.DB
+}
+
+// create stores the webhook with a new id, unless the course is already
+// watched by maxCourseWebhooks webhooks.
+func (s *webhookStore) create(h webhook) (webhook, error) {
+	h.Created = time.Now()
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(webhooksBucket))
+
+		hooks, err := webhooksFromBucket(b, h.Filter.Course)
+		if err != nil {
+			return err
+		}
+		if len(hooks) >= maxCourseWebhooks {
+			return errTooManyWebhooks
+		}
+
+		for {
+			id, err := randomString(webhookIdBytes)
+			if err != nil {
+				return err
+			}
+			if b.Get([]byte(id)) == nil {
+				h.Id = id
+				break
+			}
+		}
+
+		data, err := json.Marshal(h)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(h.Id), data)
+	})
+	if err != nil {
+		return webhook{}, err
+	}
+
+	return h, nil
+}
+
+func getWebhookFromBucket(b *bolt.Bucket, id, secret string) (webhook, error) {
+	var h webhook
+	data := b.Get([]byte(id))
+	if data == nil {
+		return h, errWebhookNotFound
+	}
+	if err := json.Unmarshal(data, &h); err != nil {
+		return h, err
+	}
+	if !h.checkSecret(secret) {
+		return h, errWrongSecret
+	}
+	return h, nil
+}
+
+// get returns the webhook, if the secret is right.
+func (s *webhookStore) get(id, secret string) (webhook, error) {
+	var h webhook
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		h, err = getWebhookFromBucket(tx.Bucket([]byte(webhooksBucket)), id, secret)
+		return err
+	})
+	return h, err
+}
+
+// delete removes the webhook, if the secret is right.
+func (s *webhookStore) delete(id, secret string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(webhooksBucket))
+		if _, err := getWebhookFromBucket(b, id, secret); err != nil {
+			return err
+		}
+		return b.Delete([]byte(id))
+	})
+}
+
+func webhooksFromBucket(b *bolt.Bucket, course int) ([]webhook, error) {
+	hooks := make([]webhook, 0)
+	err := b.ForEach(func(_, data []byte) error {
+		var h webhook
+		if err := json.Unmarshal(data, &h); err != nil {
+			return err
+		}
+		if h.Filter.Course == course {
+			hooks = append(hooks, h)
+		}
+		return nil
+	})
+	return hooks, err
+}
+
+// watching returns the webhooks that watch the course.
+func (s *webhookStore) watching(course int) ([]webhook, error) {
+	var hooks []webhook
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		hooks, err = webhooksFromBucket(tx.Bucket([]byte(webhooksBucket)), course)
+		return err
+	})
+	return hooks, err
+}
+
+// webhookPayload is the body of the deliveries.
+type webhookPayload struct {
+	Event   string `json:"event"`
+	Webhook string `json:"webhook"`
+	timetableChanges
+}
+
+// signPayload returns the signature of the body sent in the
+// X-AlmaCalendar-Signature header: the hex HMAC-SHA256 of the body with the
+// secret of the webhook.
+func signPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliverWebhook posts the body to the webhook, once.
+func deliverWebhook(ctx context.Context, h webhook, delivery string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "AlmaCalendar-Webhook")
+	req.Header.Set("X-AlmaCalendar-Event", webhookEvent)
+	req.Header.Set("X-AlmaCalendar-Delivery", delivery)
+	req.Header.Set("X-AlmaCalendar-Signature", signPayload(h.Secret, body))
+
+	res, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// notifyWebhooks delivers the changes to the webhooks that watch them,
+// retrying the failed deliveries. It returns when every delivery is done.
+// The webhooks of every curriculum get only the fresh changes, the ones not
+// already sent for another curriculum of the course year.
+func notifyWebhooks(changes timetableChanges, fresh []timetableChange) {
+	if webhooks == nil {
+		return
+	}
+
+	hooks, err := webhooks.watching(changes.Course)
+	if err != nil {
+		log.Err(err).Int("course-code", changes.Course).Msg("unable to get webhooks")
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, h := range hooks {
+		if !h.Filter.watches(changes.Course, changes.Year, changes.Curriculum) {
+			continue
+		}
+
+		payload := webhookPayload{Event: webhookEvent, Webhook: h.Id, timetableChanges: changes}
+		candidates := changes.Changes
+		if h.Filter.Curriculum == "" {
+			candidates = fresh
+		}
+		payload.Changes = make([]timetableChange, 0, len(candidates))
+		for _, c := range candidates {
+			if h.Filter.matches(c) {
+				payload.Changes = append(payload.Changes, c)
+			}
+		}
+		if len(payload.Changes) == 0 {
+			continue
+		}
+
+		body, err := json.Marshal(payload)
+		if err != nil {
+			log.Err(err).Str("webhook", h.Id).Msg("unable to encode webhook payload")
+			continue
+		}
+		delivery, err := randomString(webhookIdBytes)
+		if err != nil {
+			log.Err(err).Str("webhook", h.Id).Msg("unable to create delivery id")
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for attempt := 0; ; attempt++ {
+				err := deliverWebhook(context.Background(), h, delivery, body)
+				if err == nil {
+					return
+				}
+				if attempt == len(webhookRetryDelays) {
+					log.Warn().Err(err).Str("webhook", h.Id).Str("delivery", delivery).Msg("webhook delivery failed")
+					return
+				}
+				time.Sleep(webhookRetryDelays[attempt])
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// webhookRequest is the body of the request that creates a webhook.
+type webhookRequest struct {
+	Url    string        `json:"url"`
+	Secret string        `json:"secret"`
+	Filter webhookFilter `json:"filter"`
+}
+
+func (r webhookRequest) validate(courses *unibo_integ.CoursesMap) (webhook, error) {
+	if len(r.Url) > maxWebhookUrlLength {
+		return webhook{}, fmt.Errorf("url is too long (max %d characters)", maxWebhookUrlLength)
+	}
+	u, err := url.Parse(r.Url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return webhook{}, fmt.Errorf("invalid url %q", r.Url)
+	}
+
+	if len(r.Secret) < minWebhookSecret || len(r.Secret) > maxWebhookSecret {
+		return webhook{}, fmt.Errorf("secret must be from %d to %d characters long", minWebhookSecret, maxWebhookSecret)
+	}
+
+	if err := r.Filter.validate(courses); err != nil {
+		return webhook{}, err
+	}
+
+	return webhook{Url: u.String(), Secret: r.Secret, Filter: r.Filter}, nil
+}
+
+// webhookResponse is the response of the webhooks API, without the secret.
+type webhookResponse struct {
+	Id      string        `json:"id"`
+	Url     string        `json:"url"`
+	Filter  webhookFilter `json:"filter"`
+	Created time.Time     `json:"created"`
+}
+
+func newWebhookResponse(h webhook) webhookResponse {
+	return webhookResponse{Id: h.Id, Url: h.Url, Filter: h.Filter, Created: h.Created}
+}
+
+// webhooksAvailable sends an error response and returns false if the
+// webhooks store was not opened.
+func webhooksAvailable(ctx *gin.Context) bool {
+	if webhooks == nil {
+		ctx.String(http.StatusServiceUnavailable, "Webhooks are not available")
+		return false
+	}
+	return true
+}
+
+// webhookError sends the response for an error of the webhooks store.
+func webhookError(ctx *gin.Context, err error) {
+	if errors.Is(err, errWebhookNotFound) {
+		ctx.String(http.StatusNotFound, "Webhook not found")
+	} else if errors.Is(err, errWrongSecret) {
+		ctx.String(http.StatusForbidden, "Wrong webhook secret")
+	} else {
+		_ = ctx.Error(err)
+		ctx.String(http.StatusInternalServerError, "Unable to get webhook")
+	}
+}
+
+func createWebhook(courses *unibo_integ.CoursesMap) func(c *gin.Context) {
+	return func(ctx *gin.Context) {
+		if !webhooksAvailable(ctx) {
+			return
+		}
+
+		var req webhookRequest
+		err := json.NewDecoder(ctx.Request.Body).Decode(&req)
+		if err != nil {
+			ctx.String(http.StatusBadRequest, "Invalid webhook")
+			return
+		}
+
+		h, err := req.validate(courses)
+		if err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		h, err = webhooks.create(h)
+		if errors.Is(err, errTooManyWebhooks) {
+			ctx.String(http.StatusConflict, "Too many webhooks for this course")
+			return
+		} else if err != nil {
+			_ = ctx.Error(err)
+			ctx.String(http.StatusInternalServerError, "Unable to create webhook")
+			return
+		}
+
+		ctx.JSON(http.StatusCreated, newWebhookResponse(h))
+	}
+}
+
+// getWebhook returns a webhook. Its secret is given in the
+// "X-Webhook-Secret" header.
+func getWebhook() func(c *gin.Context) {
+	return func(ctx *gin.Context) {
+		if !webhooksAvailable(ctx) {
+			return
+		}
+
+		h, err := webhooks.get(ctx.Param("id"), ctx.GetHeader("X-Webhook-Secret"))
+		if err != nil {
+			webhookError(ctx, err)
+			return
+		}
+
+		ctx.JSON(http.StatusOK, newWebhookResponse(h))
+	}
+}
+
+// deleteWebhook removes a webhook. Its secret is given in the
+// "X-Webhook-Secret" header.
+func deleteWebhook() func(c *gin.Context) {
+	return func(ctx *gin.Context) {
+		if !webhooksAvailable(ctx) {
+			return
+		}
+
+		err := webhooks.delete(ctx.Param("id"), ctx.GetHeader("X-Webhook-Secret"))
+		if err != nil {
+			webhookError(ctx, err)
+			return
+		}
+
+		ctx.Status(http.StatusNoContent)
+	}
+}
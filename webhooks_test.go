@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert/v2"
+
+	"github.com/VaiTon/unibocalendar/unibo_integ"
+)
+
+func Test_webhookRequest_validate(t *testing.T) {
+	courses := unibo_integ.CoursesMap{8009: {Codice: 8009, DurataAnni: 3}}
+	secret := strings.Repeat("s", minWebhookSecret)
+
+	tests := []struct {
+		name    string
+		req     webhookRequest
+		wantErr bool
+	}{
+		{"valid", webhookRequest{"https://example.com/hook", secret, webhookFilter{Course: 8009}}, false},
+		{"every filter", webhookRequest{"http://example.com/hook", secret, webhookFilter{
+			Course: 8009, Year: 2, Curriculum: "000-000", Subjects: []string{"1"}, Kinds: []changeKind{changeExamAdded},
+		}}, false},
+		{"invalid scheme", webhookRequest{"ftp://example.com/hook", secret, webhookFilter{Course: 8009}}, true},
+		{"relative url", webhookRequest{"/hook", secret, webhookFilter{Course: 8009}}, true},
+		{"short secret", webhookRequest{"https://example.com/hook", "secret", webhookFilter{Course: 8009}}, true},
+		{"unknown course", webhookRequest{"https://example.com/hook", secret, webhookFilter{Course: 1}}, true},
+		{"invalid year", webhookRequest{"https://example.com/hook", secret, webhookFilter{Course: 8009, Year: 4}}, true},
+		{"invalid kind", webhookRequest{"https://example.com/hook", secret, webhookFilter{Course: 8009, Kinds: []changeKind{"x"}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.req.validate(&courses)
+			assert.Equal(t, tt.wantErr, err != nil)
+		})
+	}
+}
+
+func Test_webhookFilter(t *testing.T) {
+	f := webhookFilter{Course: 8009, Year: 1, Subjects: []string{"1"}, Kinds: []changeKind{changeLessonMoved}}
+
+	assert.Equal(t, true, f.watches(8009, 1, "000-000"))
+	assert.Equal(t, false, f.watches(8009, 2, "000-000"))
+	assert.Equal(t, false, f.watches(8010, 1, "000-000"))
+	assert.Equal(t, true, webhookFilter{Course: 8009}.watches(8009, 3, "A"))
+	assert.Equal(t, false, webhookFilter{Course: 8009, Curriculum: "B"}.watches(8009, 3, "A"))
+
+	assert.Equal(t, true, f.matches(timetableChange{Kind: changeLessonMoved, SubjectCode: "1"}))
+	assert.Equal(t, false, f.matches(timetableChange{Kind: changeLessonAdded, SubjectCode: "1"}))
+	assert.Equal(t, false, f.matches(timetableChange{Kind: changeLessonMoved, SubjectCode: "2"}))
+}
+
+func Test_webhookClient(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer receiver.Close()
+
+	_, err := webhookClient.Post(receiver.URL, "application/json", nil)
+	assert.NotEqual(t, nil, err)
+	assert.Equal(t, true, strings.Contains(err.Error(), errPrivateAddress.Error()))
+
+	webhooksAllowPrivate = true
+	defer func() { webhooksAllowPrivate = false }()
+
+	res, err := webhookClient.Post(receiver.URL, "application/json", nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	_ = res.Body.Close()
+}
+
+func Test_notifyWebhooks(t *testing.T) {
+	store := &webhookStore{db: testDatabase(t)}
+
+	webhooks = store
+	defer func() { webhooks = nil }()
+
+	client, delays := webhookClient, webhookRetryDelays
+	defer func() { webhookClient, webhookRetryDelays = client, delays }()
+	webhookRetryDelays = []time.Duration{0}
+
+	type delivery struct {
+		header  http.Header
+		body    []byte
+		payload webhookPayload
+	}
+	received := make(chan delivery, 10)
+	failures := 1
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first delivery fails, so that it is retried
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		d := delivery{header: r.Header}
+		d.body, _ = io.ReadAll(r.Body)
+		assert.Equal(t, nil, json.Unmarshal(d.body, &d.payload))
+		received <- d
+	}))
+	defer receiver.Close()
+	webhookClient = receiver.Client()
+
+	secret := strings.Repeat("s", minWebhookSecret)
+	moved, err := store.create(webhook{Url: receiver.URL, Secret: secret, Filter: webhookFilter{Course: 8009, Kinds: []changeKind{changeLessonMoved}}})
+	assert.Equal(t, nil, err)
+	_, err = store.create(webhook{Url: receiver.URL, Secret: secret, Filter: webhookFilter{Course: 8009, Year: 2}})
+	assert.Equal(t, nil, err)
+	_, err = store.create(webhook{Url: receiver.URL, Secret: secret, Filter: webhookFilter{Course: 8010}})
+	assert.Equal(t, nil, err)
+
+	curr, err := store.create(webhook{Url: receiver.URL, Secret: secret, Filter: webhookFilter{Course: 8009, Curriculum: "000-001"}})
+	assert.Equal(t, nil, err)
+
+	now := time.Date(2025, 10, 20, 9, 0, 0, 0, time.UTC)
+	changes := timetableChanges{
+		Course:     8009,
+		Year:       1,
+		Curriculum: "000-000",
+		Detected:   now,
+		Changes: []timetableChange{
+			{Kind: changeLessonAdded, SubjectCode: "1", After: &changeSlot{Start: now, End: now}},
+			{Kind: changeLessonMoved, SubjectCode: "2", Before: &changeSlot{Start: now, End: now}, After: &changeSlot{Start: now, End: now}},
+		},
+	}
+	notifyWebhooks(changes, changes.Changes)
+
+	// Only the first webhook watches the first year
+	assert.Equal(t, 1, len(received))
+	d := <-received
+	assert.Equal(t, signPayload(secret, d.body), d.header.Get("X-AlmaCalendar-Signature"))
+	assert.Equal(t, webhookEvent, d.header.Get("X-AlmaCalendar-Event"))
+	assert.Equal(t, webhookEvent, d.payload.Event)
+	assert.Equal(t, moved.Id, d.payload.Webhook)
+	assert.Equal(t, 8009, d.payload.Course)
+	assert.Equal(t, 1, len(d.payload.Changes))
+	assert.Equal(t, changeLessonMoved, d.payload.Changes[0].Kind)
+
+	// The same changes found in another curriculum are sent only to the
+	// webhooks of that curriculum
+	changes.Curriculum = "000-001"
+	notifyWebhooks(changes, nil)
+	assert.Equal(t, 1, len(received))
+	d = <-received
+	assert.Equal(t, curr.Id, d.payload.Webhook)
+	assert.Equal(t, 2, len(d.payload.Changes))
+}
+
+func Test_webhooksApi(t *testing.T) {
+	store := &webhookStore{db: testDatabase(t)}
+
+	webhooks = store
+	defer func() { webhooks = nil }()
+
+	r := setupRouter(unibo_integ.CoursesMap{8009: {Codice: 8009, DurataAnni: 3}})
+	request := func(method, url, body string, header http.Header) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	secret := strings.Repeat("s", minWebhookSecret)
+
+	w := request(http.MethodPost, "/api/webhooks", `{"url": "https://example.com/hook", "secret": "short", "filter": {"course": 8009}}`, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = request(http.MethodPost, "/api/webhooks",
+		`{"url": "https://example.com/hook", "secret": "`+secret+`", "filter": {"course": 8009, "year": 1, "subjects": ["2", "1"]}}`, nil)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, false, strings.Contains(w.Body.String(), secret))
+
+	var created webhookResponse
+	assert.Equal(t, nil, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.NotEqual(t, "", created.Id)
+	assert.Equal(t, []string{"1", "2"}, created.Filter.Subjects)
+
+	w = request(http.MethodGet, "/api/webhooks/"+created.Id, "", http.Header{"X-Webhook-Secret": {"wrong"}})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = request(http.MethodGet, "/api/webhooks/"+created.Id, "", http.Header{"X-Webhook-Secret": {secret}})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = request(http.MethodDelete, "/api/webhooks/"+created.Id, "", http.Header{"X-Webhook-Secret": {secret}})
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = request(http.MethodGet, "/api/webhooks/"+created.Id, "", http.Header{"X-Webhook-Secret": {secret}})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	body := `{"url": "https://example.com/hook", "secret": "` + secret + `", "filter": {"course": 8009}}`
+	for i := 0; i < maxCourseWebhooks; i++ {
+		w = request(http.MethodPost, "/api/webhooks", body, nil)
+		assert.Equal(t, http.StatusCreated, w.Code)
+	}
+	w = request(http.MethodPost, "/api/webhooks", body, nil)
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/cartabinaria/unibo-go/timetable"
+	"github.com/gin-gonic/gin"
+	"github.com/jung-kurt/gofpdf"
+	"github.com/patrickmn/go-cache"
+
+	"github.com/VaiTon/unibocalendar/unibo_integ"
+)
+
+// Hours always shown by the week grid, extended if a lesson is outside them
+const (
+	weekFirstHour = 8
+	weekLastHour  = 19
+)
+
+var errInvalidWeek = errors.New("invalid week")
+
+// weekdayKeys are the catalogue keys of the names of the days, indexed by
+// time.Weekday.
+var weekdayKeys = []string{
+	"week.sunday", "week.monday", "week.tuesday", "week.wednesday", "week.thursday", "week.friday", "week.saturday",
+}
+
+// weekLesson is a lesson placed in the column of its day.
+type weekLesson struct {
+	Title    string
+	Teacher  string
+	Location string
+	Online   bool
+	Start    time.Time
+	End      time.Time
+	// Top and Height are the position of the lesson in the column, as
+	// percentages of its height
+	Top    float64
+	Height float64
+	// Lane is the position of the lesson among the Lanes lessons that overlap
+	// it, so that they can be drawn side by side
+	Lane  int
+	Lanes int
+}
+
+// Left returns the horizontal position of the lesson in the column, as a
+// percentage of its width.
+func (l weekLesson) Left() float64 {
+	return float64(l.Lane) * l.Width()
+}
+
+// Width returns the width of the lesson, as a percentage of the column.
+func (l weekLesson) Width() float64 {
+	return 100 / float64(l.Lanes)
+}
+
+type weekDay struct {
+	Date    time.Time
+	Lessons []weekLesson
+}
+
+// Label returns the name of the day followed by the date, e.g. "Lunedì 20/10".
+func (d weekDay) Label(lang string) string {
+	return tr(lang, weekdayKeys[d.Date.Weekday()]) + " " + d.Date.Format("02/01")
+}
+
+// weekGrid is the timetable of a week, one column for every day.
+type weekGrid struct {
+	// Start is the midnight of the Monday of the week
+	Start     time.Time
+	FirstHour int
+	LastHour  int
+	Days      []weekDay
+}
+
+// Hours returns the hours that start a row of the grid.
+func (g weekGrid) Hours() []int {
+	hours := make([]int, 0, g.LastHour-g.FirstHour)
+	for h := g.FirstHour; h < g.LastHour; h++ {
+		hours = append(hours, h)
+	}
+	return hours
+}
+
+// Empty reports whether there are no lessons in the week.
+func (g weekGrid) Empty() bool {
+	for _, d := range g.Days {
+		if len(d.Lessons) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// startOfWeek returns the midnight of the Monday of the week of t, in the
+// timezone of the timetables.
+func startOfWeek(t time.Time) time.Time {
+	t = t.In(romeLocation)
+	offset := (int(t.Weekday()) + 6) % 7
+	return time.Date(t.Year(), t.Month(), t.Day()-offset, 0, 0, 0, 0, romeLocation)
+}
+
+// parseWeek parses the "week" query parameter, any day of the week in
+// dateLayout. If it is empty, the current week is returned.
+func parseWeek(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return startOfWeek(now), nil
+	}
+
+	day, err := time.ParseInLocation(dateLayout, s, romeLocation)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %q", errInvalidWeek, s)
+	}
+	return startOfWeek(day), nil
+}
+
+// assignLanes sets the lane of lessons sorted by start, so that overlapping
+// lessons don't cover each other.
+func assignLanes(lessons []weekLesson) {
+	for i := 0; i < len(lessons); {
+		// Find the group of lessons that overlap each other, even indirectly
+		end := lessons[i].End
+		j := i + 1
+		for j < len(lessons) && lessons[j].Start.Before(end) {
+			if lessons[j].End.After(end) {
+				end = lessons[j].End
+			}
+			j++
+		}
+
+		var laneEnds []time.Time
+		for k := i; k < j; k++ {
+			lane := slices.IndexFunc(laneEnds, func(e time.Time) bool { return !e.After(lessons[k].Start) })
+			if lane == -1 {
+				lane = len(laneEnds)
+				laneEnds = append(laneEnds, time.Time{})
+			}
+			laneEnds[lane] = lessons[k].End
+			lessons[k].Lane = lane
+		}
+		for k := i; k < j; k++ {
+			lessons[k].Lanes = len(laneEnds)
+		}
+
+		i = j
+	}
+}
+
+// buildWeekGrid places the lessons of t in the week that starts at start.
+// Monday to Friday are always shown, the weekend only if it has lessons.
+func buildWeekGrid(t timetable.Timetable, start time.Time) weekGrid {
+	g := weekGrid{Start: start, FirstHour: weekFirstHour, LastHour: weekLastHour}
+
+	days := make([]weekDay, 7)
+	for i := range days {
+		days[i].Date = start.AddDate(0, 0, i)
+	}
+
+	for _, event := range t {
+		begin, end := event.Start.In(romeLocation), event.End.In(romeLocation)
+		i := int(begin.Sub(start).Hours() / 24)
+		if begin.Before(start) || i >= len(days) || !end.After(begin) {
+			continue
+		}
+
+		days[i].Lessons = append(days[i].Lessons, weekLesson{
+			Title:    event.Title,
+			Teacher:  event.Teacher,
+			Location: lessonLocation(event, locationShort),
+			Online:   lessonOnline(event),
+			Start:    begin,
+			End:      end,
+		})
+
+		g.FirstHour = min(g.FirstHour, begin.Hour())
+		lastHour := end.Hour()
+		if end.Minute() != 0 {
+			lastHour++
+		}
+		if end.Day() != begin.Day() {
+			lastHour = 24
+		}
+		g.LastHour = max(g.LastHour, lastHour)
+	}
+
+	shown := 5
+	if len(days[6].Lessons) != 0 {
+		shown = 7
+	} else if len(days[5].Lessons) != 0 {
+		shown = 6
+	}
+	g.Days = days[:shown]
+
+	minutes := float64(g.LastHour-g.FirstHour) * 60
+	for _, d := range g.Days {
+		slices.SortFunc(d.Lessons, func(a, b weekLesson) int {
+			if c := a.Start.Compare(b.Start); c != 0 {
+				return c
+			}
+			return a.End.Compare(b.End)
+		})
+		assignLanes(d.Lessons)
+
+		dayStart := d.Date.Add(time.Duration(g.FirstHour) * time.Hour)
+		for i := range d.Lessons {
+			l := &d.Lessons[i]
+			duration := math.Min(l.End.Sub(l.Start).Minutes(), minutes-l.Start.Sub(dayStart).Minutes())
+			l.Top = l.Start.Sub(dayStart).Minutes() / minutes * 100
+			l.Height = duration / minutes * 100
+		}
+	}
+
+	return g
+}
+
+// weekUrl returns the URL of the week grid of the selection, for the week
+// that starts at week. Without week, the current week is shown.
+func (s subjectSelection) weekUrl(week time.Time, pdf bool) string {
+	q := s.query()
+	q.Set("anno", fmt.Sprint(s.Year))
+	if !week.IsZero() {
+		q.Set("week", week.Format(dateLayout))
+	}
+
+	path := fmt.Sprintf("/courses/%d/week", s.Course.Codice)
+	if pdf {
+		path += ".pdf"
+	}
+	return path + encodeFeedQuery(q)
+}
+
+// weekTitle returns the title of the week grid, e.g. "Informatica - 1 anno".
+func (s subjectSelection) weekTitle() string {
+	title := tr(s.Lang, "cal.course.name", s.Course.Descrizione, s.Year)
+	if s.Curriculum.Label != "" {
+		title += " - " + s.Curriculum.Label
+	}
+	return title
+}
+
+// Sizes of the PDF, in millimeters
+const (
+	weekPdfMargin     = 10.0
+	weekPdfHourWidth  = 12.0
+	weekPdfTitle      = 10.0
+	weekPdfHeader     = 8.0
+	weekPdfLineHeight = 3.2
+)
+
+// writeWeekPdf draws the week grid on a landscape A4 page.
+func writeWeekPdf(w io.Writer, g weekGrid, title, lang string) error {
+	pdf := gofpdf.New("L", "mm", "A4", "")
+	pdf.SetTitle(title, true)
+	pdf.SetCreator("AlmaCalendar", true)
+	pdf.SetAutoPageBreak(false, 0)
+	pdf.SetMargins(weekPdfMargin, weekPdfMargin, weekPdfMargin)
+	pdf.AddPage()
+
+	// The core fonts use cp1252, that covers the Italian accented letters
+	text := pdf.UnicodeTranslatorFromDescriptor("")
+
+	pageW, pageH := pdf.GetPageSize()
+	end := g.Start.AddDate(0, 0, len(g.Days)-1)
+
+	pdf.SetFont("Helvetica", "B", 14)
+	pdf.CellFormat(0, weekPdfTitle, text(title), "", 0, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 10)
+	pdf.CellFormat(0, weekPdfTitle, text(tr(lang, "week.range", g.Start.Format("02/01/2006"), end.Format("02/01/2006"))),
+		"", 1, "R", false, 0, "")
+
+	gridX := weekPdfMargin + weekPdfHourWidth
+	gridY := weekPdfMargin + weekPdfTitle + weekPdfHeader
+	gridW := pageW - gridX - weekPdfMargin
+	gridH := pageH - gridY - weekPdfMargin
+	dayW := gridW / float64(len(g.Days))
+	hourH := gridH / float64(g.LastHour-g.FirstHour)
+
+	// Day names
+	pdf.SetFont("Helvetica", "B", 10)
+	for i, d := range g.Days {
+		pdf.SetXY(gridX+float64(i)*dayW, gridY-weekPdfHeader)
+		pdf.CellFormat(dayW, weekPdfHeader, text(d.Label(lang)), "", 0, "C", false, 0, "")
+	}
+
+	// Hour lines
+	pdf.SetFont("Helvetica", "", 8)
+	pdf.SetDrawColor(200, 200, 200)
+	for i, h := range g.Hours() {
+		y := gridY + float64(i)*hourH
+		pdf.Line(gridX, y, gridX+gridW, y)
+		pdf.SetXY(weekPdfMargin, y)
+		pdf.CellFormat(weekPdfHourWidth, weekPdfLineHeight, fmt.Sprintf("%02d:00", h), "", 0, "L", false, 0, "")
+	}
+	pdf.Rect(gridX, gridY, gridW, gridH, "D")
+	for i := 1; i < len(g.Days); i++ {
+		x := gridX + float64(i)*dayW
+		pdf.Line(x, gridY, x, gridY+gridH)
+	}
+
+	// Lessons
+	pdf.SetDrawColor(151, 19, 39)
+	for i, d := range g.Days {
+		for _, l := range d.Lessons {
+			laneW := l.Width() / 100 * dayW
+			x := gridX + float64(i)*dayW + l.Left()/100*dayW
+			y := gridY + l.Top/100*gridH
+			h := l.Height / 100 * gridH
+
+			if l.Online {
+				pdf.SetFillColor(220, 234, 242)
+			} else {
+				pdf.SetFillColor(245, 222, 225)
+			}
+			pdf.Rect(x+0.3, y+0.3, laneW-0.6, h-0.6, "FD")
+
+			pdf.ClipRect(x+0.3, y+0.3, laneW-0.6, h-0.6, false)
+			pdf.SetXY(x+0.8, y+0.8)
+			pdf.SetFont("Helvetica", "", 7)
+			pdf.CellFormat(laneW-1.6, weekPdfLineHeight, l.Start.Format("15:04")+"-"+l.End.Format("15:04"),
+				"", 2, "L", false, 0, "")
+			pdf.SetFont("Helvetica", "B", 7)
+			pdf.MultiCell(laneW-1.6, weekPdfLineHeight, text(l.Title), "", "L", false)
+			pdf.SetFont("Helvetica", "", 7)
+			if l.Location != "" {
+				pdf.SetX(x + 0.8)
+				pdf.MultiCell(laneW-1.6, weekPdfLineHeight, text(l.Location), "", "L", false)
+			} else if l.Online {
+				pdf.SetX(x + 0.8)
+				pdf.MultiCell(laneW-1.6, weekPdfLineHeight, text(tr(lang, "cal.lesson.online")), "", "L", false)
+			}
+			pdf.ClipEnd()
+		}
+	}
+
+	return pdf.Output(w)
+}
+
+// selectionWeek returns the lessons of the selection in the week that starts
+// at week, from the same cached timetable used for the subjects.
+func selectionWeek(sel subjectSelection, week time.Time) (weekGrid, error) {
+	t, err := getCachedTimetable(sel.Course, sel.Year, sel.Curriculum)
+	if err != nil {
+		return weekGrid{}, err
+	}
+
+	if codes := sel.codes(); len(codes) != 0 {
+		t = filterTimetableBySubjects(t, codes)
+	}
+	return buildWeekGrid(t, week), nil
+}
+
+func weekHandler(courses unibo_integ.CoursesMap, pdf bool) func(c *gin.Context) {
+	return func(ctx *gin.Context) {
+		sel, ok := parseSubjectSelection(ctx, courses)
+		if !ok {
+			return
+		}
+
+		week, err := parseWeek(ctx.Query("week"), time.Now())
+		if err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		grid, err := selectionWeek(sel, week)
+		if err != nil {
+			_ = ctx.Error(err)
+			ctx.String(http.StatusInternalServerError, "Unable to retrieve timetable")
+			return
+		}
+
+		if pdf {
+			ctx.Header("Content-Disposition",
+				fmt.Sprintf(`inline; filename="%d-%d-%s.pdf"`, sel.Course.Codice, sel.Year, week.Format(dateLayout)))
+
+			cacheKey := "week-" + sel.weekUrl(week, true)
+			if buf, found := calcache.Get(cacheKey); found {
+				ctx.Data(http.StatusOK, "application/pdf", buf.(*bytes.Buffer).Bytes())
+				return
+			}
+
+			buf := bytes.NewBuffer(nil)
+			err = writeWeekPdf(buf, grid, sel.weekTitle(), sel.Lang)
+			if err != nil {
+				_ = ctx.Error(err)
+				ctx.String(http.StatusInternalServerError, "Unable to create PDF")
+				return
+			}
+			calcache.Set(cacheKey, buf, cache.DefaultExpiration)
+
+			ctx.Data(http.StatusOK, "application/pdf", buf.Bytes())
+			return
+		}
+
+		ctx.HTML(http.StatusOK, "week", gin.H{
+			"Title":     sel.weekTitle(),
+			"Selection": sel,
+			"Grid":      grid,
+			"End":       week.AddDate(0, 0, len(grid.Days)-1),
+			"Prev":      sel.weekUrl(week.AddDate(0, 0, -7), false),
+			"Next":      sel.weekUrl(week.AddDate(0, 0, 7), false),
+			"Current":   sel.weekUrl(time.Time{}, false),
+			"Pdf":       sel.weekUrl(week, true),
+			"Lang":      sel.Lang,
+		})
+	}
+}
+
+// weekPage shows the lessons of a subject selection in a printable week
+// grid. The selection is parsed by parseSubjectSelection, the week by
+// parseWeek.
+func weekPage(courses unibo_integ.CoursesMap) func(c *gin.Context) {
+	return weekHandler(courses, false)
+}
+
+// getWeekPdf returns the week grid of weekPage as a PDF.
+func getWeekPdf(courses unibo_integ.CoursesMap) func(c *gin.Context) {
+	return weekHandler(courses, true)
+}
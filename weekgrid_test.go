@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/cartabinaria/unibo-go/curriculum"
+	"github.com/cartabinaria/unibo-go/timetable"
+	"github.com/go-playground/assert/v2"
+
+	"github.com/VaiTon/unibocalendar/unibo_integ"
+)
+
+func Test_parseWeek(t *testing.T) {
+	now := time.Date(2025, 10, 22, 18, 30, 0, 0, romeLocation)
+	monday := time.Date(2025, 10, 20, 0, 0, 0, 0, romeLocation)
+
+	tests := []struct {
+		week    string
+		want    time.Time
+		wantErr bool
+	}{
+		{"", monday, false},
+		{"2025-10-20", monday, false},
+		{"2025-10-26", monday, false},
+		{"2025-10-27", monday.AddDate(0, 0, 7), false},
+		{"2025-01-01", time.Date(2024, 12, 30, 0, 0, 0, 0, romeLocation), false},
+		{"20/10/2025", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.week, func(t *testing.T) {
+			got, err := parseWeek(tt.week, now)
+			assert.Equal(t, tt.wantErr, err != nil)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_assignLanes(t *testing.T) {
+	day := time.Date(2025, 10, 20, 0, 0, 0, 0, romeLocation)
+	lesson := func(from, to int) weekLesson {
+		return weekLesson{Start: day.Add(time.Duration(from) * time.Hour), End: day.Add(time.Duration(to) * time.Hour)}
+	}
+
+	// 9-11 and 10-12 overlap, 11-13 reuses the first lane, 14-15 is alone
+	lessons := []weekLesson{lesson(9, 11), lesson(10, 12), lesson(11, 13), lesson(14, 15)}
+	assignLanes(lessons)
+
+	lanes := make([][2]int, 0, len(lessons))
+	for _, l := range lessons {
+		lanes = append(lanes, [2]int{l.Lane, l.Lanes})
+	}
+	assert.Equal(t, [][2]int{{0, 2}, {1, 2}, {0, 2}, {0, 1}}, lanes)
+	assert.Equal(t, 50.0, lessons[1].Left())
+	assert.Equal(t, 50.0, lessons[1].Width())
+}
+
+func Test_buildWeekGrid(t *testing.T) {
+	monday := time.Date(2025, 10, 20, 0, 0, 0, 0, romeLocation)
+	event := func(day, from, to int, title string) timetable.Event {
+		start := monday.AddDate(0, 0, day)
+		return timetable.Event{
+			Title: title,
+			Start: timetable.CalendarTime{Time: start.Add(time.Duration(from) * time.Minute)},
+			End:   timetable.CalendarTime{Time: start.Add(time.Duration(to) * time.Minute)},
+		}
+	}
+
+	g := buildWeekGrid(timetable.Timetable{
+		event(1, 11*60, 13*60, "Analisi"),
+		event(1, 9*60, 11*60, "Algebra"),
+		event(5, 7*60+30, 9*60, "Laboratorio"),
+		event(7, 9*60, 11*60, "Next week"),
+		event(-1, 9*60, 11*60, "Last week"),
+	}, monday)
+
+	assert.Equal(t, 6, len(g.Days))
+	assert.Equal(t, 7, g.FirstHour)
+	assert.Equal(t, weekLastHour, g.LastHour)
+	assert.Equal(t, false, g.Empty())
+
+	// Positions are percentages of the 12 hours from 7 to 19
+	minutes := 720.0
+	tuesday := g.Days[1].Lessons
+	assert.Equal(t, 2, len(tuesday))
+	assert.Equal(t, "Algebra", tuesday[0].Title)
+	assert.Equal(t, 1, tuesday[0].Lanes)
+	assert.Equal(t, 120/minutes*100, tuesday[0].Top)
+	assert.Equal(t, 120/minutes*100, tuesday[0].Height)
+	assert.Equal(t, "Tuesday 21/10", g.Days[1].Label(langEn))
+
+	assert.Equal(t, "Laboratorio", g.Days[5].Lessons[0].Title)
+	assert.Equal(t, 30/minutes*100, g.Days[5].Lessons[0].Top)
+
+	empty := buildWeekGrid(nil, monday)
+	assert.Equal(t, 5, len(empty.Days))
+	assert.Equal(t, true, empty.Empty())
+	assert.Equal(t, []int{8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18}, empty.Hours())
+}
+
+func Test_subjectSelection_weekUrl(t *testing.T) {
+	sel := subjectSelection{
+		Course:     &unibo_integ.Course{Codice: 8009, Descrizione: "Informatica"},
+		Year:       2,
+		Curriculum: curriculum.Curriculum{Value: "000-000"},
+		Subjects:   []timetable.SimpleSubject{{Code: "00013"}, {Code: "04642"}},
+		Lang:       langIt,
+	}
+	week := time.Date(2025, 10, 20, 0, 0, 0, 0, romeLocation)
+
+	assert.Equal(t, "/courses/8009/week?anno=2&curr=000-000&lang=it&subjects=00013,04642&week=2025-10-20", sel.weekUrl(week, false))
+	assert.Equal(t, "/courses/8009/week.pdf?anno=2&curr=000-000&lang=it&subjects=00013,04642&week=2025-10-20", sel.weekUrl(week, true))
+	assert.Equal(t, "/courses/8009/week?anno=2&curr=000-000&lang=it&subjects=00013,04642", sel.weekUrl(time.Time{}, false))
+}
+
+func Test_writeWeekPdf(t *testing.T) {
+	monday := time.Date(2025, 10, 20, 0, 0, 0, 0, romeLocation)
+	g := buildWeekGrid(timetable.Timetable{{
+		Title:   "Programmazione ad oggetti",
+		Teacher: "Mario Rossi",
+		Start:   timetable.CalendarTime{Time: monday.Add(9 * time.Hour)},
+		End:     timetable.CalendarTime{Time: monday.Add(12 * time.Hour)},
+	}}, monday)
+
+	var buf bytes.Buffer
+	err := writeWeekPdf(&buf, g, "Informatica - 1 anno", langIt)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, bytes.HasPrefix(buf.Bytes(), []byte("%PDF-")))
+}